@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// withAccessLog wraps h to log each request (at info level), with the
+// method, path, client address, status and how long it took (see
+// -access-log). Event streams are logged when they're opened and again
+// when they're closed, so the log shows how long clients stay connected.
+// If enabled isn't set, h is returned untouched.
+func withAccessLog(h http.Handler, enabled bool) http.Handler {
+	if !enabled {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lw := &accessLogWriter{ResponseWriter: w, r: r, start: time.Now()}
+		h.ServeHTTP(lw, r)
+		if lw.status == 0 {
+			// nothing was written at all
+			lw.status = http.StatusOK
+		}
+		elapsed := time.Since(lw.start).Round(time.Millisecond)
+		if lw.stream {
+			infof("%s %s %s: stream closed after %s", r.RemoteAddr, r.Method, r.URL.RequestURI(), elapsed)
+			return
+		}
+		infof("%s %s %s: %d in %s", r.RemoteAddr, r.Method, r.URL.RequestURI(), lw.status, elapsed)
+	})
+}
+
+// accessLogWriter keeps track of the status sent back, and spots event
+// streams being opened
+type accessLogWriter struct {
+	http.ResponseWriter
+	r      *http.Request
+	start  time.Time
+	status int
+	stream bool // set if the response is an event stream
+}
+
+func (lw *accessLogWriter) WriteHeader(code int) {
+	if lw.status == 0 {
+		lw.status = code
+		ct := lw.Header().Get("Content-Type")
+		if code == http.StatusOK && strings.HasPrefix(ct, "text/event-stream") {
+			lw.stream = true
+			infof("%s %s %s: stream opened", lw.r.RemoteAddr, lw.r.Method, lw.r.URL.RequestURI())
+		}
+	}
+	lw.ResponseWriter.WriteHeader(code)
+}
+
+func (lw *accessLogWriter) Write(p []byte) (int, error) {
+	if lw.status == 0 {
+		lw.WriteHeader(http.StatusOK)
+	}
+	return lw.ResponseWriter.Write(p)
+}
+
+func (lw *accessLogWriter) Flush() {
+	if f, ok := lw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify is passed through for the eventsource server (see
+// heartbeatWriter)
+func (lw *accessLogWriter) CloseNotify() <-chan bool {
+	if cn, ok := lw.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
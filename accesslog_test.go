@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestAccessLog(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	defer func(threshold logLevel) {
+		log.SetOutput(os.Stderr)
+		logThreshold = threshold
+	}(logThreshold)
+	logThreshold = levelInfo
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/stream":
+			w.Header().Set("Content-Type", "text/event-stream")
+			w.Write([]byte("data: hello\n\n"))
+		case "/quiet":
+		default:
+			http.Error(w, "no", http.StatusTeapot)
+		}
+	})
+	for _, test := range []struct {
+		target string
+		want   []string
+	}{
+		{"/teapot?x=1", []string{"GET /teapot?x=1: 418 in "}},
+		// (nothing written is a 200)
+		{"/quiet", []string{"GET /quiet: 200 in "}},
+		{"/stream", []string{"GET /stream: stream opened", "GET /stream: stream closed after "}},
+	} {
+		out.Reset()
+		r := httptest.NewRequest("GET", test.target, nil)
+		withAccessLog(h, true).ServeHTTP(httptest.NewRecorder(), r)
+		for _, want := range test.want {
+			if !strings.Contains(out.String(), r.RemoteAddr+" "+want) {
+				t.Errorf("%s: no %q in %q", test.target, want, out.String())
+			}
+		}
+	}
+
+	out.Reset()
+	withAccessLog(h, false).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/teapot", nil))
+	if out.Len() != 0 {
+		t.Errorf("logged %q with the access log off", out.String())
+	}
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// number of releases returned by the api if no limit is given
+const defaultQueryLimit = 100
+
+// parseQueryOptions builds QueryOptions from the url query params "source",
+// "since" (RFC3339), "limit", "lang", "tag" and "after" (a cursor, see
+// writePage).
+func parseQueryOptions(params url.Values) (QueryOptions, error) {
+	opts := QueryOptions{
+		Source: params.Get("source"),
+		Tag:    strings.TrimSpace(params.Get("tag")),
+		Limit:  defaultQueryLimit,
+	}
+	if s := params.Get("lang"); s != "" {
+		opts.Lang = normaliseLang(s)
+		if opts.Lang == "" {
+			return opts, errors.New("bad lang")
+		}
+	}
+	if s := params.Get("since"); s != "" {
+		since, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return opts, errors.New("bad since (expected RFC3339 time)")
+		}
+		opts.Since = since
+	}
+	if s := params.Get("limit"); s != "" {
+		limit, err := strconv.Atoi(s)
+		if err != nil || limit < 1 {
+			return opts, errors.New("bad limit")
+		}
+		opts.Limit = limit
+	}
+	if s := params.Get("after"); s != "" {
+		id, err := decodeCursor(s)
+		if err != nil {
+			return opts, err
+		}
+		opts.AfterID = id
+	}
+	return opts, nil
+}
+
+// cursors for paging through results (the "after" param) are opaque to
+// clients, but really just wrap up the id of the last press release seen
+var errBadCursor = errors.New("bad after (expected a next_cursor)")
+
+func encodeCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte("id:" + strconv.Itoa(id)))
+}
+
+func decodeCursor(cursor string) (int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(raw), "id:") {
+		return 0, errBadCursor
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(string(raw), "id:"))
+	if err != nil || id < 1 {
+		return 0, errBadCursor
+	}
+	return id, nil
+}
+
+// releasePage is the json for a page of press releases
+type releasePage struct {
+	Releases []*PressRelease `json:"releases"`
+	// for the after param, to get the next page (if there is one)
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// writePage sends back a page of press releases as json (a releasePage).
+// One more than opts.Limit should have been asked for: if it turned up,
+// there's another page, and a cursor for it goes in next_cursor, and the
+// X-Next-Cursor header (and a Link header, with the url for it).
+func writePage(w http.ResponseWriter, r *http.Request, pressReleases []*PressRelease, opts QueryOptions) {
+	page := releasePage{Releases: pressReleases}
+	if page.Releases == nil {
+		page.Releases = []*PressRelease{}
+	}
+	if opts.Limit > 0 && len(pressReleases) > opts.Limit {
+		page.Releases = pressReleases[:opts.Limit]
+		page.NextCursor = encodeCursor(page.Releases[len(page.Releases)-1].id)
+		params := r.URL.Query()
+		params.Set("after", page.NextCursor)
+		next := url.URL{Path: r.URL.Path, RawQuery: params.Encode()}
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+		w.Header().Set("Link", "<"+next.String()+`>; rel="next"`)
+	}
+	writeJSON(w, page)
+}
+
+// writeJSON sends v back to the client as a json response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		errorf("encoding json: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
+
+// releasesHandler serves up stored press releases as json, for browsing
+// the archive (see parseQueryOptions for the supported query params)
+func releasesHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := parseQueryOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// (one extra, to tell if there's another page)
+		page := opts
+		page.Limit++
+		pressReleases, err := store.Query(page)
+		if err != nil {
+			errorf("querying store: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		writePage(w, r, pressReleases, opts)
+	}
+}
+
+// countHandler serves up the number of stored press releases as json, eg
+// {"count": 123}. The params are as for releasesHandler (bar limit).
+func countHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts, err := parseQueryOptions(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n, err := store.Count(opts)
+		if err != nil {
+			errorf("counting releases: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]int{"count": n})
+	}
+}
+
+// most releases /api/releases/latest will return at once
+const maxLatest = 50
+
+// latestHandler serves up the newest few press releases from a source as
+// json, for simple polling clients: "source" (a source name, or all if
+// not given) and "n", the number wanted (default 1, up to maxLatest).
+// Unknown sources get a 404.
+func latestHandler(store Store, live *liveScrapers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		source := params.Get("source")
+		if source == allChannel {
+			source = ""
+		}
+		if _, ok := live.current()[source]; source != "" && !ok {
+			http.Error(w, "unknown source", http.StatusNotFound)
+			return
+		}
+		n := 1
+		if s := params.Get("n"); s != "" {
+			var err error
+			n, err = strconv.Atoi(s)
+			if err != nil || n < 1 {
+				http.Error(w, "bad n", http.StatusBadRequest)
+				return
+			}
+			if n > maxLatest {
+				n = maxLatest
+			}
+		}
+		// (Query returns the most recently stashed first)
+		pressReleases, err := store.Query(QueryOptions{Source: source, Limit: n})
+		if err != nil {
+			errorf("querying store: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, pressReleases)
+	}
+}
+
+// searchHandler serves up press releases matching a search query (the "q"
+// param) as json. The other params are as for releasesHandler.
+func searchHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		q := params.Get("q")
+		if q == "" {
+			http.Error(w, "missing q", http.StatusBadRequest)
+			return
+		}
+		opts, err := parseQueryOptions(params)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		page := opts
+		page.Limit++
+		pressReleases, err := store.Search(q, page)
+		if err == errNoSearch {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		}
+		if err != nil {
+			errorf("searching store: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		writePage(w, r, pressReleases, opts)
+	}
+}
+
+// sourceInfo describes a source, for sourcesHandler
+type sourceInfo struct {
+	Name        string     `json:"name"`
+	DisplayName string     `json:"display_name"`
+	BaseURL     string     `json:"base_url,omitempty"`
+	LastScrape  *time.Time `json:"last_scrape,omitempty"` // last successful one
+	Count       int        `json:"count"`                 // number of releases in the store
+}
+
+// sourcesHandler lists the available sources as json, sorted by name
+func sourcesHandler(store Store, live *liveScrapers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scrapers := live.current()
+		counts, err := store.SourceCounts()
+		if err != nil {
+			errorf("counting releases: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		sources := []sourceInfo{}
+		for name, scraper := range scrapers {
+			meta := scraperMeta(scraper)
+			info := sourceInfo{Name: name, DisplayName: meta.DisplayName, BaseURL: meta.BaseURL, Count: counts[name]}
+			if t, ok := scrapeStatus.lastSuccessAt(name); ok {
+				info.LastScrape = &t
+			}
+			sources = append(sources, info)
+		}
+		sort.Slice(sources, func(i, j int) bool { return sources[i].Name < sources[j].Name })
+		writeJSON(w, sources)
+	}
+}
+
+// errorsHandler serves up the recent scraping problems as json, most
+// recent first. The "source" param picks out a single source.
+func errorsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, scrapeProblems.recent(r.URL.Query().Get("source")))
+}
+
+// releaseHandler serves up a single press release as json, from urls of the
+// form /api/releases/<source>/<id> (source can be "all").
+func releaseHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/releases/"), "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			http.NotFound(w, r)
+			return
+		}
+		pr, err := store.Get(parts[0], parts[1])
+		switch err {
+		case nil:
+		case errNotFound:
+			http.NotFound(w, r)
+			return
+		case errBadId:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		default:
+			errorf("fetching %s/%s: %s", parts[0], parts[1], err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, pr)
+	}
+}
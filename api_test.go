@@ -0,0 +1,311 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// getPage fetches a page of press releases from h
+func getPage(t *testing.T, h http.HandlerFunc, target string) (releasePage, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	h(w, httptest.NewRequest("GET", target, nil))
+	var page releasePage
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatalf("%s: %s", target, err)
+		}
+	}
+	return page, w
+}
+
+func TestReleasesCursorPaging(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		for i := 0; i < 25; i++ {
+			source := "tesco"
+			if i%3 == 0 {
+				source = "asda"
+			}
+			_, err := store.Stash(&PressRelease{Source: source, Permalink: fmt.Sprintf("http://example.com/%d", i), Title: fmt.Sprint(i), Content: "content"})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, test := range []struct {
+			source      string
+			limit, want int
+		}{
+			{"", 4, 25},
+			{"tesco", 4, 16},
+			{"asda", 1, 9},
+			{"", 100, 25},
+		} {
+			expected, err := store.Query(QueryOptions{Source: test.source})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(expected) != test.want {
+				t.Fatalf("got %d %q releases from Query, want %d", len(expected), test.source, test.want)
+			}
+
+			params := url.Values{"limit": {fmt.Sprint(test.limit)}}
+			if test.source != "" {
+				params.Set("source", test.source)
+			}
+			var got []*PressRelease
+			pages := 0
+			for {
+				page, w := getPage(t, releasesHandler(store), "/api/releases?"+params.Encode())
+				if w.Code != http.StatusOK {
+					t.Fatalf("%s: got %d", params.Encode(), w.Code)
+				}
+				got = append(got, page.Releases...)
+				pages++
+				if page.NextCursor == "" {
+					if w.Header().Get("X-Next-Cursor") != "" {
+						t.Errorf("X-Next-Cursor header without a next_cursor")
+					}
+					break
+				}
+				if h := w.Header().Get("X-Next-Cursor"); h != page.NextCursor {
+					t.Errorf("got X-Next-Cursor %q, next_cursor %q", h, page.NextCursor)
+				}
+				if len(page.Releases) != test.limit {
+					t.Errorf("got a short page (%d) before the last one", len(page.Releases))
+				}
+				params.Set("after", page.NextCursor)
+			}
+			// every one once, in order (no gaps or duplicates)
+			if len(got) != len(expected) {
+				t.Fatalf("source %q: paged through %d, want %d", test.source, len(got), len(expected))
+			}
+			for i := range got {
+				if got[i].Title != expected[i].Title {
+					t.Fatalf("source %q: release %d is %s, want %s", test.source, i, got[i].Title, expected[i].Title)
+				}
+			}
+			if wantPages := (test.want + test.limit - 1) / test.limit; pages != wantPages {
+				t.Errorf("source %q limit %d: got %d pages, want %d", test.source, test.limit, pages, wantPages)
+			}
+		}
+
+		if _, w := getPage(t, releasesHandler(store), "/api/releases?after=garbage!"); w.Code != http.StatusBadRequest {
+			t.Errorf("bad cursor: got %d, want %d", w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestReleases(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		bst := time.FixedZone("BST", 3600)
+		for _, pr := range []*PressRelease{
+			{Title: "old", Source: "tesco", Permalink: "http://example.com/1", PubDate: time.Date(2014, 1, 1, 0, 30, 0, 0, bst)},
+			{Title: "new", Source: "tesco", Permalink: "http://example.com/2", PubDate: time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC), Tags: []string{"food"}},
+			{Title: "asda", Source: "asda", Permalink: "http://example.com/3", PubDate: time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC)},
+		} {
+			if _, err := store.Stash(pr); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, test := range []struct {
+			query string
+			want  []string
+		}{
+			// (most recently stashed first)
+			{"", []string{"asda", "new", "old"}},
+			{"source=tesco", []string{"new", "old"}},
+			// "old" was really published at 23:30 UTC
+			{"source=tesco&since=2013-12-31T23:45:00Z", []string{"new"}},
+			{"source=tesco&since=2013-12-31T23:15:00Z", []string{"new", "old"}},
+			{"limit=1", []string{"asda"}},
+			{"source=sainsburys", nil},
+		} {
+			page, w := getPage(t, releasesHandler(store), "/api/releases?"+test.query)
+			if w.Code != http.StatusOK {
+				t.Errorf("%T %q: got %d", store, test.query, w.Code)
+				continue
+			}
+			var got []string
+			for _, pr := range page.Releases {
+				got = append(got, pr.Title)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("%T %q: got %v, want %v", store, test.query, got, test.want)
+			}
+		}
+
+		for _, query := range []string{"limit=x", "limit=0", "since=yesterday"} {
+			if _, w := getPage(t, releasesHandler(store), "/api/releases?"+query); w.Code != http.StatusBadRequest {
+				t.Errorf("%T %q: got %d, want %d", store, query, w.Code, http.StatusBadRequest)
+			}
+		}
+	}
+}
+
+func TestReleaseHandler(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		if _, err := store.Stash(&PressRelease{Source: "tesco", Permalink: "http://example.com/1", Title: "One"}); err != nil {
+			t.Fatal(err)
+		}
+		for _, test := range []struct {
+			path string
+			want int
+		}{
+			{"/api/releases/tesco/1", http.StatusOK},
+			{"/api/releases/all/1", http.StatusOK},
+			{"/api/releases/asda/1", http.StatusNotFound},
+			{"/api/releases/tesco/2", http.StatusNotFound},
+			{"/api/releases/tesco/x", http.StatusBadRequest},
+			{"/api/releases/tesco", http.StatusNotFound},
+			{"/api/releases/tesco/1/more", http.StatusNotFound},
+		} {
+			w := httptest.NewRecorder()
+			releaseHandler(store)(w, httptest.NewRequest("GET", test.path, nil))
+			if w.Code != test.want {
+				t.Errorf("%T %s: got %d, want %d", store, test.path, w.Code, test.want)
+				continue
+			}
+			if w.Code != http.StatusOK {
+				continue
+			}
+			var pr PressRelease
+			if err := json.Unmarshal(w.Body.Bytes(), &pr); err != nil {
+				t.Fatal(err)
+			}
+			if pr.Title != "One" || pr.Permalink != "http://example.com/1" {
+				t.Errorf("%T %s: got %+v", store, test.path, pr)
+			}
+		}
+	}
+}
+
+// metaScraper is a fakeScraper with some metadata
+type metaScraper struct {
+	fakeScraper
+	meta ScraperMeta
+}
+
+func (m *metaScraper) Meta() ScraperMeta { return m.meta }
+
+func TestSourcesHandler(t *testing.T) {
+	store := NewMemStore()
+	for i := 0; i < 2; i++ {
+		if _, err := store.Stash(&PressRelease{Source: "sources-b", Permalink: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	scrapeStatus.success("sources-b")
+	live := newLiveScrapers(map[string]Scraper{
+		"sources-b": &metaScraper{fakeScraper{"sources-b"}, ScraperMeta{DisplayName: "Source B", BaseURL: "http://b.example.com/"}},
+		"sources-a": &fakeScraper{"sources-a"},
+	})
+	w := httptest.NewRecorder()
+	sourcesHandler(store, live)(w, httptest.NewRequest("GET", "/api/sources", nil))
+	var sources []sourceInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &sources); err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 {
+		t.Fatalf("got %d sources, want 2", len(sources))
+	}
+	// (sorted by name, with the name standing in for a missing display name)
+	a, b := sources[0], sources[1]
+	if a.Name != "sources-a" || a.DisplayName != "sources-a" || a.Count != 0 || a.LastScrape != nil {
+		t.Errorf("got %+v", a)
+	}
+	if b.Name != "sources-b" || b.DisplayName != "Source B" || b.BaseURL != "http://b.example.com/" || b.Count != 2 || b.LastScrape == nil {
+		t.Errorf("got %+v", b)
+	}
+}
+
+func TestCountHandler(t *testing.T) {
+	store := NewMemStore()
+	for i, source := range []string{"tesco", "tesco", "asda"} {
+		pr := &PressRelease{Source: source, Permalink: fmt.Sprint(i), PubDate: time.Date(2014, time.Month(1+i), 1, 0, 0, 0, 0, time.UTC)}
+		if _, err := store.Stash(pr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, test := range []struct {
+		query      string
+		code, want int
+	}{
+		{"", http.StatusOK, 3},
+		{"source=tesco", http.StatusOK, 2},
+		{"source=tesco&since=2014-01-15T00:00:00Z", http.StatusOK, 1},
+		{"since=bad", http.StatusBadRequest, 0},
+	} {
+		w := httptest.NewRecorder()
+		countHandler(store)(w, httptest.NewRequest("GET", "/api/releases/count?"+test.query, nil))
+		if w.Code != test.code {
+			t.Errorf("%q: got %d, want %d", test.query, w.Code, test.code)
+			continue
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+		var got struct{ Count int }
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Count != test.want {
+			t.Errorf("%q: got count %d, want %d", test.query, got.Count, test.want)
+		}
+	}
+}
+
+func TestLatestHandler(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		for i := 1; i <= 120; i++ {
+			source := "tesco"
+			if i%2 == 0 {
+				source = "asda"
+			}
+			if _, err := store.Stash(&PressRelease{Title: fmt.Sprint(i), Source: source, Permalink: fmt.Sprint(i), PubDate: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		live := newLiveScrapers(map[string]Scraper{"tesco": &fakeScraper{"tesco"}, "asda": &fakeScraper{"asda"}})
+		for _, test := range []struct {
+			query       string
+			code        int
+			first, last string
+			n           int
+		}{
+			{"source=tesco", http.StatusOK, "119", "119", 1},
+			{"source=asda&n=3", http.StatusOK, "120", "116", 3},
+			{"n=1000", http.StatusOK, "120", "71", maxLatest},
+			{"source=all&n=2", http.StatusOK, "120", "119", 2},
+			{"source=sainsburys", http.StatusNotFound, "", "", 0},
+			{"n=0", http.StatusBadRequest, "", "", 0},
+			{"n=x", http.StatusBadRequest, "", "", 0},
+		} {
+			w := httptest.NewRecorder()
+			latestHandler(store, live)(w, httptest.NewRequest("GET", "/api/releases/latest?"+test.query, nil))
+			if w.Code != test.code {
+				t.Errorf("%T %q: got %d, want %d", store, test.query, w.Code, test.code)
+				continue
+			}
+			if w.Code != http.StatusOK {
+				continue
+			}
+			var got []*PressRelease
+			if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			var titles []string
+			for _, pr := range got {
+				titles = append(titles, pr.Title)
+			}
+			if len(titles) != test.n || titles[0] != test.first || titles[len(titles)-1] != test.last {
+				t.Errorf("%T %q: got %v, want %d from %s to %s", store, test.query, titles, test.n, test.first, test.last)
+			}
+		}
+	}
+}
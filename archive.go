@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// archiveHTML saves a copy of the raw html of a press release page under
+// dir, as <dir>/<source>/<sha256 of the html>.html, and returns the path.
+// Identical pages share a file, so re-archiving one is a no-op.
+func archiveHTML(dir, source, rawHTML string) (string, error) {
+	sum := sha256.Sum256([]byte(rawHTML))
+	path := filepath.Join(dir, source, hex.EncodeToString(sum[:])+".html")
+	return path, writeNewFile(path, []byte(rawHTML))
+}
+
+// dumpJSON writes a newly stashed press release out as json, to
+// <dir>/<source>/<id>.json (for -json-dir). An existing file is left alone.
+func dumpJSON(dir string, ev *pressReleaseEvent) error {
+	path := filepath.Join(dir, ev.payload.Source, strconv.Itoa(ev.id)+".json")
+	return writeNewFile(path, []byte(ev.Data()))
+}
+
+// writeNewFile writes data out to path (making any directories needed),
+// unless there's already something there. It goes to a temp file first,
+// so a half-written file is never left lying around under the real name.
+func writeNewFile(path string, data []byte) error {
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// rescrape re-runs a scraper over the archived html of the press releases
+// already in the store for it (see -archive-html), to rebuild them after
+// its selectors have been fixed up. Releases which come out differently
+// are updated in the store. Ones with no archived html (or which are split
+// over several pages, as only the first is archived) are left alone.
+// Returns the number of press releases updated.
+func rescrape(scraper Scraper, store Store) (int, error) {
+	const pageSize = 100
+	updated := 0
+	for offset := 0; ; offset += pageSize {
+		pressReleases, err := store.Query(QueryOptions{Source: scraper.Name(), Limit: pageSize, Offset: offset})
+		if err != nil {
+			return updated, err
+		}
+		for _, old := range pressReleases {
+			if old.RawHTMLPath == "" || len(old.URLs) > 1 {
+				continue
+			}
+			ev, err := store.Lookup(old.Source, old.Permalink)
+			if err != nil {
+				return updated, err
+			}
+			raw, err := ioutil.ReadFile(old.RawHTMLPath)
+			if err != nil {
+				warnf("%s: reading archived %s: %s", scraper.Name(), old.Permalink, err)
+				continue
+			}
+
+			pr := &PressRelease{
+				Title:       old.Title, // (in case the title selector misses)
+				Source:      old.Source,
+				Permalink:   old.Permalink,
+				URLs:        old.URLs,
+				FinalURL:    old.FinalURL,
+				PubDate:     old.PubDate,
+				RawHTMLPath: old.RawHTMLPath,
+			}
+			err = safeScrape(context.Background(), scraper, pr, string(raw))
+			if err != nil {
+				warnf("%s: rescraping %s: %s", scraper.Name(), old.Permalink, err)
+				continue
+			}
+			pr.complete = true
+			scrapeAll(context.Background(), scraper, []*PressRelease{pr}, 1)
+			if pr.ContentHash == old.ContentHash {
+				continue
+			}
+			_, err = store.Update(ev.id, pr)
+			if err != nil {
+				return updated, err
+			}
+			debugf("%s: rescraped %s", scraper.Name(), pr.Permalink)
+			updated++
+		}
+		if len(pressReleases) < pageSize {
+			return updated, nil
+		}
+	}
+}
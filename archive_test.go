@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/donovanhide/eventsource"
+)
+
+func TestArchiveHTML(t *testing.T) {
+	dir := t.TempDir()
+	path, err := archiveHTML(dir, "tesco", "<p>hello</p>")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Dir(path) != filepath.Join(dir, "tesco") || !strings.HasSuffix(path, ".html") {
+		t.Errorf("archived to %s", path)
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil || string(raw) != "<p>hello</p>" {
+		t.Errorf("got %q (%v)", raw, err)
+	}
+	// (the same page goes to the same file, a different one doesn't)
+	if again, err := archiveHTML(dir, "tesco", "<p>hello</p>"); err != nil || again != path {
+		t.Errorf("re-archived to %s (%v), want %s", again, err, path)
+	}
+	if other, err := archiveHTML(dir, "tesco", "<p>goodbye</p>"); err != nil || other == path {
+		t.Errorf("different page archived to %s (%v)", other, err)
+	}
+}
+
+// upperScraper is a listScraper which shouts
+type upperScraper struct{ listScraper }
+
+func (u *upperScraper) Scrape(pr *PressRelease, rawHTML string) error {
+	pr.Content = strings.ToUpper(rawHTML)
+	return nil
+}
+
+// Pages are archived as they're scraped, and can be scraped again from
+// the archive later.
+func TestRescrape(t *testing.T) {
+	dir := t.TempDir()
+	old := *archiveDir
+	*archiveDir = dir
+	t.Cleanup(func() { *archiveDir = old })
+	setFlag(t, minContent, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "page ", r.URL.Path)
+	}))
+	defer srv.Close()
+
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		scraper := &listScraper{fakeScraper{"archive"}, []*PressRelease{{Source: "archive", Permalink: srv.URL + "/1"}}}
+		doit(scraper, store, eventsource.NewServer())
+		ev, err := store.Lookup("archive", srv.URL+"/1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(ev.payload.RawHTMLPath, filepath.Join(dir, "archive")+"/") {
+			t.Fatalf("%T: archived to %q", store, ev.payload.RawHTMLPath)
+		}
+		raw, err := ioutil.ReadFile(ev.payload.RawHTMLPath)
+		if err != nil || string(raw) != "page /1" {
+			t.Errorf("%T: got %q (%v)", store, raw, err)
+		}
+
+		if n, err := rescrape(&upperScraper{*scraper}, store); err != nil || n != 1 {
+			t.Errorf("%T: rescraped %d (%v), want 1", store, n, err)
+		}
+		ev, err = store.Lookup("archive", srv.URL+"/1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.payload.Content != "PAGE /1" || ev.payload.RawHTMLPath == "" {
+			t.Errorf("%T: got %+v", store, ev.payload)
+		}
+		// (nothing changes the second time)
+		if n, err := rescrape(&upperScraper{*scraper}, store); err != nil || n != 0 {
+			t.Errorf("%T: rescraped %d again (%v), want 0", store, n, err)
+		}
+	}
+}
+
+// Newly stashed releases are written out to -json-dir, one file each.
+func TestJSONDir(t *testing.T) {
+	dir := t.TempDir()
+	old := *jsonDir
+	*jsonDir = dir
+	t.Cleanup(func() { *jsonDir = old })
+	setFlag(t, minContent, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "page ", r.URL.Path)
+	}))
+	defer srv.Close()
+
+	store := NewMemStore()
+	scraper := &listScraper{fakeScraper{"json-dir"}, []*PressRelease{{Source: "json-dir", Permalink: srv.URL + "/1"}, {Source: "json-dir", Permalink: srv.URL + "/2"}}}
+	doit(scraper, store, eventsource.NewServer())
+	files, err := filepath.Glob(filepath.Join(dir, "json-dir", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got files %v, want 2", files)
+	}
+	for _, file := range files {
+		raw, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var pr PressRelease
+		if err := json.Unmarshal(raw, &pr); err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+		stored, err := store.Get("json-dir", strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+		if pr.Permalink != stored.Permalink || pr.Content != "page "+strings.TrimPrefix(pr.Permalink, srv.URL) {
+			t.Errorf("%s: got %+v", file, pr)
+		}
+	}
+}
+
+func TestWriteNewFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a", "b", "1.json")
+	if err := writeNewFile(path, []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	// (an existing file is left alone)
+	if err := writeNewFile(path, []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	if raw, err := ioutil.ReadFile(path); err != nil || string(raw) != "first" {
+		t.Errorf("got %q (%v), want %q", raw, err, "first")
+	}
+	if left, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".tmp-*")); len(left) != 0 {
+		t.Errorf("temp files left behind: %v", left)
+	}
+}
@@ -16,6 +16,10 @@ func (scraper *AsdaScraper) Name() string {
 	return "asda"
 }
 
+func (scraper *AsdaScraper) Meta() ScraperMeta {
+	return ScraperMeta{DisplayName: "Asda", BaseURL: "http://your.asda.com/"}
+}
+
 // fetches a list of latest press releases from Asda
 func (scraper *AsdaScraper) FetchList() ([]*PressRelease, error) {
 	url := "http://your.asda.com/press-centre/"
@@ -28,5 +32,5 @@ func (scraper *AsdaScraper) Scrape(pr *PressRelease, raw_html string) error {
 	content := "#main .article-content .body"
 	cruft := ""
 	pubDate := "#main .article-content .posted-by"
-	return GenericScrape(scraper.Name(), pr, raw_html, title, content, cruft, pubDate)
+	return GenericScrape(scraper.Name(), pr, raw_html, []string{title}, []string{content}, cruft, []string{pubDate})
 }
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+// basicAuth gates access behind HTTP basic auth (see -auth-user and
+// -auth-pass). An empty user means no auth at all.
+// Cross-origin requests from cors (see -cors-origins) are told they can
+// send credentials, and their preflights are let through without any.
+type basicAuth struct {
+	user, pass string
+	cors       corsOrigins
+}
+
+// check returns true if the request has the right credentials
+func (auth basicAuth) check(r *http.Request) bool {
+	user, pass, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	// (compare both, so the time taken doesn't say which was wrong)
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(auth.user))
+	passOK := subtle.ConstantTimeCompare([]byte(pass), []byte(auth.pass))
+	return userOK&passOK == 1
+}
+
+// unguardedPaths are left open even with auth on, so load balancers and
+// metrics scrapers don't need the credentials. (/status, with its error
+// messages, still does.)
+var unguardedPaths = map[string]bool{
+	"/healthz": true,
+	"/metrics": true,
+}
+
+// wrap rejects requests to h which don't have the right credentials
+// (bar those for unguardedPaths).
+// If no user is configured, h is returned untouched.
+func (auth basicAuth) wrap(h http.Handler) http.Handler {
+	if auth.user == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if unguardedPaths[r.URL.Path] {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if origin := r.Header.Get("Origin"); origin != "" && auth.cors.allows(origin) {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if isPreflight(r) && len(auth.cors) > 0 {
+			// (answered here, so none of the handlers are reached
+			// without credentials)
+			auth.cors.preflight(w, r)
+			return
+		}
+		if !auth.check(r) {
+			w.Header().Set("WWW-Authenticate", `Basic realm="ukpr", charset="UTF-8"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var helloHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("hello"))
+})
+
+func TestBasicAuth(t *testing.T) {
+	h := basicAuth{user: "newsroom", pass: "s3cret"}.wrap(helloHandler)
+	tests := []struct {
+		name       string
+		user, pass string
+		send       bool
+		want       int
+	}{
+		{"correct", "newsroom", "s3cret", true, http.StatusOK},
+		{"wrong pass", "newsroom", "secret", true, http.StatusUnauthorized},
+		{"wrong user", "news", "s3cret", true, http.StatusUnauthorized},
+		{"missing", "", "", false, http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/api/releases", nil)
+		if test.send {
+			r.SetBasicAuth(test.user, test.pass)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, w.Code, test.want)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("%s: no WWW-Authenticate header", test.name)
+		}
+	}
+
+	// no user, no auth
+	w := httptest.NewRecorder()
+	basicAuth{}.wrap(helloHandler).ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("without auth: got %d, want %d", w.Code, http.StatusOK)
+	}
+}
+
+// Preflights come without credentials, so they mustn't need them.
+func TestBasicAuthCORS(t *testing.T) {
+	cors := parseCORSOrigins("https://example.com")
+	mux := http.NewServeMux()
+	mux.Handle("/api/releases", cors.wrap(helloHandler))
+	mux.Handle("/browse/", helloHandler)
+	h := basicAuth{user: "newsroom", pass: "s3cret", cors: cors}.wrap(mux)
+
+	request := func(method, path, origin string, creds bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, nil)
+		r.Header.Set("Origin", origin)
+		if method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		if creds {
+			r.SetBasicAuth("newsroom", "s3cret")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := request("OPTIONS", "/api/releases", "https://example.com", false)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("preflight: got %d, want %d", w.Code, http.StatusNoContent)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://example.com" {
+		t.Errorf("preflight: got Access-Control-Allow-Origin %q", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("preflight: got Access-Control-Allow-Credentials %q, want true", got)
+	}
+
+	// (and it doesn't get a handler to answer it without credentials)
+	w = request("OPTIONS", "/browse/", "https://example.com", false)
+	if w.Code != http.StatusNoContent || w.Body.String() == "hello" {
+		t.Errorf("preflight for /browse/: got %d %q", w.Code, w.Body.String())
+	}
+	w = request("OPTIONS", "/api/releases", "https://elsewhere.com", false)
+	if w.Code != http.StatusForbidden {
+		t.Errorf("preflight from elsewhere: got %d, want %d", w.Code, http.StatusForbidden)
+	}
+
+	// the request proper still needs them
+	if w = request("GET", "/api/releases", "https://example.com", false); w.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: got %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	w = request("GET", "/api/releases", "https://example.com", true)
+	if w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Fatalf("with credentials: got %d %q", w.Code, w.Body.String())
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Errorf("got Access-Control-Allow-Credentials %q, want true", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "https://example.com" {
+		t.Errorf("got Access-Control-Allow-Origin %q", got)
+	}
+	w = request("GET", "/api/releases", "https://elsewhere.com", true)
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "" {
+		t.Errorf("from elsewhere: got Access-Control-Allow-Credentials %q", got)
+	}
+}
+
+// Load balancers and metrics scrapers don't have the credentials.
+func TestBasicAuthMonitoring(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", healthzHandler)
+	mux.HandleFunc("/metrics", metricsHandler)
+	mux.Handle("/status", helloHandler)
+	mux.Handle("/healthz/", helloHandler)
+	h := basicAuth{user: "newsroom", pass: "s3cret"}.wrap(mux)
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/healthz", http.StatusOK},
+		{"/metrics", http.StatusOK},
+		{"/status", http.StatusUnauthorized},
+		// (only the exact paths)
+		{"/healthz/", http.StatusUnauthorized},
+		{"/healthz/../status", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", test.path, nil))
+		if w.Code != test.want {
+			t.Errorf("%s: got %d, want %d", test.path, w.Code, test.want)
+		}
+	}
+}
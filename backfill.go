@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+var errNoArchive = errors.New("no paginated archive to backfill from")
+
+// backfill goes back through the pages of a source's archives (see
+// ArchiveScraper), scraping and stashing all the press releases which
+// aren't already in the store. Stops after maxPages (0 for no limit), or
+// at the first page with nothing on it that hasn't turned up already.
+// Returns the number of press releases stashed.
+// Nothing is sent out to clients - it's for filling in the archive of a new
+// deployment, before the server starts.
+func backfill(scraper Scraper, store Store, maxPages int) (int, error) {
+	archive, ok := scraper.(ArchiveScraper)
+	if !ok {
+		return 0, errNoArchive
+	}
+	setRequestDelay(scraper, scraperMeta(scraper).BaseURL)
+
+	stashed := 0
+	seen := make(map[string]bool)
+	for page := 1; maxPages <= 0 || page <= maxPages; page++ {
+		listed, err := archive.FetchArchivePage(page)
+		if err != nil {
+			return stashed, err
+		}
+		// (some sites serve up the last page again for any page past
+		// the end)
+		var fresh []*PressRelease
+		for _, pr := range listed {
+			if !seen[pr.Permalink] {
+				seen[pr.Permalink] = true
+				fresh = append(fresh, pr)
+			}
+		}
+		if len(fresh) == 0 {
+			break
+		}
+		for _, pr := range fresh {
+			setRequestDelay(scraper, pr.Permalink)
+		}
+
+		pressReleases, err := store.WhichAreNew(fresh)
+		if err != nil {
+			return stashed, err
+		}
+		ok := scrapeAll(context.Background(), scraper, pressReleases, scrapeConcurrency(scraper))
+		n := 0
+		for i, pr := range pressReleases {
+			if ok[i] && stashNew(scraper, store, pr) != nil {
+				n++
+			}
+		}
+		stashed += n
+		infof("%s: backfill page %d: %d releases (%d new, %d stashed)", scraper.Name(), page, len(fresh), len(pressReleases), n)
+	}
+	return stashed, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// archiveScraper is a fakeScraper with a paginated archive
+type archiveScraper struct {
+	fakeScraper
+	pageURL string
+}
+
+func (a *archiveScraper) FetchArchivePage(page int) ([]*PressRelease, error) {
+	return GenericFetchArchivePage(a.name, a.pageURL, ".list a", page)
+}
+
+func TestBackfill(t *testing.T) {
+	setFlag(t, minContent, 0)
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		var page int
+		if _, err := fmt.Sscanf(r.URL.Path, "/page/%d", &page); err != nil {
+			fmt.Fprint(w, "<p>"+r.URL.Path+"</p>")
+			return
+		}
+		// three pages of two releases, with the last page repeated after
+		// that (as some sites do), and a link from the first on all of them
+		if page > 3 {
+			page = 3
+		}
+		fmt.Fprint(w, `<div class="list">`)
+		for i := 0; i < 2; i++ {
+			fmt.Fprintf(w, `<a href="/news/%d">Release %d</a>`, page*10+i, page*10+i)
+		}
+		fmt.Fprint(w, `<a href="/news/10">Release 10</a></div>`)
+	}))
+	defer srv.Close()
+	scraper := &archiveScraper{fakeScraper{"backfill"}, srv.URL + "/page/%d"}
+	store := NewMemStore()
+
+	n, err := backfill(scraper, store, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts, err := store.SourceCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 6 || counts["backfill"] != 6 {
+		t.Errorf("stashed %d (%d in the store), want 6", n, counts["backfill"])
+	}
+	// (it stops at the first page with nothing new)
+	for path, want := range map[string]int{"/page/1": 1, "/page/2": 1, "/page/3": 1, "/page/4": 1, "/page/5": 0, "/news/10": 1, "/news/31": 1} {
+		if hits[path] != want {
+			t.Errorf("%s fetched %d times, want %d", path, hits[path], want)
+		}
+	}
+
+	// again, no further than -backfill-pages: nothing new, and nothing
+	// already stored is fetched again
+	n, err = backfill(scraper, store, 2)
+	if err != nil || n != 0 {
+		t.Errorf("stashed %d (%v) the second time, want 0", n, err)
+	}
+	fetched := 0
+	for path, hit := range hits {
+		if strings.HasPrefix(path, "/news/") && hit != 1 {
+			t.Errorf("%s fetched %d times", path, hit)
+		}
+		if strings.HasPrefix(path, "/page/") {
+			fetched += hit
+		}
+	}
+	if fetched != 4+2 {
+		t.Errorf("fetched %d index pages in all, want 6", fetched)
+	}
+
+	if _, err := backfill(&fakeScraper{"no-archive"}, store, 0); err != errNoArchive {
+		t.Errorf("got %v, want %v", err, errNoArchive)
+	}
+}
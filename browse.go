@@ -0,0 +1,138 @@
+package main
+
+import (
+	htmltemplate "html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// number of releases shown per page when browsing
+const browsePageSize = 20
+
+var browseTmpl = htmltemplate.Must(htmltemplate.New("browse").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{if .Source}}{{.Source}} - {{end}}ukpr</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: 1em auto; }
+.release { border-bottom: 1px solid #ccc; padding: 0.5em 0; }
+.meta { color: #666; font-size: small; }
+</style>
+</head>
+<body>
+{{if .Source}}
+<p><a href="/browse/">&laquo; all sources</a></p>
+<h1>{{.Source}}</h1>
+{{range .Releases}}
+<div class="release">
+<h3><a href="{{.Permalink}}">{{.Title}}</a></h3>
+<div class="meta">{{.PubDate.Format "2 Jan 2006 15:04"}} - {{.Permalink}}</div>
+<p>{{.Snippet}}</p>
+</div>
+{{else}}
+<p>No press releases.</p>
+{{end}}
+<p>
+{{if .PrevPage}}<a href="?page={{.PrevPage}}">&laquo; newer</a>{{end}}
+{{if .NextPage}}<a href="?page={{.NextPage}}">older &raquo;</a>{{end}}
+</p>
+{{else}}
+<h1>ukpr</h1>
+<ul>
+{{range .Sources}}<li><a href="/browse/{{.Name}}">{{.Name}}</a> ({{.Count}})</li>
+{{end}}
+</ul>
+{{end}}
+</body>
+</html>
+`))
+
+type browseSource struct {
+	Name  string
+	Count int
+}
+
+type browseRelease struct {
+	Title     string
+	Permalink string
+	PubDate   time.Time
+	Snippet   string
+}
+
+type browsePage struct {
+	Sources  []browseSource
+	Source   string
+	Releases []browseRelease
+	PrevPage int
+	NextPage int
+}
+
+// browseHandler serves up a simple html interface for eyeballing the
+// stored press releases:
+// /browse/ lists the sources, /browse/<source>?page=N shows their releases
+func browseHandler(store Store, live *liveScrapers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sources := live.names()
+		var page browsePage
+		page.Source = strings.Trim(strings.TrimPrefix(r.URL.Path, "/browse/"), "/")
+		if page.Source == "" {
+			counts, err := store.SourceCounts()
+			if err != nil {
+				errorf("querying store: %s", err)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			for _, name := range sources {
+				page.Sources = append(page.Sources, browseSource{name, counts[name]})
+			}
+			sort.Slice(page.Sources, func(i, j int) bool { return page.Sources[i].Name < page.Sources[j].Name })
+		} else {
+			known := false
+			for _, name := range sources {
+				if name == page.Source {
+					known = true
+				}
+			}
+			if !known {
+				http.NotFound(w, r)
+				return
+			}
+
+			pageNum := 1
+			if s := r.URL.Query().Get("page"); s != "" {
+				n, err := strconv.Atoi(s)
+				if err != nil || n < 1 {
+					http.Error(w, "bad page", http.StatusBadRequest)
+					return
+				}
+				pageNum = n
+			}
+			// grab one extra, to tell if there's another page
+			opts := QueryOptions{Source: page.Source, Limit: browsePageSize + 1, Offset: (pageNum - 1) * browsePageSize}
+			pressReleases, err := store.Query(opts)
+			if err != nil {
+				errorf("querying store: %s", err)
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			if len(pressReleases) > browsePageSize {
+				pressReleases = pressReleases[:browsePageSize]
+				page.NextPage = pageNum + 1
+			}
+			page.PrevPage = pageNum - 1
+			for _, pr := range pressReleases {
+				page.Releases = append(page.Releases, browseRelease{pr.Title, pr.Permalink, pr.PubDate, excerpt(plainText(pr.Content))})
+			}
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		err := browseTmpl.Execute(w, page)
+		if err != nil {
+			errorf("rendering browse page: %s", err)
+		}
+	}
+}
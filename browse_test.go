@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestBrowse(t *testing.T) {
+	store := NewMemStore()
+	start := time.Date(2014, 3, 1, 9, 0, 0, 0, time.UTC)
+	for i := 0; i < 25; i++ {
+		_, err := store.Stash(&PressRelease{
+			Title:     fmt.Sprintf("Release <%d>", i),
+			Source:    "tesco",
+			Permalink: fmt.Sprintf("http://example.com/%d", i),
+			PubDate:   start.Add(time.Duration(i) * time.Hour),
+			Content:   "<p>hello <b>world</b></p>",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := browseHandler(store, newLiveScrapers(map[string]Scraper{"tesco": nil, "asda": nil}))
+	browse := func(target string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest("GET", target, nil))
+		return w
+	}
+
+	index := browse("/browse/").Body.String()
+	for _, want := range []string{"tesco</a> (25)", "asda</a> (0)"} {
+		if !strings.Contains(index, want) {
+			t.Errorf("index: no %q in %s", want, index)
+		}
+	}
+
+	// newest first, browsePageSize to a page
+	first := browse("/browse/tesco").Body.String()
+	for _, want := range []string{"Release &lt;24&gt;", "Release &lt;5&gt;", "hello world", "http://example.com/24", "?page=2"} {
+		if !strings.Contains(first, want) {
+			t.Errorf("page 1: no %q", want)
+		}
+	}
+	if strings.Contains(first, "Release &lt;4&gt;") {
+		t.Errorf("page 1: more than %d releases", browsePageSize)
+	}
+	second := browse("/browse/tesco?page=2").Body.String()
+	if n := strings.Count(second, "Release &lt;"); n != 5 {
+		t.Errorf("page 2: got %d releases, want 5", n)
+	}
+	if !strings.Contains(second, "Release &lt;0&gt;") || !strings.Contains(second, "?page=1") {
+		t.Errorf("page 2: %s", second)
+	}
+
+	if w := browse("/browse/sainsburys"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown source: got %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
+
+// The snippets are cut at a word, not part way through a character.
+func TestBrowseSnippet(t *testing.T) {
+	store := NewMemStore()
+	_, err := store.Stash(&PressRelease{
+		Title:     "Prices",
+		Source:    "tesco",
+		Permalink: "http://example.com/1",
+		PubDate:   time.Now(),
+		Content:   "<p>" + strings.Repeat("£1.99 – ", 100) + "</p>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	browseHandler(store, newLiveScrapers(map[string]Scraper{"tesco": nil}))(w, httptest.NewRequest("GET", "/browse/tesco", nil))
+	page := w.Body.String()
+	if !utf8.ValidString(page) {
+		t.Fatal("page isn't valid utf-8")
+	}
+	if !strings.Contains(page, "£1.99 – £1.99") || !strings.Contains(page, "£1.99…") {
+		t.Errorf("no snippet in %s", page)
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"code.google.com/p/cascadia"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Config holds scrapers defined in a config file (see -config), for
+// sources which don't need any special handling beyond a bunch of css
+// selectors. eg:
+//
+//	{
+//	  "scrapers": [
+//	    {
+//	      "name": "waitrose",
+//	      "url": "http://www.waitrose.presscentre.com/content/default.aspx?NewsAreaID=2",
+//	      "links": "#content .main .item h3 a",
+//	      "title": "#content h1",
+//	      "content": ["#content .main .bodyCopy", "#content .main .text"],
+//	      "pubdate": "#content .date_release",
+//	      "end_marker": "(?i)-\\s*ends\\s*-"
+//	    }
+//	  ]
+//	}
+type Config struct {
+	Scrapers []*ConfigScraper `json:"scrapers"`
+}
+
+// ConfigScraper is a generic selector-based scraper, defined in a config
+// file rather than in code. The selectors are as for ScrapeSpec (so title,
+// content and pubdate can be lists).
+type ConfigScraper struct {
+	ScraperName string       `json:"name"`
+	DisplayName string       `json:"display_name"`
+	URL         string       `json:"url"`   // the index page to look for links on
+	Links       string       `json:"links"` // selector for the links on the index page
+	Title       selectorList `json:"title"`
+	Content     selectorList `json:"content"`
+	Cruft       selectorList `json:"cruft"`
+	PubDate     selectorList `json:"pubdate"`
+	Image       string       `json:"image"`
+	Tags        string       `json:"tags"`
+	Author      string       `json:"author"`
+	EndMarker   string       `json:"end_marker"`   // a regexp
+	TitleSuffix string       `json:"title_suffix"` // a regexp, for the site name on the end of titles
+	// how often to poll (in seconds), if not the global -interval
+	IntervalSecs int `json:"interval"`
+	// politeness, if not the global -concurrency and -request-delay (in
+	// milliseconds)
+	Concurrency    int `json:"concurrency"`
+	RequestDelayMS int `json:"request_delay"`
+	// url template for the archive pages (for -backfill), with a %d for
+	// the page number
+	ArchiveURL string `json:"archive_url"`
+	// a selector and/or regexp for spotting the index page showing an error
+	// or "no results" (see OutageMarkers)
+	OutageSelector string `json:"outage_selector"`
+	OutageText     string `json:"outage_text"`
+	// selector for the date by each link on the index page, for indexes in
+	// date order (newest first), so polling can stop at the ones already
+	// stored (see GenericFetchListSince)
+	IndexDate string `json:"index_date"`
+}
+
+// selectorList is a list of candidate selectors, which can be given in the
+// config file as either a single string or a list of them
+type selectorList []string
+
+func (sl *selectorList) UnmarshalJSON(raw []byte) error {
+	var sel string
+	if err := json.Unmarshal(raw, &sel); err == nil {
+		*sl = nil
+		if sel != "" {
+			*sl = selectorList{sel}
+		}
+		return nil
+	}
+	var sels []string
+	if err := json.Unmarshal(raw, &sels); err != nil {
+		return errors.New("expected a selector, or a list of them")
+	}
+	*sl = sels
+	return nil
+}
+
+// loadConfig reads in and checks over a config file
+func loadConfig(filename string) (*Config, error) {
+	raw, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	err = json.Unmarshal(raw, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	seen := make(map[string]bool)
+	for i, scraper := range cfg.Scrapers {
+		err = scraper.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("%s: scraper %d: %s", filename, i+1, err)
+		}
+		if seen[scraper.ScraperName] {
+			return nil, fmt.Errorf("%s: scraper '%s' defined more than once", filename, scraper.ScraperName)
+		}
+		seen[scraper.ScraperName] = true
+	}
+	return &cfg, nil
+}
+
+// Validate makes sure all the required fields are there, and that the urls
+// and selectors are valid (so a typo doesn't bring things down mid-scrape).
+func (scraper *ConfigScraper) Validate() error {
+	if scraper.ScraperName == "" {
+		return errors.New("missing name")
+	}
+	required := map[string]string{
+		"url":     scraper.URL,
+		"links":   scraper.Links,
+		"title":   strings.Join(scraper.Title, ""),
+		"content": strings.Join(scraper.Content, ""),
+	}
+	for field, val := range required {
+		if val == "" {
+			return fmt.Errorf("%s: missing %s", scraper.ScraperName, field)
+		}
+	}
+	urls := map[string]string{
+		"url": scraper.URL,
+		// (with a page number in, as "%d" isn't a valid escape)
+		"archive_url": strings.Replace(scraper.ArchiveURL, "%d", "1", 1),
+	}
+	for field, val := range urls {
+		if val == "" {
+			continue
+		}
+		u, err := url.Parse(val)
+		if err != nil {
+			return fmt.Errorf("%s: bad %s: %s", scraper.ScraperName, field, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: bad %s: expected an absolute http(s) url", scraper.ScraperName, field)
+		}
+	}
+	selectors := map[string][]string{
+		"links":           {scraper.Links},
+		"title":           scraper.Title,
+		"content":         scraper.Content,
+		"cruft":           scraper.Cruft,
+		"pubdate":         scraper.PubDate,
+		"image":           {scraper.Image},
+		"tags":            {scraper.Tags},
+		"author":          {scraper.Author},
+		"outage_selector": {scraper.OutageSelector},
+		"index_date":      {scraper.IndexDate},
+	}
+	for field, sels := range selectors {
+		for _, sel := range sels {
+			if sel == "" {
+				if len(sels) > 1 {
+					return fmt.Errorf("%s: empty %s selector", scraper.ScraperName, field)
+				}
+				continue
+			}
+			if _, err := cascadia.Compile(sel); err != nil {
+				return fmt.Errorf("%s: bad %s selector: %s", scraper.ScraperName, field, err)
+			}
+		}
+	}
+	if scraper.IntervalSecs < 0 {
+		return fmt.Errorf("%s: bad interval", scraper.ScraperName)
+	}
+	if scraper.Concurrency < 0 {
+		return fmt.Errorf("%s: bad concurrency", scraper.ScraperName)
+	}
+	if scraper.RequestDelayMS < 0 {
+		return fmt.Errorf("%s: bad request_delay", scraper.ScraperName)
+	}
+	if scraper.ArchiveURL != "" && strings.Count(scraper.ArchiveURL, "%d") != 1 {
+		return fmt.Errorf("%s: bad archive_url (needs a %%d for the page number)", scraper.ScraperName)
+	}
+	if scraper.EndMarker != "" {
+		if _, err := compilePattern(scraper.EndMarker); err != nil {
+			return fmt.Errorf("%s: bad end_marker: %s", scraper.ScraperName, err)
+		}
+	}
+	if scraper.OutageText != "" {
+		if _, err := regexp.Compile(scraper.OutageText); err != nil {
+			return fmt.Errorf("%s: bad outage_text: %s", scraper.ScraperName, err)
+		}
+	}
+	if scraper.TitleSuffix != "" {
+		if _, err := compilePattern("(?:" + scraper.TitleSuffix + ")$"); err != nil {
+			return fmt.Errorf("%s: bad title_suffix: %s", scraper.ScraperName, err)
+		}
+	}
+	return nil
+}
+
+func (scraper *ConfigScraper) Name() string {
+	return scraper.ScraperName
+}
+
+// the base url is taken from the index page url
+func (scraper *ConfigScraper) Meta() ScraperMeta {
+	meta := ScraperMeta{DisplayName: scraper.DisplayName}
+	if u, err := url.Parse(scraper.URL); err == nil {
+		meta.BaseURL = u.Scheme + "://" + u.Host + "/"
+	}
+	return meta
+}
+
+func (scraper *ConfigScraper) Interval() time.Duration {
+	return time.Duration(scraper.IntervalSecs) * time.Second
+}
+
+func (scraper *ConfigScraper) Politeness() Politeness {
+	return Politeness{
+		Concurrency: scraper.Concurrency,
+		Delay:       time.Duration(scraper.RequestDelayMS) * time.Millisecond,
+	}
+}
+
+// fetches a list of latest press releases from the index page
+func (scraper *ConfigScraper) FetchList() ([]*PressRelease, error) {
+	markers := OutageMarkers{Selector: scraper.OutageSelector, Text: scraper.OutageText}
+	return GenericFetchListChecked(scraper.Name(), scraper.URL, scraper.Links, markers)
+}
+
+// fetches the latest press releases, stopping at the ones from before
+// since if there's an index_date
+func (scraper *ConfigScraper) FetchListSince(since time.Time) ([]*PressRelease, error) {
+	markers := OutageMarkers{Selector: scraper.OutageSelector, Text: scraper.OutageText}
+	return fetchLinks(scraper.Name(), scraper.URL, scraper.Links, true, markers, newWatermark(scraper.IndexDate, since))
+}
+
+// fetches a page of the archives, if there's an archive_url
+func (scraper *ConfigScraper) FetchArchivePage(page int) ([]*PressRelease, error) {
+	if scraper.ArchiveURL == "" {
+		return nil, errNoArchive
+	}
+	return GenericFetchArchivePage(scraper.Name(), scraper.ArchiveURL, scraper.Links, page)
+}
+
+func (scraper *ConfigScraper) Scrape(pr *PressRelease, raw_html string) error {
+	spec := ScrapeSpec{
+		Title:       scraper.Title,
+		Content:     scraper.Content,
+		Cruft:       scraper.Cruft,
+		PubDate:     scraper.PubDate,
+		Image:       scraper.Image,
+		Tags:        scraper.Tags,
+		Author:      scraper.Author,
+		EndMarker:   scraper.EndMarker,
+		TitleSuffix: scraper.TitleSuffix,
+	}
+	return spec.Scrape(scraper.Name(), pr, raw_html)
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes out a config file for a test
+func writeConfig(t *testing.T, config string) string {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(filename, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestLoadConfig(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/a/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<ul class="news"><li><a href="/a/1">1</a></li></ul>`)
+	})
+	mux.HandleFunc("/b/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<div id="list"><a href="p1">1</a><a href="p2">2</a></div>`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	cfg, err := loadConfig(writeConfig(t, fmt.Sprintf(`{"scrapers": [
+  {"name": "a", "url": "%s/a/", "links": ".news a", "title": "h1", "content": "#content"},
+  {"name": "b", "url": "%s/b/", "links": "#list a", "title": ["h2", "h1"], "content": "#content", "end_marker": "(?i)-\\s*stop\\s*-"}
+]}`, srv.URL, srv.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Scrapers) != 2 || cfg.Scrapers[0].Name() != "a" || cfg.Scrapers[1].Name() != "b" {
+		t.Fatalf("got scrapers %v", cfg.Scrapers)
+	}
+
+	for i, want := range [][]string{{srv.URL + "/a/1"}, {srv.URL + "/b/p1", srv.URL + "/b/p2"}} {
+		docs, err := cfg.Scrapers[i].FetchList()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, pr := range docs {
+			got = append(got, pr.Permalink)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: got links %v, want %v", cfg.Scrapers[i].Name(), got, want)
+		}
+	}
+
+	pr := &PressRelease{Permalink: srv.URL + "/b/p1"}
+	if err := cfg.Scrapers[1].Scrape(pr, `<h1>Title</h1><div id="content"><p>Body - STOP - notes</p></div>`); err != nil {
+		t.Fatal(err)
+	}
+	if pr.Title != "Title" || !strings.Contains(pr.Content, "Body") || !strings.Contains(pr.Notes, "notes") {
+		t.Errorf("got title %q, content %q, notes %q", pr.Title, pr.Content, pr.Notes)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	const good = `"name": "a", "url": "http://example.com/news", "links": "a", "title": "h1", "content": "#content"`
+	tests := []struct {
+		name, config, want string
+	}{
+		{"not json", `{"scrapers": [`, "unexpected end"},
+		{"no name", `{"scrapers": [{"url": "http://example.com/", "links": "a", "title": "h1", "content": "#c"}]}`, "missing name"},
+		{"no links", `{"scrapers": [{"name": "a", "url": "http://example.com/", "title": "h1", "content": "#c"}]}`, "missing links"},
+		{"relative url", `{"scrapers": [{"name": "a", "url": "/news", "links": "a", "title": "h1", "content": "#c"}]}`, "bad url"},
+		{"bad selector", `{"scrapers": [{"name": "a", "url": "http://example.com/", "links": "a[", "title": "h1", "content": "#c"}]}`, "bad links selector"},
+		{"bad selector list", `{"scrapers": [{` + good + `, "cruft": 7}]}`, "expected a selector"},
+		{"empty in a list", `{"scrapers": [{` + good + `, "pubdate": [".date", ""]}]}`, "empty pubdate selector"},
+		{"bad end marker", `{"scrapers": [{` + good + `, "end_marker": "(ends"}]}`, "bad end_marker"},
+		{"bad title suffix", `{"scrapers": [{` + good + `, "title_suffix": "( | Tesco"}]}`, "bad title_suffix"},
+		{"twice", `{"scrapers": [{` + good + `}, {` + good + `}]}`, "defined more than once"},
+	}
+	for _, test := range tests {
+		_, err := loadConfig(writeConfig(t, test.config))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want %q", test.name, err, test.want)
+		}
+	}
+	if _, err := loadConfig(writeConfig(t, `{"scrapers": [{`+good+`}]}`)); err != nil {
+		t.Errorf("good config: %s", err)
+	}
+}
+
+func TestSelectorList(t *testing.T) {
+	for _, test := range []struct {
+		raw  string
+		want []string
+	}{
+		{`".body"`, []string{".body"}},
+		{`[".body", "#content"]`, []string{".body", "#content"}},
+		{`""`, nil},
+	} {
+		var sl selectorList
+		if err := json.Unmarshal([]byte(test.raw), &sl); err != nil || fmt.Sprint([]string(sl)) != fmt.Sprint(test.want) {
+			t.Errorf("%s: got %v (%v), want %v", test.raw, sl, err, test.want)
+		}
+	}
+	var sl selectorList
+	if err := json.Unmarshal([]byte(`3`), &sl); err == nil {
+		t.Errorf("no error for a number")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	good := func() *ConfigScraper {
+		return &ConfigScraper{ScraperName: "validate", URL: "http://example.com/news", Links: "a", Title: selectorList{"h1"}, Content: selectorList{".body"}, ArchiveURL: "http://example.com/news/page/%d/"}
+	}
+	if err := good().Validate(); err != nil {
+		t.Errorf("good scraper: %s", err)
+	}
+	for _, test := range []struct {
+		name  string
+		spoil func(s *ConfigScraper)
+	}{
+		{"relative url", func(s *ConfigScraper) { s.URL = "example.com/news" }},
+		{"bad url", func(s *ConfigScraper) { s.URL = "http://exa mple.com/%zz" }},
+		{"empty selector", func(s *ConfigScraper) { s.Content = selectorList{""} }},
+		{"no title", func(s *ConfigScraper) { s.Title = nil }},
+		{"bad selector", func(s *ConfigScraper) { s.Links = "a[" }},
+		{"relative archive url", func(s *ConfigScraper) { s.ArchiveURL = "/page/%d" }},
+	} {
+		s := good()
+		test.spoil(s)
+		if err := s.Validate(); err == nil {
+			t.Errorf("%s: no error", test.name)
+		}
+	}
+}
+
+func TestValidateScrapers(t *testing.T) {
+	scrapers, err := loadScrapers()
+	if err != nil {
+		t.Fatalf("builtin scrapers: %s", err)
+	}
+	scrapers["good"] = &ConfigScraper{ScraperName: "good", URL: "http://example.com/", Links: "a", Title: selectorList{"h1"}, Content: selectorList{".body"}}
+	if err := validateScrapers(scrapers); err != nil {
+		t.Errorf("got %s", err)
+	}
+	scrapers["bad"] = &ConfigScraper{ScraperName: "bad", URL: "ftp://example.com/", Links: "a", Title: selectorList{"h1"}, Content: selectorList{".body"}}
+	if err := validateScrapers(scrapers); err == nil || !strings.HasPrefix(err.Error(), "invalid scraper: bad") {
+		t.Errorf("got %v, want an error for the bad one", err)
+	}
+
+	// (loading the config fails the same way)
+	old := *configFile
+	*configFile = writeConfig(t, `{"scrapers": [{"name": "bad", "url": "ftp://example.com/", "links": "a", "title": "h1", "content": "p"}]}`)
+	defer func() { *configFile = old }()
+	if _, err := loadScrapers(); err == nil {
+		t.Errorf("no error loading a bad scraper")
+	}
+}
+
+func TestConfigCruft(t *testing.T) {
+	var scraper ConfigScraper
+	if err := json.Unmarshal([]byte(`{"name": "cruft", "url": "http://example.com/", "links": "a", "title": "h1", "content": ".body", "cruft": [".share-buttons", ".related", ".ad"]}`), &scraper); err != nil {
+		t.Fatal(err)
+	}
+	if err := scraper.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	pr := &PressRelease{Permalink: "http://example.com/1"}
+	if err := scraper.Scrape(pr, cruftPage); err != nil {
+		t.Fatal(err)
+	}
+	if pr.Content != "<div><p>Keep this.</p><p>And this.</p></div>" {
+		t.Errorf("got %s", pr.Content)
+	}
+}
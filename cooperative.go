@@ -16,6 +16,10 @@ func (scraper *CooperativeScraper) Name() string {
 	return "cooperative"
 }
 
+func (scraper *CooperativeScraper) Meta() ScraperMeta {
+	return ScraperMeta{DisplayName: "The Co-operative", BaseURL: "http://www.co-operative.coop/"}
+}
+
 // fetches a list of latest press releases from Cooperative
 func (scraper *CooperativeScraper) FetchList() ([]*PressRelease, error) {
 	url := "http://www.co-operative.coop/corporate/Press/Press-releases/"
@@ -29,5 +33,5 @@ func (scraper *CooperativeScraper) Scrape(pr *PressRelease, raw_html string) err
 	content := "#ctl00_ctl00_Content_contentDiv"
 	// TODO: kill everything after: "Additional Information:"
 	cruft := "script, noscript, .TwitterTweetFacebookLike, .CrumbTrail, .main-content, .NewsItemDate, .NewsItemFooter, .sendToAFriendBelowContent"
-	return GenericScrape(scraper.Name(), pr, raw_html, title, content, cruft, pubDate)
+	return GenericScrape(scraper.Name(), pr, raw_html, []string{title}, []string{content}, cruft, []string{pubDate})
 }
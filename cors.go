@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsOrigins is the set of origins allowed to make cross-origin requests
+// (see -cors-origins). "*" allows any origin. Empty means no CORS support
+// at all.
+type corsOrigins []string
+
+// parseCORSOrigins splits up a comma-separated list of origins
+func parseCORSOrigins(s string) corsOrigins {
+	var origins corsOrigins
+	for _, origin := range strings.Split(s, ",") {
+		origin = strings.TrimRight(strings.TrimSpace(origin), "/")
+		if origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// allows returns true if requests from origin are allowed
+func (origins corsOrigins) allows(origin string) bool {
+	for _, o := range origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap adds CORS headers to the responses from h, for requests from allowed
+// origins, and handles preflight requests.
+// If no origins are configured, h is returned untouched.
+func (origins corsOrigins) wrap(h http.Handler) http.Handler {
+	if len(origins) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			// not a cross-origin request
+			h.ServeHTTP(w, r)
+			return
+		}
+		if isPreflight(r) {
+			origins.preflight(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if origins.allows(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		// (otherwise the browser will block the response)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// isPreflight returns true for a CORS preflight request. Browsers never
+// send credentials with these (see basicAuth).
+func isPreflight(r *http.Request) bool {
+	return r.Method == "OPTIONS" && r.Header.Get("Origin") != "" && r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+// preflight answers a preflight request, which is turned away unless it's
+// from an allowed origin.
+func (origins corsOrigins) preflight(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	w.Header().Add("Vary", "Origin")
+	if !origins.allows(origin) {
+		http.Error(w, "origin not allowed", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	// EventSource clients send Last-Event-ID when reconnecting
+	w.Header().Set("Access-Control-Allow-Headers", "Last-Event-ID, Cache-Control, Authorization")
+	w.Header().Set("Access-Control-Max-Age", "86400")
+	w.WriteHeader(http.StatusNoContent)
+}
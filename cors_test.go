@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	tests := []struct {
+		name, origins   string
+		method, origin  string
+		wantCode        int
+		wantAllowOrigin string
+	}{
+		{"allowed", "https://a.com, https://b.com/", "GET", "https://b.com", http.StatusOK, "https://b.com"},
+		{"case", "https://a.com", "GET", "https://A.com", http.StatusOK, "https://A.com"},
+		{"any", "*", "GET", "https://anyone.com", http.StatusOK, "https://anyone.com"},
+		// (served, but without the header the browser won't hand it over)
+		{"not allowed", "https://a.com", "GET", "https://evil.com", http.StatusOK, ""},
+		{"same origin", "https://a.com", "GET", "", http.StatusOK, ""},
+		{"off", "", "GET", "https://a.com", http.StatusOK, ""},
+		{"preflight", "https://a.com", "OPTIONS", "https://a.com", http.StatusNoContent, "https://a.com"},
+		{"preflight not allowed", "https://a.com", "OPTIONS", "https://evil.com", http.StatusForbidden, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/tesco/", nil)
+		if test.origin != "" {
+			r.Header.Set("Origin", test.origin)
+		}
+		if test.method == "OPTIONS" {
+			r.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		w := httptest.NewRecorder()
+		parseCORSOrigins(test.origins).wrap(helloHandler).ServeHTTP(w, r)
+		if w.Code != test.wantCode {
+			t.Errorf("%s: got %d, want %d", test.name, w.Code, test.wantCode)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != test.wantAllowOrigin {
+			t.Errorf("%s: got Access-Control-Allow-Origin %q, want %q", test.name, got, test.wantAllowOrigin)
+		}
+		if w.Code == http.StatusNoContent && w.Header().Get("Access-Control-Allow-Headers") == "" {
+			t.Errorf("%s: no Access-Control-Allow-Headers on a preflight", test.name)
+		}
+		if w.Code == http.StatusOK && w.Body.String() != "hello" {
+			t.Errorf("%s: got body %q", test.name, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+)
+
+// Spotting near-duplicate press releases (see -dedup), eg the same
+// syndicated story turning up under several urls with a different intro or
+// a tweaked headline. The content hash only catches exact copies, so each
+// press release also gets a simhash of its text: similar texts come out
+// with similar hashes (only a few bits differ), so they can be compared
+// cheaply without keeping the texts to hand.
+
+// number of words in each of the overlapping runs (shingles) which go into
+// a simhash
+const shingleSize = 3
+
+// number of recent press releases (per source) a new one is compared
+// against
+const dedupWindow = 1000
+
+// simhash returns a 64-bit simhash of the words in text, built from
+// overlapping runs of shingleSize words. Returns 0 if there's no text.
+func simhash(text string) uint64 {
+	words := strings.Fields(normaliseWords(text))
+	if len(words) == 0 {
+		return 0
+	}
+	n := shingleSize
+	if len(words) < n {
+		n = len(words)
+	}
+	// each shingle votes on each bit
+	var votes [64]int
+	for i := 0; i+n <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+n], " ")))
+		sum := h.Sum64()
+		for b := uint(0); b < 64; b++ {
+			if sum&(1<<b) != 0 {
+				votes[b]++
+			} else {
+				votes[b]--
+			}
+		}
+	}
+	var hash uint64
+	for b, v := range votes {
+		if v > 0 {
+			hash |= 1 << uint(b)
+		}
+	}
+	return hash
+}
+
+// similarity returns how alike two simhashes are, from 0 (nothing in
+// common) to 1 (the same). Unrelated texts come out at around 0.5.
+func similarity(a, b uint64) float64 {
+	return 1 - float64(bits.OnesCount64(a^b))/64
+}
+
+// nearDuplicate returns true if two simhashes are similar enough to count
+// as the same press release (going by -dedup, which is off if 0).
+func nearDuplicate(a, b uint64) bool {
+	if *dedupFlag <= 0 || a == 0 || b == 0 {
+		return false
+	}
+	return similarity(a, b) >= *dedupFlag
+}
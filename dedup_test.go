@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+const queueing = `Brits spend two years of their lives queueing, according to a new study. The average adult will spend
+an hour a week waiting in line at the supermarket, the bank and the post office, researchers found. Queues at the
+checkout were named the most frustrating, followed by waiting for a table at a restaurant and queueing for a bus.
+A spokesman said: "We are a nation of queuers, but there are limits to our patience." The study of 2,000 adults
+also found that one in ten people have walked out of a shop rather than wait in a long queue to pay.`
+
+const barbecues = `Millions of Brits have never cooked on a barbecue, a study has found. One in five adults admitted they
+had never lit one, with many saying they would rather leave it to a partner or friend. The research also found that
+sausages and burgers are still the most popular things to put on the grill, but halloumi is catching up fast.`
+
+// queueingSyndicated is queueing, as rewritten a bit by another outlet
+var queueingSyndicated = strings.NewReplacer(
+	"according to a new study", "a poll has revealed",
+	"A spokesman said", "A spokeswoman for the firm said",
+).Replace(queueing)
+
+func TestSimhash(t *testing.T) {
+	if s := similarity(simhash(queueing), simhash(queueing)); s != 1 {
+		t.Errorf("got similarity %f for the same text", s)
+	}
+	if s := similarity(simhash(queueing), simhash(queueingSyndicated)); s < 0.8 {
+		t.Errorf("got similarity %f for near duplicates, want at least 0.8", s)
+	}
+	if s := similarity(simhash(queueing), simhash(barbecues)); s > 0.7 {
+		t.Errorf("got similarity %f for different texts, want at most 0.7", s)
+	}
+}
+
+func TestDedup(t *testing.T) {
+	old := *dedupFlag
+	defer func() { *dedupFlag = old }()
+	release := func(source, permalink, text string) *PressRelease {
+		return &PressRelease{Title: "x", Source: source, Permalink: permalink, Text: text, simhash: simhash(text)}
+	}
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		*dedupFlag = 0.8
+		original, err := store.Stash(release("72point", "http://example.com/1", queueing))
+		if err != nil {
+			t.Fatal(err)
+		}
+		near := release("72point", "http://example.com/2", queueingSyndicated)
+		distinct := release("72point", "http://example.com/3", barbecues)
+		// (only the same source's releases are compared)
+		otherSource := release("tesco", "http://example.com/4", queueing)
+		unseen, err := store.WhichAreNew([]*PressRelease{near, distinct, otherSource})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(unseen) != 2 || unseen[0] != distinct || unseen[1] != otherSource {
+			t.Errorf("%T: got %v new", store, unseen)
+		}
+		if near.duplicateOf != original.id {
+			t.Errorf("%T: near duplicate flagged as a duplicate of %d, want %d", store, near.duplicateOf, original.id)
+		}
+
+		if err := store.LinkDuplicate(near.duplicateOf, near.Permalink); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.LinkDuplicate(9999, "http://example.com/9"); err != errNotFound {
+			t.Errorf("%T: linking to a missing release: got %v, want %v", store, err, errNotFound)
+		}
+		// the linked url counts as seen now, even without any text (as
+		// when it's listed on the index page again)
+		*dedupFlag = 0
+		if n := countNew(t, store, &PressRelease{Source: "72point", Permalink: near.Permalink}); n != 0 {
+			t.Errorf("%T: linked duplicate is new again", store)
+		}
+		if n := countNew(t, store, distinct); n != 1 {
+			t.Errorf("%T: distinct release isn't new without -dedup", store)
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"code.google.com/p/go.net/html"
+	"code.google.com/p/go.net/html/atom"
+	"code.google.com/p/go.net/html/charset"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// feedDoc covers RSS 2.0 (items in a <channel>), RSS 1.0 (items at the
+// top level) and Atom (<entry>s) feeds, just enough for RSSFetchList
+type feedDoc struct {
+	ChannelItems []feedItem  `xml:"channel>item"`
+	Items        []feedItem  `xml:"item"`
+	Entries      []atomEntry `xml:"entry"`
+}
+
+type feedItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Description string   `xml:"description"`
+	Encoded     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Categories  []string `xml:"category"`
+}
+
+type atomEntry struct {
+	Title string `xml:"title"`
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+	Published  string      `xml:"published"`
+	Updated    string      `xml:"updated"`
+	Content    atomContent `xml:"content"`
+	Summary    atomContent `xml:"summary"`
+	Categories []struct {
+		Term string `xml:"term,attr"`
+	} `xml:"category"`
+}
+
+// atomContent is html (escaped), xhtml (inline) or plain text
+type atomContent struct {
+	Type  string `xml:"type,attr"`
+	Text  string `xml:",chardata"`
+	Inner string `xml:",innerxml"`
+}
+
+func (c atomContent) html() string {
+	switch c.Type {
+	case "xhtml":
+		return c.Inner
+	case "html", "text/html":
+		return c.Text
+	}
+	return html.EscapeString(c.Text)
+}
+
+// RSSFetchList fetches a list of press releases from an RSS or Atom feed.
+// For feeds which carry the whole press release (in content:encoded,
+// description, or the atom content), the releases are filled out from the
+// feed and marked complete, so there's no need to fetch the pages
+// themselves. Ones without content are left for scraping as usual.
+func RSSFetchList(scraperName, feedURL string) ([]*PressRelease, error) {
+	allowed, err := robotsAllowed(feedURL, userAgent)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, errDisallowed
+	}
+	resp, err := politeGet(httpClient, feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &statusError{feedURL, resp.StatusCode}
+	}
+	var feed feedDoc
+	dec := xml.NewDecoder(resp.Body)
+	dec.CharsetReader = func(label string, input io.Reader) (io.Reader, error) {
+		return charset.NewReader(input, "text/xml; charset="+label)
+	}
+	err = dec.Decode(&feed)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", feedURL, err)
+	}
+	base := resp.Request.URL
+
+	docs := make([]*PressRelease, 0)
+	seen := make(map[string]bool)
+	add := func(link, title, date, content string, tags []string) {
+		permalink, err := resolveLink(base, link)
+		if err != nil {
+			debugf("%s: skipping item in %s: %s", scraperName, feedURL, err)
+			return
+		}
+		if seen[permalink] {
+			return
+		}
+		seen[permalink] = true
+		pr := &PressRelease{Source: scraperName, Permalink: permalink, Title: normaliseSpace(title)}
+		if date = normaliseSpace(date); date != "" {
+			pr.PubDate, err = parseTime(date)
+			if err != nil {
+				pr.PubDate, err = parsePubDate(date)
+			}
+			if err != nil {
+				warnf("%s: couldn't parse date '%s' in %s (%s)", scraperName, date, feedURL, err)
+			}
+		}
+		for _, tag := range tags {
+			if tag = normaliseSpace(tag); tag != "" {
+				pr.Tags = append(pr.Tags, tag)
+			}
+		}
+		if strings.TrimSpace(content) != "" && pr.Title != "" {
+			err = fillFromFeed(pr, content)
+			if err != nil {
+				warnf("%s: bad content for %s in %s (%s)", scraperName, permalink, feedURL, err)
+			}
+		}
+		docs = append(docs, pr)
+	}
+
+	for _, item := range append(feed.ChannelItems, feed.Items...) {
+		link := item.Link
+		if link == "" {
+			// (a permalink guid will do)
+			link = item.Guid
+		}
+		date := item.PubDate
+		if date == "" {
+			date = item.Date
+		}
+		content := item.Encoded
+		if content == "" {
+			content = item.Description
+		}
+		add(link, item.Title, date, content, item.Categories)
+	}
+	for _, entry := range feed.Entries {
+		link := ""
+		for _, l := range entry.Links {
+			if l.Rel == "" || l.Rel == "alternate" {
+				link = l.Href
+				break
+			}
+		}
+		date := entry.Published
+		if date == "" {
+			date = entry.Updated
+		}
+		content := entry.Content.html()
+		if strings.TrimSpace(content) == "" {
+			content = entry.Summary.html()
+		}
+		var tags []string
+		for _, c := range entry.Categories {
+			tags = append(tags, c.Term)
+		}
+		add(link, entry.Title, date, content, tags)
+	}
+	return docs, nil
+}
+
+// fillFromFeed fills out the content of a press release from the html in a
+// feed item, and marks it complete. As for scraped pages, the content is
+// scrubbed, and the pubdate fudged to now if the feed didn't give one.
+func fillFromFeed(pr *PressRelease, content string) error {
+	div := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	nodes, err := html.ParseFragment(strings.NewReader(content), div)
+	if err != nil {
+		return err
+	}
+	for _, n := range nodes {
+		div.AppendChild(n)
+	}
+	pr.Text = htmlText(div)
+	pr.Content, err = renderScrubbed(div)
+	if err != nil {
+		return err
+	}
+	if pr.PubDate.IsZero() {
+		pr.PubDate = time.Now()
+	}
+	pr.complete = true
+	return nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donovanhide/eventsource"
+)
+
+const testRSS = `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:content="http://purl.org/rss/1.0/modules/content/">
+<channel><title>News</title>
+<item><title> Prices cut </title><link>/news/1</link><pubDate>Wed, 12 Mar 2014 09:30:00 +0000</pubDate>
+<description>Short</description>
+<content:encoded><![CDATA[<p style="color:red">Prices have been cut on <b>1,000</b> lines.</p><script>track()</script><p>More.</p>]]></content:encoded>
+<category>Corporate</category></item>
+<item><title>Teaser only</title><link>http://example.com/news/2</link><pubDate>10 March 2014</pubDate></item>
+</channel></rss>`
+
+const testAtom = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom"><title>News</title>
+<entry><title>Atom one</title><link rel="alternate" href="http://example.com/a/1"/><published>2014-03-12T10:00:00Z</published>
+<content type="html">&lt;p&gt;Hello &amp;amp; welcome&lt;/p&gt;</content><category term="Food"/></entry>
+<entry><title>Atom two</title><link href="http://example.com/a/2"/><updated>2014-03-11T10:00:00Z</updated>
+<content type="xhtml"><div xmlns="http://www.w3.org/1999/xhtml"><p>Inline</p></div></content></entry>
+</feed>`
+
+// feedServer serves up the test feeds, at /rss and /atom
+func feedServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/rss":
+			fmt.Fprint(w, testRSS)
+		case "/atom":
+			fmt.Fprint(w, testAtom)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestRSSFetchList(t *testing.T) {
+	srv := feedServer()
+	defer srv.Close()
+
+	prs, err := RSSFetchList("feed", srv.URL+"/rss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 2 {
+		t.Fatalf("got %d releases, want 2", len(prs))
+	}
+	pr := prs[0]
+	if !pr.complete || pr.Source != "feed" || pr.Title != "Prices cut" || pr.Permalink != srv.URL+"/news/1" {
+		t.Errorf("got %+v", pr)
+	}
+	if want := time.Date(2014, 3, 12, 9, 30, 0, 0, time.UTC); !pr.PubDate.Equal(want) {
+		t.Errorf("got pubdate %s, want %s", pr.PubDate, want)
+	}
+	// (scrubbed, like scraped content)
+	if pr.Content != "<div><p>Prices have been cut on <b>1,000</b> lines.</p><p>More.</p></div>" {
+		t.Errorf("got content %q", pr.Content)
+	}
+	if !strings.Contains(pr.Text, "More.") || fmt.Sprint(pr.Tags) != "[Corporate]" {
+		t.Errorf("got text %q, tags %v", pr.Text, pr.Tags)
+	}
+	// (no content, so it's scraped as usual)
+	if pr := prs[1]; pr.complete || pr.Title != "Teaser only" || pr.PubDate.Day() != 10 {
+		t.Errorf("got %+v", pr)
+	}
+
+	prs, err = RSSFetchList("feed", srv.URL+"/atom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prs) != 2 {
+		t.Fatalf("got %d atom releases, want 2", len(prs))
+	}
+	if pr := prs[0]; !pr.complete || pr.Permalink != "http://example.com/a/1" || !strings.Contains(pr.Content, "Hello &amp; welcome") || fmt.Sprint(pr.Tags) != "[Food]" {
+		t.Errorf("got %+v", pr)
+	}
+	if pr := prs[1]; !pr.complete || !strings.Contains(pr.Content, "<p>Inline</p>") || pr.PubDate.Day() != 11 {
+		t.Errorf("got %+v", pr)
+	}
+
+	if _, err := RSSFetchList("feed", srv.URL+"/missing"); err == nil {
+		t.Errorf("no error for a missing feed")
+	}
+}
+
+// Complete releases are stashed without their pages being fetched.
+func TestCompleteReleases(t *testing.T) {
+	setFlag(t, minContent, 0)
+	srv := feedServer()
+	defer srv.Close()
+	prs, err := RSSFetchList("feed-complete", srv.URL+"/atom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := NewMemStore()
+	// (the permalinks are on example.com, so fetching them would fail)
+	doit(&listScraper{fakeScraper{"feed-complete"}, prs}, store, eventsource.NewServer())
+	stashed, err := store.Query(QueryOptions{Source: "feed-complete"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stashed) != 2 || stashed[0].Title != "Atom two" || !strings.Contains(stashed[0].Content, "Inline") {
+		t.Errorf("got %v stashed", stashed)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fixtureTransport serves up saved pages in place of the source sites, so
+// the scrapers can be checked without going near the network.
+// <dir>/fixtures.json maps urls to the files (relative to dir) holding
+// their html, eg:
+//
+//	{"http://www.72point.com/coverage/": "index.html"}
+//
+// Any other url gets a 404.
+type fixtureTransport struct {
+	dir  string
+	urls map[string]string
+}
+
+func loadFixtures(t *testing.T, dir string) *fixtureTransport {
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "fixtures.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ft := &fixtureTransport{dir: dir}
+	err = json.Unmarshal(raw, &ft.urls)
+	if err != nil {
+		t.Fatalf("%s: %s", filepath.Join(dir, "fixtures.json"), err)
+	}
+	return ft
+}
+
+func (ft *fixtureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       ioutil.NopCloser(strings.NewReader("")),
+		Request:    req,
+	}
+	file, ok := ft.urls[req.URL.String()]
+	if !ok {
+		return resp, nil
+	}
+	page, err := ioutil.ReadFile(filepath.Join(ft.dir, file))
+	if err != nil {
+		return nil, err
+	}
+	resp.StatusCode = http.StatusOK
+	resp.Header.Set("Content-Type", "text/html; charset=utf-8")
+	resp.Body = ioutil.NopCloser(strings.NewReader(string(page)))
+	resp.ContentLength = int64(len(page))
+	return resp, nil
+}
+
+// TestFixtures runs each builtin scraper which has fixtures (under
+// testdata/<source>/) against them, and checks every press release listed
+// comes out with a title, some content and a pubdate (a real one - not
+// just fudged to the current time), so selectors which have rotted show
+// up. When a site changes its layout, save the new pages there and fix up
+// the selectors until it passes again.
+func TestFixtures(t *testing.T) {
+	scrapers, err := loadScrapers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dirs, err := filepath.Glob("testdata/*/fixtures.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) == 0 {
+		t.Fatal("no fixtures found in testdata")
+	}
+	for _, fixtures := range dirs {
+		dir := filepath.Dir(fixtures)
+		scraper, ok := scrapers[filepath.Base(dir)]
+		if !ok {
+			t.Errorf("%s: no such scraper", dir)
+			continue
+		}
+		t.Run(scraper.Name(), func(t *testing.T) {
+			checkFixtures(t, scraper, dir)
+		})
+	}
+}
+
+func checkFixtures(t *testing.T, scraper Scraper, dir string) {
+	old := httpClient.Transport
+	httpClient.Transport = loadFixtures(t, dir)
+	defer func() { httpClient.Transport = old }()
+
+	start := time.Now()
+	pressReleases, err := safeFetchList(context.Background(), scraper, time.Time{})
+	if err != nil {
+		t.Fatalf("fetching list: %s", err)
+	}
+	if len(pressReleases) == 0 {
+		t.Fatal("no press releases listed")
+	}
+	for _, pr := range pressReleases {
+		if !pr.complete {
+			err = scrape(context.Background(), scraper, pr)
+			if err != nil {
+				t.Errorf("scraping %s: %s", pr.Permalink, err)
+				continue
+			}
+		}
+		if pr.Title == "" {
+			t.Errorf("%s: no title", pr.Permalink)
+		}
+		if isEmpty(pr) {
+			t.Errorf("%s: no content", pr.Permalink)
+		}
+		if pr.PubDate.IsZero() || !pr.PubDate.Before(start) {
+			t.Errorf("%s: no pubdate", pr.Permalink)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"code.google.com/p/go.net/html"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Guessing the list selector for a new site (for -guess).
+// Press release index pages are mostly a run of near-identical items, each
+// with a link to a release. So links are grouped by the path of tags (and
+// classes) down to them from the top of the page, and the group with the
+// most (and wordiest) distinct links is taken to be the release list.
+// It's only a starting point for writing a scraper config - the sidebar or
+// footer can still win on an odd page.
+
+var errNoListFound = errors.New("no repeated links found")
+
+// elements whose links are assumed never to be press releases
+var chromeElements = map[string]bool{
+	"nav":    true,
+	"header": true,
+	"footer": true,
+	"aside":  true,
+	"form":   true,
+}
+
+// only classes which can go straight into a selector are used
+var plainClassPat = regexp.MustCompile(`^-?[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// linkGroup is a bunch of links at the same position in the page structure
+type linkGroup struct {
+	path  []*html.Node // ancestors of the first link, outermost first
+	links map[string]bool
+	score float64
+}
+
+// GuessListSelector has a go at working out a selector for the press
+// release links on an index page (see above). baseURL is the url the page
+// came from, for resolving the links (only links on the same host are
+// considered).
+func GuessListSelector(raw_html, baseURL string) (string, error) {
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return "", err
+	}
+	root, err := html.Parse(strings.NewReader(raw_html))
+	if err != nil {
+		return "", err
+	}
+
+	groups := make(map[string]*linkGroup)
+	for _, a := range querySelectorAll(root, "a[href]") {
+		path, ok := ancestry(a)
+		if !ok {
+			continue
+		}
+		link, err := resolveLink(base, getAttr(a, "href"))
+		if err != nil {
+			continue
+		}
+		u, _ := url.Parse(link)
+		u.Fragment = ""
+		if !strings.EqualFold(u.Host, base.Host) || u.String() == base.String() {
+			continue
+		}
+		link = u.String()
+
+		sig := signature(path)
+		g := groups[sig]
+		if g == nil {
+			g = &linkGroup{path: path, links: make(map[string]bool)}
+			groups[sig] = g
+		}
+		if g.links[link] {
+			continue
+		}
+		g.links[link] = true
+		// longer link texts (ie titles) count for more, up to a point
+		words := len(strings.Fields(linkTitle(a)))
+		if words > 10 {
+			words = 10
+		}
+		g.score += 1 + float64(words)/5
+	}
+
+	var best *linkGroup
+	for _, g := range sortedGroups(groups) {
+		if len(g.links) < 2 {
+			continue
+		}
+		if best == nil || g.score > best.score {
+			best = g
+		}
+	}
+	if best == nil {
+		return "", errNoListFound
+	}
+	return pathSelector(best.path), nil
+}
+
+// ancestry returns the chain of elements from the top of the page down to
+// n (inclusive), or false if n is in amongst the page furniture (see
+// chromeElements).
+func ancestry(n *html.Node) ([]*html.Node, bool) {
+	var path []*html.Node
+	for ; n != nil; n = n.Parent {
+		if n.Type != html.ElementNode {
+			continue
+		}
+		if chromeElements[n.Data] {
+			return nil, false
+		}
+		path = append([]*html.Node{n}, path...)
+	}
+	return path, true
+}
+
+// signature sums up where a path goes, by tag and class (but not position,
+// so all the items in a list come out the same)
+func signature(path []*html.Node) string {
+	parts := make([]string, len(path))
+	for i, n := range path {
+		parts[i] = n.Data + "." + strings.Join(plainClasses(n), ".")
+	}
+	return strings.Join(parts, " ")
+}
+
+// sortedGroups returns the groups in a fixed order, so ties always go the
+// same way
+func sortedGroups(groups map[string]*linkGroup) []*linkGroup {
+	var sigs []string
+	for sig := range groups {
+		sigs = append(sigs, sig)
+	}
+	sort.Strings(sigs)
+	out := make([]*linkGroup, len(sigs))
+	for i, sig := range sigs {
+		out[i] = groups[sig]
+	}
+	return out
+}
+
+// pathSelector turns a path into a descendant selector, eg
+// "#content .main .item h3 a". It starts from the nearest element with an
+// id, and uses classes in place of tag names where there are any.
+func pathSelector(path []*html.Node) string {
+	last := len(path) - 1
+	start := 0
+	for i := 0; i < last; i++ {
+		if plainClassPat.MatchString(getAttr(path[i], "id")) {
+			start = i
+		}
+	}
+	var steps []string
+	for i := start; i <= last; i++ {
+		n := path[i]
+		classes := plainClasses(n)
+		switch {
+		case i == start && plainClassPat.MatchString(getAttr(n, "id")):
+			steps = append(steps, "#"+getAttr(n, "id"))
+		case n.Data == "html" || n.Data == "body":
+		case i < last && len(classes) > 0:
+			steps = append(steps, "."+strings.Join(classes, "."))
+		default:
+			steps = append(steps, n.Data)
+		}
+	}
+	return strings.Join(steps, " ")
+}
+
+// plainClasses returns the classes on n which can be used in a selector
+// (see plainClassPat), sorted
+func plainClasses(n *html.Node) []string {
+	var classes []string
+	for _, c := range strings.Fields(getAttr(n, "class")) {
+		if plainClassPat.MatchString(c) {
+			classes = append(classes, c)
+		}
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+// guessRun fetches an index page (for -guess), and prints out a guess at
+// the list selector for it, along with the links it picks up.
+func guessRun(pageURL string) error {
+	allowed, err := robotsAllowed(pageURL, userAgent)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return errDisallowed
+	}
+	resp, err := politeGet(httpClient, pageURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := utf8Body(resp)
+	if err != nil {
+		return err
+	}
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	selector, err := GuessListSelector(string(raw), resp.Request.URL.String())
+	if err != nil {
+		return fmt.Errorf("%s: %s", pageURL, err)
+	}
+	fmt.Println(selector)
+	root, err := html.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	for _, a := range querySelectorAll(root, selector) {
+		fmt.Printf(" %s %s\n", getAttr(a, "href"), linkTitle(a))
+	}
+	return nil
+}
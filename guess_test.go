@@ -0,0 +1,48 @@
+package main
+
+import (
+	"code.google.com/p/go.net/html"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestGuessListSelector(t *testing.T) {
+	for _, test := range []struct {
+		file, base, want string
+	}{
+		{"testdata/waitrose/index.html", "http://www.waitrose.presscentre.com/Press-Releases", "#content .main .item h3 a"},
+		{"testdata/72point/index.html", "http://www.72point.com/coverage/", "#content .items .item .content .links a"},
+	} {
+		raw, err := ioutil.ReadFile(test.file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sel, err := GuessListSelector(string(raw), test.base)
+		if err != nil {
+			t.Errorf("%s: %s", test.file, err)
+			continue
+		}
+		if sel != test.want {
+			t.Errorf("%s: got %q, want %q", test.file, sel, test.want)
+		}
+		root, err := html.Parse(strings.NewReader(string(raw)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(querySelectorAll(root, sel)); n < 2 {
+			t.Errorf("%s: %q picks out %d links", test.file, sel, n)
+		}
+	}
+
+	// (the menu and the offsite links don't count)
+	page := `<html><body><nav><a href="/a">A long menu item here</a><a href="/b">B long menu item here</a></nav>
+		<ul class="news"><li><a href="/news/1">Release one title</a></li><li><a href="/news/2">Release two title</a></li></ul>
+		<div class="partners"><a href="http://other.example.com/x">x</a><a href="http://other.example.com/y">y</a></div></body></html>`
+	if sel, err := GuessListSelector(page, "http://example.com/news"); err != nil || sel != ".news li a" {
+		t.Errorf("got %q (%v), want %q", sel, err, ".news li a")
+	}
+	if _, err := GuessListSelector(`<a href="/news/1">One</a>`, "http://example.com/"); err != errNoListFound {
+		t.Errorf("one link: got %v, want %v", err, errNoListFound)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// withHeartbeat wraps an event stream handler to send a keep-alive comment
+// down the stream every so often, so proxies (nginx, Cloudflare etc) don't
+// give up on connections which have gone quiet. It also tells them not to
+// buffer or cache the stream. An every of zero turns the heartbeat off.
+func withHeartbeat(h http.HandlerFunc, every time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		if every <= 0 {
+			h(w, r)
+			return
+		}
+		hw := &heartbeatWriter{ResponseWriter: w, done: make(chan struct{}), tail: eventEnd}
+		go hw.beat(every)
+		defer hw.stop()
+		h(hw, r)
+	}
+}
+
+// heartbeatWriter serialises the writes from the handler and the
+// heartbeat, which happen on different goroutines.
+// The eventsource encoder writes each event a field at a time, so it also
+// keeps track of the last couple of bytes written, to keep the heartbeat
+// from landing in the middle of an event (its blank line would cut the
+// event short).
+type heartbeatWriter struct {
+	http.ResponseWriter
+	sync.Mutex
+	started bool // set once the handler has sent the headers
+	stopped bool
+	done    chan struct{}
+	tail    [2]byte
+}
+
+// eventEnd is the blank line which ends an event
+var eventEnd = [2]byte{'\n', '\n'}
+
+func (hw *heartbeatWriter) WriteHeader(code int) {
+	hw.Lock()
+	defer hw.Unlock()
+	hw.started = true
+	hw.ResponseWriter.WriteHeader(code)
+}
+
+func (hw *heartbeatWriter) Write(p []byte) (int, error) {
+	hw.Lock()
+	defer hw.Unlock()
+	hw.started = true
+	n, err := hw.ResponseWriter.Write(p)
+	if n > 0 {
+		if n > 1 {
+			hw.tail[0] = p[n-2]
+		} else {
+			hw.tail[0] = hw.tail[1]
+		}
+		hw.tail[1] = p[n-1]
+	}
+	return n, err
+}
+
+func (hw *heartbeatWriter) Flush() {
+	hw.Lock()
+	defer hw.Unlock()
+	hw.flush()
+}
+
+func (hw *heartbeatWriter) flush() {
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// CloseNotify is passed through for the eventsource server, which uses it
+// to spot clients going away
+func (hw *heartbeatWriter) CloseNotify() <-chan bool {
+	if cn, ok := hw.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// beat sends a keep-alive comment every so often, until stopped. Nothing is
+// sent until the handler has started the stream (so errors from it still
+// go out as they should), or while it's part way through an event.
+func (hw *heartbeatWriter) beat(every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hw.done:
+			return
+		case <-ticker.C:
+		}
+		hw.Lock()
+		if hw.started && !hw.stopped && hw.tail == eventEnd {
+			hw.ResponseWriter.Write([]byte(": keep-alive\n\n"))
+			hw.flush()
+		}
+		hw.Unlock()
+	}
+}
+
+// stop ends the heartbeat, once the handler is done with the response
+func (hw *heartbeatWriter) stop() {
+	hw.Lock()
+	defer hw.Unlock()
+	hw.stopped = true
+	close(hw.done)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatOnIdleStream(t *testing.T) {
+	idle := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}
+	srv := httptest.NewServer(withHeartbeat(idle, 10*time.Millisecond))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if got := resp.Header.Get("X-Accel-Buffering"); got != "no" {
+		t.Errorf("got X-Accel-Buffering %q, want no", got)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-cache" {
+		t.Errorf("got Cache-Control %q, want no-cache", got)
+	}
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		rd := bufio.NewReader(resp.Body)
+		for {
+			line, err := rd.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+	beats := 0
+	timeout := time.After(2 * time.Second)
+	for beats < 3 {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatalf("stream ended after %d heartbeats", beats)
+			}
+			switch line {
+			case ": keep-alive\n":
+				beats++
+			case "\n":
+			default:
+				t.Fatalf("got %q on an idle stream", line)
+			}
+		case <-timeout:
+			t.Fatalf("got %d heartbeats, want 3", beats)
+		}
+	}
+}
+
+// The eventsource encoder writes an event a line at a time - a heartbeat
+// in between would end it early.
+func TestHeartbeatBetweenEvents(t *testing.T) {
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		for _, field := range []string{"id: 1\n", "event: new\n", "data: {}\n", "\n"} {
+			w.Write([]byte(field))
+			w.(http.Flusher).Flush()
+			time.Sleep(30 * time.Millisecond)
+		}
+	}
+	srv := httptest.NewServer(withHeartbeat(slow, 5*time.Millisecond))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream := string(body)
+	if !strings.Contains(stream, "id: 1\nevent: new\ndata: {}\n\n") {
+		t.Errorf("event broken up: %q", stream)
+	}
+	if !strings.HasSuffix(stream, "\n\n: keep-alive\n\n") {
+		t.Errorf("no heartbeat after the event: %q", stream)
+	}
+}
+
+func TestHeartbeatLeavesErrorsAlone(t *testing.T) {
+	failing := func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad lang", http.StatusBadRequest)
+		time.Sleep(10 * time.Millisecond)
+	}
+	w := httptest.NewRecorder()
+	withHeartbeat(failing, time.Millisecond)(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("got %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	if strings.Contains(w.Body.String(), "keep-alive") {
+		t.Errorf("heartbeat sent after an error: %q", w.Body.String())
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+// Picking press release details out of schema.org JSON-LD, which a lot of
+// CMSes embed in the page as <script type="application/ld+json">.
+
+import (
+	"code.google.com/p/go.net/html"
+	"code.google.com/p/go.net/html/atom"
+	"encoding/json"
+	"regexp"
+	"strings"
+)
+
+// ldArticle is the useful bits of a schema.org Article (or NewsArticle etc)
+type ldArticle struct {
+	Headline      string
+	DatePublished string
+	ArticleBody   string // plaintext
+	Image         string // url, possibly relative
+}
+
+// the schema.org types taken to be a press release
+var ldArticleTypes = map[string]bool{
+	"Article":              true,
+	"NewsArticle":          true,
+	"ReportageNewsArticle": true,
+	"BlogPosting":          true,
+}
+
+// findArticleLD returns the first article described by the JSON-LD blocks
+// in a page, or nil if there isn't one. Blocks which don't parse are
+// skipped.
+func findArticleLD(root *html.Node) *ldArticle {
+	for _, script := range querySelectorAll(root, `script[type="application/ld+json"]`) {
+		var data interface{}
+		if err := json.Unmarshal([]byte(getTextContent(script)), &data); err != nil {
+			debugf("skipping bad json-ld: %s", err)
+			continue
+		}
+		if obj := findLDArticleObj(data); obj != nil {
+			return &ldArticle{
+				Headline:      ldString(obj["headline"]),
+				DatePublished: ldString(obj["datePublished"]),
+				ArticleBody:   ldString(obj["articleBody"]),
+				Image:         ldImage(obj["image"]),
+			}
+		}
+	}
+	return nil
+}
+
+// findLDArticleObj digs through a decoded JSON-LD value (which might be a
+// list of things, or have them under "@graph") for an article
+func findLDArticleObj(data interface{}) map[string]interface{} {
+	switch v := data.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if obj := findLDArticleObj(item); obj != nil {
+				return obj
+			}
+		}
+	case map[string]interface{}:
+		if isLDArticle(v["@type"]) {
+			return v
+		}
+		if graph, ok := v["@graph"]; ok {
+			return findLDArticleObj(graph)
+		}
+	}
+	return nil
+}
+
+// isLDArticle returns true if an "@type" (a string, or a list of them) is
+// one of ldArticleTypes
+func isLDArticle(t interface{}) bool {
+	switch v := t.(type) {
+	case string:
+		return ldArticleTypes[strings.TrimPrefix(v, "http://schema.org/")]
+	case []interface{}:
+		for _, item := range v {
+			if isLDArticle(item) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ldString returns a JSON-LD value as a string, or "" if it isn't one
+func ldString(v interface{}) string {
+	s, _ := v.(string)
+	return strings.TrimSpace(s)
+}
+
+// ldImage picks an image url out of a JSON-LD "image", which can be a url,
+// an ImageObject (with a url), or a list of either
+func ldImage(v interface{}) string {
+	switch img := v.(type) {
+	case string:
+		return strings.TrimSpace(img)
+	case map[string]interface{}:
+		return ldString(img["url"])
+	case []interface{}:
+		for _, item := range img {
+			if s := ldImage(item); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+var ldParaPat = regexp.MustCompile(`\n\s*\n`)
+
+// ldBody turns a plaintext articleBody into html - a <div>, with a <p> for
+// each paragraph (going by blank lines)
+func ldBody(body string) *html.Node {
+	div := &html.Node{Type: html.ElementNode, Data: "div", DataAtom: atom.Div}
+	for _, para := range ldParaPat.Split(body, -1) {
+		if para = compressSpace(para); para != "" {
+			p := &html.Node{Type: html.ElementNode, Data: "p", DataAtom: atom.P}
+			p.AppendChild(&html.Node{Type: html.TextNode, Data: para})
+			div.AppendChild(p)
+		}
+	}
+	return div
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJSONLD(t *testing.T) {
+	for _, test := range []struct {
+		name, page                string
+		title, content, text, img string
+		pubDate                   time.Time
+	}{
+		{
+			"graph",
+			`<html><head><script type="application/ld+json">{"@context":"https://schema.org","@graph":[{"@type":"WebPage","name":"Page"},{"@type":["NewsArticle"],"headline":" Big  News ","datePublished":"2014-03-12T09:30:00+00:00","articleBody":"First para\nstill first.\n\nSecond <para> & more.","image":[{"@type":"ImageObject","url":"/img/lead.jpg"}]}]}</script>
+			<script type="application/ld+json">{broken</script></head>
+			<body><h1>CSS title</h1><div class="body"><p>CSS body</p><img src="/other.jpg"></div></body></html>`,
+			"Big News",
+			"<div><p>First para still first.</p><p>Second &lt;para&gt; &amp; more.</p></div>",
+			"First para still first.\n\nSecond <para> & more.",
+			"http://example.com/img/lead.jpg",
+			time.Date(2014, 3, 12, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			// (no articleBody, so the content comes from the selectors)
+			"array",
+			`<script type="application/ld+json">[{"@type":"Organization"},{"@type":"Article","headline":"LD title","image":"http://images.example.com/1.png"}]</script>
+			<h1>CSS title</h1><div class="body"><p>CSS body</p></div><span class="date">1 March 2014</span>`,
+			"LD title",
+			"<div><p>CSS body</p></div>",
+			"CSS body",
+			"http://images.example.com/1.png",
+			time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			"none",
+			`<h1>CSS title</h1><div class="body"><p>CSS body</p></div><span class="date">2 March 2014</span>`,
+			"CSS title",
+			"<div><p>CSS body</p></div>",
+			"CSS body",
+			"",
+			time.Date(2014, 3, 2, 0, 0, 0, 0, time.UTC),
+		},
+	} {
+		pr := &PressRelease{Permalink: "http://example.com/press/1"}
+		if err := GenericScrape("jsonld", pr, test.page, []string{"h1"}, []string{".body"}, "", []string{".date"}); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if pr.Title != test.title {
+			t.Errorf("%s: got title %q, want %q", test.name, pr.Title, test.title)
+		}
+		if pr.Content != test.content {
+			t.Errorf("%s: got content %q, want %q", test.name, pr.Content, test.content)
+		}
+		if pr.Text != test.text {
+			t.Errorf("%s: got text %q, want %q", test.name, pr.Text, test.text)
+		}
+		if pr.ImageURL != test.img {
+			t.Errorf("%s: got image %q, want %q", test.name, pr.ImageURL, test.img)
+		}
+		if !pr.PubDate.Equal(test.pubDate) {
+			t.Errorf("%s: got pubdate %s, want %s", test.name, pr.PubDate, test.pubDate)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// the language assumed if detection isn't confident
+const defaultLang = "en"
+
+// common short words for each language detectLang knows about
+var langStopwords = map[string][]string{
+	"en": strings.Fields("the and of to in is that for with on are was be by it this as at from have has will our we"),
+	"cy": strings.Fields("y yr a ac i o yn ar am mae ei eu gan bod hyn sy wedi fel gyda hefyd ni ein eich"),
+	"fr": strings.Fields("le la les de des du et un une est pour dans que qui sur avec au aux nous par pas"),
+	"de": strings.Fields("der die das und ist nicht ein eine zu den von mit sich des auf für im dem wir auch"),
+	"es": strings.Fields("el la los las de del y que en un una es por con para se su al como más"),
+	"it": strings.Fields("il lo la gli le di e che un una per con non sono della del nel alla anche come"),
+}
+
+// langWords maps each stopword to the languages it appears in
+var langWords = func() map[string][]string {
+	m := make(map[string][]string)
+	for lang, words := range langStopwords {
+		for _, w := range words {
+			m[w] = append(m[w], lang)
+		}
+	}
+	return m
+}()
+
+// detectLang makes a rough guess at the language of some text, by counting
+// up common words. Returns defaultLang if there's not much to go on, or no
+// clear winner.
+func detectLang(txt string) string {
+	words := strings.FieldsFunc(strings.ToLower(txt), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	scores := make(map[string]int)
+	for _, w := range words {
+		for _, lang := range langWords[w] {
+			scores[lang]++
+		}
+	}
+	best, bestScore, second := defaultLang, 0, 0
+	for lang, score := range scores {
+		if score > bestScore {
+			best, bestScore, second = lang, score, bestScore
+		} else if score > second {
+			second = score
+		}
+	}
+	// not confident unless there's a decent number of hits, and a clear
+	// margin over the runner-up
+	if bestScore < 5 || bestScore < second*3/2 {
+		return defaultLang
+	}
+	return best
+}
+
+var langPat = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+// normaliseLang boils a language tag (eg from <html lang="en-GB">) down to
+// just the (lowercase) primary language. Returns an empty string if it
+// doesn't look like a language.
+func normaliseLang(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if !langPat.MatchString(tag) {
+		return ""
+	}
+	return tag
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDetectLang(t *testing.T) {
+	tests := []struct {
+		txt, want string
+	}{
+		{"Tesco today announced that it will be cutting the price of milk in all of its stores from Monday, in a move which is expected to be welcomed by shoppers.", "en"},
+		{"Mae Tesco wedi cyhoeddi heddiw y bydd yn torri pris llaeth yn ei holl siopau o ddydd Llun, ac mae hyn yn newyddion da i siopwyr yng Nghymru a hefyd ar draws y wlad.", "cy"},
+		// (too little to go on, so english)
+		{"Milk", "en"},
+	}
+	for _, test := range tests {
+		if got := detectLang(test.txt); got != test.want {
+			t.Errorf("%.20q: got %q, want %q", test.txt, got, test.want)
+		}
+	}
+}
+
+func TestNormaliseLang(t *testing.T) {
+	for tag, want := range map[string]string{"en": "en", "en-GB": "en", " CY_gb ": "cy", "": "", "english": "", "x-klingon": ""} {
+		if got := normaliseLang(tag); got != want {
+			t.Errorf("%q: got %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestLangFromPage(t *testing.T) {
+	pr := &PressRelease{Permalink: "http://example.com/1"}
+	err := GenericScrape("lang", pr, `<html lang="cy-GB"><body><h1>Teitl</h1><p>Milk</p></body></html>`, []string{"h1"}, []string{"p"}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (going by the page rather than the text)
+	if pr.Lang != "cy" {
+		t.Errorf("got lang %q, want cy", pr.Lang)
+	}
+}
+
+func TestLangChannels(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		for i, lang := range []string{"en", "cy", "en"} {
+			if _, err := store.Stash(&PressRelease{Source: "tesco", Permalink: fmt.Sprintf("http://example.com/%d", i), Lang: lang}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got, err := store.Query(QueryOptions{Lang: "cy"}); err != nil || len(got) != 1 || got[0].Lang != "cy" {
+			t.Errorf("%T: got %v (%v) querying for cy", store, got, err)
+		}
+
+		repo := storeRepository{store: store, lang: "en"}
+		var ids []string
+		for id := range repo.Replay(langChannel("tesco", "en"), "0") {
+			ids = append(ids, id)
+		}
+		if strings.Join(ids, ",") != "1,3" {
+			t.Errorf("%T: replayed %v on the en channel, want 1,3", store, ids)
+		}
+		if repo.Get(langChannel(allChannel, "en"), "3") == nil {
+			t.Errorf("%T: no 3 on the en channel", store)
+		}
+	}
+
+	if channel, lang := splitLangChannel(langChannel("tesco", "cy")); channel != "tesco" || lang != "cy" {
+		t.Errorf("got %q, %q back", channel, lang)
+	}
+	if channel, lang := splitLangChannel("tesco"); channel != "tesco" || lang != "" {
+		t.Errorf("got %q, %q for a plain channel", channel, lang)
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// a minimal leveled logger, on top of the standard log package
+
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+// messages below logThreshold are suppressed
+var logThreshold = levelInfo
+
+func (level logLevel) String() string {
+	return logLevelNames[level]
+}
+
+// parseLogLevel converts a level name (eg "warn") into a logLevel
+func parseLogLevel(name string) (logLevel, error) {
+	for i, n := range logLevelNames {
+		if strings.EqualFold(n, name) {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, fmt.Errorf("unknown log level '%s' (expected debug, info, warn or error)", name)
+}
+
+func logf(level logLevel, format string, args ...interface{}) {
+	if level < logThreshold {
+		return
+	}
+	log.Printf(level.String()+" "+format, args...)
+}
+
+func debugf(format string, args ...interface{}) { logf(levelDebug, format, args...) }
+func infof(format string, args ...interface{})  { logf(levelInfo, format, args...) }
+func warnf(format string, args ...interface{})  { logf(levelWarn, format, args...) }
+func errorf(format string, args ...interface{}) { logf(levelError, format, args...) }
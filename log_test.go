@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseLogLevel(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want logLevel
+	}{
+		{"debug", levelDebug},
+		{"INFO", levelInfo},
+		{"Warn", levelWarn},
+		{"error", levelError},
+	} {
+		got, err := parseLogLevel(test.name)
+		if err != nil || got != test.want {
+			t.Errorf("%q: got %v (%v), want %v", test.name, got, err, test.want)
+		}
+	}
+	if _, err := parseLogLevel("loud"); err == nil {
+		t.Errorf("no error for an unknown level")
+	}
+}
+
+func TestLogThreshold(t *testing.T) {
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	flags := log.Flags()
+	log.SetFlags(0)
+	defer func(threshold logLevel) {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+		logThreshold = threshold
+	}(logThreshold)
+
+	logThreshold = levelWarn
+	debugf("debug %d", 1)
+	infof("info %d", 2)
+	warnf("warn %d", 3)
+	errorf("error %d", 4)
+	if want := "WARN warn 3\nERROR error 4\n"; out.String() != want {
+		t.Errorf("got %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	logThreshold = levelDebug
+	debugf("now you see me")
+	if !strings.HasPrefix(out.String(), "DEBUG now you see me") {
+		t.Errorf("got %q at debug level", out.String())
+	}
+}
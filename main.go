@@ -4,10 +4,10 @@ package main
 // 1) periodically scrapes a bunch of press release sources
 // 2) serves up those press releases as server side event endpoints
 //
-// The scraped press releases are persistant, in a sqlite db. The idea is
-// that eventually it'll be set up to keep just a week or so archive, to let
+// The scraped press releases are persistant, in a sqlite db (see -db). By
+// default just a week or so archive is kept (see -retention), to let
 // consumers have a chance to catch up if they go down for a day or two.
-// (but for now, it just keeps adding to the db)
+// (or with -store=mem, they're just kept in memory and lost on exit)
 //
 // Clients connect to:
 //
@@ -22,40 +22,186 @@ package main
 //  $ curl http://localhost:9998/72point/ -H "Last-Event-ID: 0"
 // Will serve up _all_ the stored 72point press releases.
 //
+// If the id is older than anything left in the archive (it's been pruned),
+// the client gets everything that is left. A malformed id, or one newer than
+// anything in the archive, gets no backlog at all - just the new releases.
+//
 // Without last-event-id, the client will be served only new press
 // releases as they come in.
 //
+// New press releases are sent as "new" events (see -new-event, for clients
+// expecting the old "press_release" name, or unnamed events).
+//
+// Recent press releases (published within -recheck hours) are scraped again
+// to pick up edits. Changed ones are stored, and sent out again as "updated"
+// events, without an id (so match them up by permalink).
+//
+// For consumers which only care about corrections, the changes (with the
+// title, excerpt, content hash etc from before and after) also go out on
+// their own streams, which never carry new press releases:
+//
+//   http://<host>:<port>/<source>/updated
+//   http://<host>:<port>/all/updated
+//
+// There's also a combined stream, with the press releases from every
+// source:
+//
+//   http://<host>:<port>/all/
+//
+// Event ids are global, so they're ordered across sources and last-event-id
+// works on the combined stream in just the same way.
+//
+// Any of the streams can be narrowed down to a single language, eg:
+//
+//   http://<host>:<port>/all/?lang=en
+//
+// (the language comes from the page's <html lang>, or failing that a rough
+// guess based on the text)
+//
+// For consumers which don't speak server-sent-events, the latest press
+// releases for each source (or all of them) are also available as rss:
+//
+//   http://<host>:<port>/<source>/rss
+//
+// and /opml lists all the per-source feeds, for subscribing to in one go.
+//
+// The archive can also be browsed as json:
+//
+//   http://<host>:<port>/api/releases?source=tesco&since=2014-03-01T00:00:00Z&limit=10
+//
+// (all params optional, and lang and tag can be used here too). The
+// releases come back as {"releases": [...], "next_cursor": "..."}, with a
+// next_cursor if there are more: pass it back as "after" to get the next
+// page. /api/releases/count takes the same params, and just
+// returns the number of matching releases, as {"count": N}. For simple
+// polling, /api/releases/latest?source=tesco&n=5 returns just the newest
+// n (default 1, at most 50).
+//
+// The available sources are listed at /api/sources, and recent scraping
+// problems (errors, and releases scraped with no content) at
+// /api/errors?source=tesco (source optional).
+//
+// A single press release can be fetched by source and id:
+//
+//   http://<host>:<port>/api/releases/<source>/<id>
+//
+// And searched (words, or "phrases in quotes"), with the same optional
+// params:
+//
+//   http://<host>:<port>/api/search?q=price+cut&source=tesco
+//
+// (the sqlite store needs go-sqlite3 built with the sqlite_fts5 tag for
+// searching)
+//
+// And for visual sanity-checking, there's a simple html browsing interface
+// at:
+//
+//   http://<host>:<port>/browse/
+//
+// Prometheus-style metrics are served up at /metrics. /healthz just
+// returns 200 if the server is up, and /status has the last successful
+// scrape (and last error) for each source, and the size of the store, as
+// json.
+//
+// Browser-based consumers on other origins can be let in with
+// -cors-origins.
+//
+// To keep the server (streams, api, browsing and /status) private, set
+// -auth-user and -auth-pass to require HTTP basic auth. /healthz and
+// /metrics are left open, for load balancers and metrics scrapers.
+//
+// New press releases can also be pushed out as they come in, by POSTing
+// them as json to -webhook-url.
+//
+// Extra selector-based scrapers can be defined in a json file, passed in
+// with -config (see Config), which is reread on a SIGHUP. With
+// -archive-html, the raw html of each press release is kept too, so that
+// -rescrape can rebuild a source's releases after its selectors have been
+// fixed. -backfill fills in the archive of a
+// new deployment from a source's back pages (see ArchiveScraper).
+// -json-dir writes out a json file for each new press release, for easy
+// backups.
+//
+// -guess=<url> has a go at working out the list selector for a new site
+// (see GuessListSelector).
+//
 //
 // TODOs
-// - proper logging and error handling (kill all the panics!)
 // - split up into separate packages (in particular, make it easy to build
 //   a new app with a different bunch of scrapers)
-// - we've already got a http server running, so should implement a simple
-//   browsing interface for visual sanity-checking of press releases.
-// - add a html-scrubbing func to clean up extracted content (remove style
-//   attrs, ids, dodgy elements etc)
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/donovanhide/eventsource"
 	//	"github.com/gorilla/mux"
 	"flag"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
+	"unicode/utf8"
 )
 
-// TODO: support multiple urls
 type PressRelease struct {
 	Title     string
 	Source    string
 	Permalink string
-	PubDate   time.Time
-	Content   string
+	// all the pages which make up the press release, for ones split across
+	// several pages (Permalink should be the first). Empty if there's just
+	// the one page.
+	URLs     []string
+	FinalURL string // where Permalink ended up, after any redirects
+	PubDate  time.Time
+	Content  string
+	Text     string // Content as plaintext, with paragraphs separated by blank lines
+	ImageURL string // the lead image, if there is one
+	Lang     string // language code, eg "en"
+	// who the press release is by (the spokesperson or author byline), if
+	// the page says
+	Author string
+	// a one-line summary (the page's meta description, or the start of
+	// Text), for showing in lists and notifications
+	Excerpt string
+	// the page's own idea of its url (from <link rel="canonical">), if it
+	// says. Used for spotting the same press release under other urls (eg
+	// with tracking params, or the mobile site).
+	CanonicalURL string
+	// contact details, notes to editors etc, from after the end of the
+	// press release proper (as html)
+	Notes string
+	// categories the source files it under, if any
+	Tags []string
+	// sha256 of the (whitespace-normalised) title and content, for
+	// spotting the same press release turning up under different urls
+	ContentHash string
+	// when the source last changed it (zero if it hasn't been since it was
+	// first scraped)
+	LastModified time.Time
+	// where the page's raw html was archived (see -archive-html), if it was.
+	// Kept out of the json, as it's a path on the server.
+	RawHTMLPath string `json:"-"`
 	// if this is a fully-filled out press release, complete is set
 	complete bool
+	// the store's id for it, once it's been stashed (as returned by
+	// Store.Query, for paging through results)
+	id int
+	// simhash of Text, for spotting near-duplicates (see dedup.go)
+	simhash uint64
+	// set by Store.WhichAreNew to the id of the stored press release this
+	// looks like a near-duplicate of (see -dedup)
+	duplicateOf int
 }
 
 // Scraper is the interface to implement to add a new scraper to the system
@@ -67,7 +213,8 @@ type Scraper interface {
 	// The results are passed back as PressRelease structs. At the very least,
 	// the Permalink field must be set to the URL of the press release,
 	// But there's no reason FetchList() can't fill out all the fields if the
-	// data is available (eg some rss feeds have everything required).
+	// data is available (eg some rss feeds have everything required - see
+	// RSSFetchList, which marks the ones it fills out as complete).
 	// For incomplete PressReleases, the framework will fetch the HTML from
 	// the Permalink URL, and invoke Scrape() to complete the data.
 	FetchList() ([]*PressRelease, error)
@@ -76,57 +223,723 @@ type Scraper interface {
 	Scrape(*PressRelease, string) error
 }
 
+// ScraperMeta describes a source, for listing them out (see
+// /api/sources)
+type ScraperMeta struct {
+	DisplayName string // a human-friendly name
+	BaseURL     string // the source's website
+}
+
+// MetaScraper can be implemented by scrapers to describe their source.
+// Otherwise, the name is used as the display name.
+type MetaScraper interface {
+	Meta() ScraperMeta
+}
+
+// scraperMeta returns the ScraperMeta for a scraper, with the blanks
+// filled in
+func scraperMeta(scraper Scraper) ScraperMeta {
+	var meta ScraperMeta
+	if s, ok := scraper.(MetaScraper); ok {
+		meta = s.Meta()
+	}
+	if meta.DisplayName == "" {
+		meta.DisplayName = scraper.Name()
+	}
+	return meta
+}
+
+// IntervalScraper can be implemented by scrapers which want to be polled
+// more (or less) often than the global -interval. Returning zero means
+// just use -interval.
+type IntervalScraper interface {
+	Interval() time.Duration
+}
+
+// ArchiveScraper can be implemented by scrapers for sites with paginated
+// archives, so -backfill can dig back through them.
+type ArchiveScraper interface {
+	// FetchArchivePage fetches the list of press releases on a page of the
+	// archives (numbered from 1, newest first). An empty list means
+	// there are no more pages.
+	FetchArchivePage(page int) ([]*PressRelease, error)
+}
+
+// PoliteScraper can be implemented by scrapers for sites which need
+// treating more (or less) gently than the global -concurrency and
+// -request-delay allow, eg Concurrency: 1 for fragile ones.
+type PoliteScraper interface {
+	Politeness() Politeness
+}
+
+// ContextScraper can be implemented by scrapers which can give up part way
+// through scraping a page (eg ones which make more requests of their own)
+// when ctx is done - when the press release is taking too long (see
+// -scrape-timeout) or the run has gone on past the end of the cycle. It's
+// used in place of Scrape. Scrapers which don't implement it are still cut
+// off at the deadline, they just don't find out.
+type ContextScraper interface {
+	ScrapeContext(ctx context.Context, pr *PressRelease, rawHTML string) error
+}
+
+// ContextListScraper is the same thing for FetchList.
+type ContextListScraper interface {
+	FetchListContext(ctx context.Context) ([]*PressRelease, error)
+}
+
+// WatermarkScraper can be implemented by scrapers for sources whose index
+// is in date order (newest first), so they can stop listing press releases
+// once they get to ones which must already be in the store (see
+// GenericFetchListSince). since is around the publication date of the
+// latest one stored (see listWatermark), and is never zero (FetchList is
+// used when there isn't one).
+type WatermarkScraper interface {
+	FetchListSince(since time.Time) ([]*PressRelease, error)
+}
+
+// ValidatingScraper can be implemented by scrapers which can check over
+// their own setup (urls, selectors and so on), so a misconfigured one is
+// caught at startup rather than mid-scrape. The error should say which
+// scraper it is.
+type ValidatingScraper interface {
+	Validate() error
+}
+
+// validateScrapers runs Validate on all the scrapers which have it,
+// returning the first error (going by name, so it's always the same one)
+func validateScrapers(scrapers map[string]Scraper) error {
+	var names []string
+	for name := range scrapers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if s, ok := scrapers[name].(ValidatingScraper); ok {
+			if err := s.Validate(); err != nil {
+				return fmt.Errorf("invalid scraper: %s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Politeness settings for a source. Zero fields mean just use the global
+// settings.
+type Politeness struct {
+	Concurrency int           // number of press releases to fetch at once
+	Delay       time.Duration // minimum delay between requests to the site
+}
+
+// aliases returns all the urls the press release is known by: its
+// permalink, where that ended up, and its canonical url (skipping any which
+// aren't known, or are the same).
+func (pr *PressRelease) aliases() []string {
+	urls := []string{pr.Permalink}
+	for _, u := range []string{pr.FinalURL, pr.CanonicalURL} {
+		if u != "" && u != urls[0] && (len(urls) < 2 || u != urls[1]) {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// pages returns the urls of all the pages which make up the press release
+func (pr *PressRelease) pages() []string {
+	if len(pr.URLs) == 0 {
+		return []string{pr.Permalink}
+	}
+	return pr.URLs
+}
+
+// errPanic is returned (wrapped up) by safeScrape, safeFetchList and scrape
+// when a scraper (or the fetching for one) panics
+var errPanic = errors.New("scraper panicked")
+
+// safeScrape runs scraper.Scrape (or ScrapeContext - see ContextScraper),
+// turning any panic (eg a nil dereference on some unexpectedly-shaped
+// html) into an error, so one bad page can't bring the whole server down.
+func safeScrape(ctx context.Context, scraper Scraper, pr *PressRelease, rawHTML string) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			errorf("%s: panic scraping %s: %v\n%s", scraper.Name(), pr.Permalink, r, debug.Stack())
+			err = fmt.Errorf("%s: %v", errPanic, r)
+		}
+	}()
+	if s, ok := scraper.(ContextScraper); ok {
+		return s.ScrapeContext(ctx, pr, rawHTML)
+	}
+	return scraper.Scrape(pr, rawHTML)
+}
+
+// safeFetchList runs scraper.FetchList (or FetchListContext - see
+// ContextListScraper, or FetchListSince if since isn't zero - see
+// WatermarkScraper), turning any panic into an error (as for safeScrape).
+// It returns once ctx is done, even if the scraper hasn't finished.
+func safeFetchList(ctx context.Context, scraper Scraper, since time.Time) ([]*PressRelease, error) {
+	type result struct {
+		prs []*PressRelease
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		var res result
+		defer func() {
+			if r := recover(); r != nil {
+				errorf("%s: panic fetching list: %v\n%s", scraper.Name(), r, debug.Stack())
+				res = result{nil, fmt.Errorf("%s: %v", errPanic, r)}
+			}
+			done <- res
+		}()
+		if s, ok := scraper.(WatermarkScraper); ok && !since.IsZero() {
+			res.prs, res.err = s.FetchListSince(since)
+		} else if s, ok := scraper.(ContextListScraper); ok {
+			res.prs, res.err = s.FetchListContext(ctx)
+		} else {
+			res.prs, res.err = scraper.FetchList()
+		}
+	}()
+	select {
+	case res := <-done:
+		return res.prs, res.err
+	case <-ctx.Done():
+		return nil, &ScrapeError{Kind: ErrTimeout, Err: ctx.Err()}
+	}
+}
+
 // helper to fetch and scrape an individual press release
-func scrape(scraper Scraper, pr *PressRelease) error {
-	resp, err := http.Get(pr.Permalink)
-	if err != nil {
+// If the press release is spread across multiple pages, each one is scraped
+// in turn and the content concatenated. The title, pubdate etc come from the
+// first page.
+// It gives up with an ErrTimeout once ctx is done, or after -scrape-timeout,
+// whichever comes first. Panics are turned into errors (see errPanic).
+func scrape(ctx context.Context, scraper Scraper, pr *PressRelease) error {
+	if *scrapeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(*scrapeTimeout)*time.Second)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return &ScrapeError{Kind: ErrTimeout, URL: pr.Permalink, Err: err}
+	}
+	// (the work is done on a copy, so a scrape which is abandoned can't go
+	// on changing pr after we've returned)
+	cpy := *pr
+	done := make(chan error, 1)
+	go func() {
+		// (safeScrape only covers the scraper - this catches the rest,
+		// eg fetching and archiving the pages, which would otherwise take
+		// the whole server down with them)
+		defer func() {
+			if r := recover(); r != nil {
+				errorf("%s: panic fetching %s: %v\n%s", scraper.Name(), pr.Permalink, r, debug.Stack())
+				done <- &ScrapeError{Kind: ErrParse, URL: pr.Permalink, Err: fmt.Errorf("%s: %v", errPanic, r)}
+			}
+		}()
+		done <- scrapePages(ctx, scraper, &cpy)
+	}()
+	select {
+	case err := <-done:
+		if err != nil && ctx.Err() != nil {
+			// (a ContextScraper which gave up isn't choking on the page)
+			return &ScrapeError{Kind: ErrTimeout, URL: pr.Permalink, Err: ctx.Err()}
+		}
+		*pr = cpy
 		return err
+	case <-ctx.Done():
+		return &ScrapeError{Kind: ErrTimeout, URL: pr.Permalink, Err: ctx.Err()}
 	}
-	defer resp.Body.Close()
-	html, err := ioutil.ReadAll(resp.Body)
+}
+
+// scrapePages does the work for scrape
+func scrapePages(ctx context.Context, scraper Scraper, pr *PressRelease) error {
+	pages := pr.pages()
+	html, finalURL, err := fetchPage(scraper, pages[0])
 	if err != nil {
-		return err
+		return scrapeError(ErrFetch, pages[0], err)
+	}
+	pr.FinalURL = finalURL
+	if *archiveDir != "" {
+		// (only the first page is archived)
+		path, err := archiveHTML(*archiveDir, scraper.Name(), html)
+		if err != nil {
+			warnf("%s: archiving %s: %s", scraper.Name(), pages[0], err)
+		} else {
+			pr.RawHTMLPath = path
+		}
+	}
+	err = safeScrape(ctx, scraper, pr, html)
+	if err != nil {
+		// (scrapers which don't say otherwise are taken to have choked on
+		// the page)
+		return scrapeError(ErrParse, pages[0], err)
 	}
 
-	// TODO: collect redirects
+	for _, pageURL := range pages[1:] {
+		html, _, err := fetchPage(scraper, pageURL)
+		if err != nil {
+			return scrapeError(ErrFetch, pageURL, err)
+		}
+		page := PressRelease{Title: pr.Title, Source: pr.Source, Permalink: pageURL, PubDate: pr.PubDate}
+		err = safeScrape(ctx, scraper, &page, html)
+		if err != nil {
+			return scrapeError(ErrParse, pageURL, err)
+		}
+		pr.Content += page.Content
+	}
+	return nil
+}
 
-	err = scraper.Scrape(pr, string(html))
+// fetchPage fetches a single page for scraping, returning the html and the
+// url it ended up at after any redirects.
+func fetchPage(scraper Scraper, pageURL string) (string, string, error) {
+	allowed, err := robotsAllowed(pageURL, userAgent)
 	if err != nil {
-		return err
+		return "", "", err
 	}
-	return nil
+	if !allowed {
+		return "", "", errDisallowed
+	}
+
+	// collect redirects, so we know where we actually end up
+	var hops []string
+	client := *httpClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		hops = append(hops, req.URL.String())
+		return nil
+	}
+
+	start := time.Now()
+	resp, err := retryingGet(&client, pageURL)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	body, err := utf8Body(resp)
+	if err != nil {
+		return "", "", err
+	}
+	html, err := ioutil.ReadAll(body)
+	if err != nil {
+		return "", "", err
+	}
+	fetchDuration.observe(scraper.Name(), time.Since(start).Seconds())
+
+	// (going by the response rather than hops, which is empty if the page
+	// came from the page cache)
+	finalURL := resp.Request.URL.String()
+	if len(hops) > 0 {
+		debugf("%s: %s redirected to %s (%d hops)", scraper.Name(), pageURL, finalURL, len(hops))
+	}
+	return string(html), finalURL, nil
 }
 
 // run a scraper
-func doit(scraper Scraper, store *Store, sseSrv *eventsource.Server) {
+// Errors are logged rather than returned - one broken source shouldn't
+// stop the others from being scraped.
+// A run gets until the source is next due to be polled: anything not done
+// by then is left for the next run.
+func doit(scraper Scraper, store Store, sseSrv *eventsource.Server) {
+	setRequestDelay(scraper, scraperMeta(scraper).BaseURL)
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeInterval(scraper))
+	defer cancel()
 
-	pressReleases, err := scraper.FetchList()
+	// (if this run leaves anything undone, the next one lists everything)
+	since := listWatermark(scraper, store)
+	clean := false
+	defer func() { cleanRuns.set(scraper.Name(), clean) }()
+	pressReleases, err := safeFetchList(ctx, scraper, since)
+	if errors.Is(err, errProbableOutage) {
+		// not a quiet day - the site's having problems
+		warnf("%s: fetching list: %s, trying again next time", scraper.Name(), err)
+		scrapeStatus.failure(scraper.Name(), err)
+		scrapeProblems.add(scraper.Name(), "", "fetching list: "+err.Error())
+		return
+	}
 	if err != nil {
-		panic(err)
+		if errors.Is(err, ErrFetch) || errors.Is(err, ErrTimeout) {
+			// (probably just a blip - it's tried again next time round)
+			warnf("%s: fetching list: %s", scraper.Name(), err)
+		} else {
+			errorf("%s: fetching list: %s", scraper.Name(), err)
+		}
+		scrapeErrors.inc(scraper.Name())
+		scrapeStatus.failure(scraper.Name(), err)
+		scrapeProblems.add(scraper.Name(), "", "fetching list: "+err.Error())
+		return
+	}
+	releasesFetched.add(scraper.Name(), float64(len(pressReleases)))
+	for _, pr := range pressReleases {
+		setRequestDelay(scraper, pr.Permalink)
 	}
 
 	// cull out the ones we've already got
 	oldCount := len(pressReleases)
-	pressReleases = store.WhichAreNew(pressReleases)
-	log.Printf("%s: %d releases (%d new)", scraper.Name(), oldCount, len(pressReleases))
-	// for all the new ones:
-	for _, pr := range pressReleases {
-		if !pr.complete {
-			err = scrape(scraper, pr)
+	listed := pressReleases
+	pressReleases, err = store.WhichAreNew(pressReleases)
+	if err != nil {
+		errorf("%s: checking store: %s", scraper.Name(), err)
+		scrapeStatus.failure(scraper.Name(), err)
+		return
+	}
+	infof("%s: %d releases (%d new)", scraper.Name(), oldCount, len(pressReleases))
+	known := alreadyKnown(listed, pressReleases)
+	// (leaving out any which couldn't be scraped recently, and won't have
+	// changed - see givenUp)
+	fresh := skipGivenUp(scraper.Name(), pressReleases)
+	clean = len(fresh) == len(pressReleases)
+	pressReleases = fresh
+	if *maxPerCycle > 0 && len(pressReleases) > *maxPerCycle {
+		clean = false
+		infof("%s: only doing %d of the %d new releases this time round", scraper.Name(), *maxPerCycle, len(pressReleases))
+		pressReleases = oldestN(pressReleases, *maxPerCycle)
+	}
+
+	// fetch and scrape the new ones, a few at a time
+	ok := scrapeAll(ctx, scraper, pressReleases, scrapeConcurrency(scraper))
+
+	for i, pr := range pressReleases {
+		if !ok[i] {
+			clean = false
+			continue
+		}
+		ev := stashAndPublish(scraper, store, sseSrv, pr)
+		if ev == nil {
+			continue
+		}
+		if hook != nil {
+			hook.send(ev.Id(), pr)
+		}
+	}
+
+	if *recheckFlag > 0 {
+		recheck(ctx, scraper, store, sseSrv, known)
+	}
+	scrapeStatus.success(scraper.Name())
+}
+
+// cleanRuns remembers which sources had nothing left over (failed,
+// given up on, or put off by -max-per-cycle) at the end of their last run.
+// Only those get a watermark (see listWatermark), or a conditional GET of
+// their index pages (see fetchLinks) - the rest list everything, so the
+// leftovers aren't missed.
+var cleanRuns = &runRecord{clean: make(map[string]bool)}
+
+type runRecord struct {
+	sync.Mutex
+	clean map[string]bool
+}
+
+func (rr *runRecord) set(source string, clean bool) {
+	rr.Lock()
+	defer rr.Unlock()
+	rr.clean[source] = clean
+}
+
+func (rr *runRecord) wasClean(source string) bool {
+	rr.Lock()
+	defer rr.Unlock()
+	return rr.clean[source]
+}
+
+// listWatermark returns the since to list a source's press releases from
+// (see WatermarkScraper): the publication date of the one stashed most
+// recently, or if that's more recent, the start of the -recheck window (so
+// the ones due a recheck are still listed). It's zero (ie list everything) for
+// scrapers which aren't WatermarkScrapers, and after a run which left
+// anything undone - including the first one, as it's not known how the
+// last run before a restart went.
+func listWatermark(scraper Scraper, store Store) time.Time {
+	if _, ok := scraper.(WatermarkScraper); !ok || !cleanRuns.wasClean(scraper.Name()) {
+		return time.Time{}
+	}
+	newest, err := store.Query(QueryOptions{Source: scraper.Name(), Limit: 1})
+	if err != nil {
+		errorf("%s: checking store: %s", scraper.Name(), err)
+		return time.Time{}
+	}
+	if len(newest) == 0 {
+		return time.Time{}
+	}
+	since := newest[0].PubDate
+	if *recheckFlag > 0 {
+		if window := time.Now().Add(-time.Duration(*recheckFlag) * time.Hour); window.Before(since) {
+			since = window
+		}
+	}
+	return since
+}
+
+// publishLock is held while a press release is stashed and broadcast, so
+// events go out in id order, even with several sources on the go at once.
+// (otherwise a client which saw the later of two events and then
+// reconnected would never get the earlier one, as the replay only covers
+// ids after its Last-Event-ID)
+var publishLock sync.Mutex
+
+// stashAndPublish stashes a freshly-scraped press release (see stashNew)
+// and broadcasts it to any connected clients. Returns nil if it wasn't
+// stashed.
+func stashAndPublish(scraper Scraper, store Store, sseSrv *eventsource.Server, pr *PressRelease) *pressReleaseEvent {
+	publishLock.Lock()
+	defer publishLock.Unlock()
+	ev := stashNew(scraper, store, pr)
+	if ev != nil {
+		sseSrv.Publish(eventChannels(pr), ev)
+	}
+	return ev
+}
+
+// stashNew stashes a freshly-scraped press release (and writes it out for
+// -json-dir), unless it's too short or turns out to be one we've already
+// got. Returns nil if it wasn't stashed (failures are logged).
+func stashNew(scraper Scraper, store Store, pr *PressRelease) *pressReleaseEvent {
+	if tooShort(scraper, pr) {
+		return nil
+	}
+
+	// now we've got the content (and know where any redirects ended
+	// up, and the page's canonical url) it might turn out we've already
+	// got it
+	unseen, err := store.WhichAreNew([]*PressRelease{pr})
+	if err != nil {
+		errorf("%s: checking store: %s", scraper.Name(), err)
+		return nil
+	}
+	if len(unseen) == 0 {
+		if pr.duplicateOf != 0 {
+			// keep a note of it, so it's not fetched again
+			infof("%s: %s looks like a duplicate of %d", scraper.Name(), pr.Permalink, pr.duplicateOf)
+			err = store.LinkDuplicate(pr.duplicateOf, pr.Permalink)
 			if err != nil {
-				log.Printf("ERROR '%s' %s\n", err, pr.Permalink)
-				continue
+				errorf("%s: linking %s to %d: %s", scraper.Name(), pr.Permalink, pr.duplicateOf, err)
 			}
-			pr.complete = true
+			return nil
 		}
+		debugf("%s: already got %s (as %s)", scraper.Name(), pr.Permalink, pr.FinalURL)
+		return nil
+	}
+
+	ev, err := store.Stash(pr)
+	if err == errAlreadyStashed {
+		// (beaten to it, eg by an overlapping backfill)
+		debugf("%s: already stashed %s", scraper.Name(), pr.Permalink)
+		return nil
+	}
+	if err != nil {
+		errorf("%s: stashing %s: %s", scraper.Name(), pr.Permalink, err)
+		scrapeErrors.inc(scraper.Name())
+		scrapeProblems.add(scraper.Name(), pr.Permalink, "stashing: "+err.Error())
+		return nil
+	}
+	releasesStashed.inc(scraper.Name())
+	debugf("%s: stashed %s", scraper.Name(), pr.Permalink)
+	if *jsonDir != "" {
+		err = dumpJSON(*jsonDir, ev)
+		if err != nil {
+			errorf("%s: writing json for %s: %s", scraper.Name(), pr.Permalink, err)
+		}
+	}
+	return ev
+}
+
+// eventChannels returns the channels a press release goes out on
+func eventChannels(pr *PressRelease) []string {
+	return []string{pr.Source, allChannel, langChannel(pr.Source, pr.Lang), langChannel(allChannel, pr.Lang)}
+}
+
+// changeChannels returns the channels a change to a stored press release
+// goes out on (see changeEvent), as well as its eventChannels
+func changeChannels(pr *PressRelease) []string {
+	return []string{updatedChannel(pr.Source), updatedChannel(allChannel)}
+}
 
-		// stash the new press release
-		ev := store.Stash(pr)
-		log.Printf("%s: stashed %s", scraper.Name(), pr.Permalink)
+// tooShort returns true (and logs it) if a freshly-scraped press release has
+// less than -min-content chars of text - probably a broken selector
+// picking out the wrong bit of the page
+func tooShort(scraper Scraper, pr *PressRelease) bool {
+	n := utf8.RuneCountInString(strings.TrimSpace(pr.Text))
+	if n >= *minContent {
+		return false
+	}
+	warnf("%s: suspected scrape failure, only %d chars of text in %s", scraper.Name(), n, pr.Permalink)
+	scrapeErrors.inc(scraper.Name())
+	scrapeProblems.add(scraper.Name(), pr.Permalink, fmt.Sprintf("too short (%d chars of text)", n))
+	return true
+}
 
-		// broadcast it to any connected clients
-		sseSrv.Publish([]string{pr.Source}, ev)
+// alreadyKnown returns the press releases in listed which aren't in unseen
+// (as returned by WhichAreNew)
+func alreadyKnown(listed, unseen []*PressRelease) []*PressRelease {
+	isNew := make(map[*PressRelease]bool)
+	for _, pr := range unseen {
+		isNew[pr] = true
 	}
+	var known []*PressRelease
+	for _, pr := range listed {
+		if !isNew[pr] {
+			known = append(known, pr)
+		}
+	}
+	return known
+}
+
+// oldestN picks out the n oldest press releases (for -max-per-cycle).
+// Indexes list the newest first, so unless they all have dates, that's the
+// ones at the end. Order is otherwise kept.
+func oldestN(prs []*PressRelease, n int) []*PressRelease {
+	if len(prs) <= n {
+		return prs
+	}
+	for _, pr := range prs {
+		if pr.PubDate.IsZero() {
+			return prs[len(prs)-n:]
+		}
+	}
+	sorted := make([]*PressRelease, len(prs))
+	copy(sorted, prs)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].PubDate.Before(sorted[j].PubDate) })
+	cutoff := sorted[n-1].PubDate
+	ties := n
+	for _, pr := range sorted[:n] {
+		if pr.PubDate.Before(cutoff) {
+			ties--
+		}
+	}
+	var out []*PressRelease
+	for _, pr := range prs {
+		if pr.PubDate.Before(cutoff) {
+			out = append(out, pr)
+		} else if pr.PubDate.Equal(cutoff) && ties > 0 {
+			out = append(out, pr)
+			ties--
+		}
+	}
+	return out
+}
+
+// recheck re-scrapes press releases we've already got which are still
+// fairly fresh (published within the last -recheck hours), to pick up any
+// edits the source has made since (corrections, added quotes etc).
+// Changed ones are updated in the store, and sent out as "updated" events.
+func recheck(ctx context.Context, scraper Scraper, store Store, sseSrv *eventsource.Server, known []*PressRelease) {
+	cutoff := time.Now().Add(-time.Duration(*recheckFlag) * time.Hour)
+	var prs []*PressRelease
+	var stored []*pressReleaseEvent
+	for _, pr := range known {
+		ev, err := store.Lookup(pr.Source, pr.Permalink)
+		if err != nil {
+			if err != errNotFound {
+				errorf("%s: checking store: %s", scraper.Name(), err)
+			}
+			continue
+		}
+		if ev.payload.PubDate.Before(cutoff) {
+			continue
+		}
+		prs = append(prs, pr)
+		stored = append(stored, ev)
+	}
+	if len(prs) == 0 {
+		return
+	}
+	debugf("%s: rechecking %d releases", scraper.Name(), len(prs))
+
+	ok := scrapeAll(ctx, scraper, prs, scrapeConcurrency(scraper))
+	for i, pr := range prs {
+		if !ok[i] || tooShort(scraper, pr) {
+			continue
+		}
+		old := stored[i].payload
+		oldHash := old.ContentHash
+		if oldHash == "" {
+			oldHash = contentHash(old)
+		}
+		if pr.ContentHash == oldHash {
+			continue
+		}
+		pr.PubDate = old.PubDate
+		ev, err := store.Update(stored[i].id, pr)
+		if err != nil {
+			errorf("%s: updating %s: %s", scraper.Name(), pr.Permalink, err)
+			scrapeErrors.inc(scraper.Name())
+			continue
+		}
+		releasesUpdated.inc(scraper.Name())
+		infof("%s: %s has been updated", scraper.Name(), pr.Permalink)
+		sseSrv.Publish(eventChannels(pr), ev)
+		sseSrv.Publish(changeChannels(pr), &changeEvent{id: ev.id, old: old, latest: ev.payload})
+	}
+}
+
+// scrapeAll completes a batch of press releases, using up to n workers to
+// fetch and scrape the incomplete ones in parallel.
+// Returns a slice (in the same order as pressReleases) flagging the ones
+// which are good to go - failures are logged and left out. Once ctx is
+// done, the rest are left out too (they're picked up next time round).
+func scrapeAll(ctx context.Context, scraper Scraper, pressReleases []*PressRelease, n int) []bool {
+	if n < 1 {
+		n = 1
+	}
+	ok := make([]bool, len(pressReleases))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < n; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				pr := pressReleases[i]
+				if ctx.Err() != nil {
+					debugf("%s: out of time, leaving %s for next time", scraper.Name(), pr.Permalink)
+					continue
+				}
+				if !pr.complete {
+					err := scrape(ctx, scraper, pr)
+					if err != nil {
+						if retryable(err) {
+							// (it's not stashed, so it's tried again next
+							// time round)
+							warnf("%s: scraping %s: %s", scraper.Name(), pr.Permalink, err)
+						} else {
+							errorf("%s: scraping %s: %s (giving up on it for %s)", scraper.Name(), pr.Permalink, err, giveUpFor)
+							giveUp(scraper.Name(), pr)
+						}
+						scrapeErrors.inc(scraper.Name())
+						scrapeProblems.add(scraper.Name(), pr.Permalink, "scraping: "+err.Error())
+						continue
+					}
+					pr.complete = true
+					if isEmpty(pr) {
+						warnf("%s: no content in %s", scraper.Name(), pr.Permalink)
+						scrapeProblems.add(scraper.Name(), pr.Permalink, "empty content")
+					}
+				}
+				pr.ContentHash = contentHash(pr)
+				// (so they're ordered and sent out consistently)
+				pr.PubDate = pr.PubDate.UTC()
+				if pr.Text == "" {
+					pr.Text = fragmentText(pr.Content)
+				}
+				if pr.Excerpt == "" {
+					pr.Excerpt = excerpt(pr.Text)
+				}
+				pr.simhash = simhash(pr.Title + " " + pr.Text)
+				if pr.Lang == "" {
+					pr.Lang = detectLang(pr.Title + " " + pr.Text)
+				}
+				ok[i] = true
+			}
+		}()
+	}
+	for i := range pressReleases {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return ok
 }
 
 var port = flag.Int("port", 9998, "port to run server on")
@@ -134,10 +947,133 @@ var interval = flag.Int("interval", 60*10, "interval at which to poll source sit
 var testScraper = flag.String("t", "", "Test an individual scraper")
 var briefFlag = flag.Bool("b", false, "Brief (testing mode output)")
 var listFlag = flag.Bool("l", false, "List scrapers")
+var dryRunFlag = flag.Bool("n", false, "Dry run (with -t): just fetch the index and list the permalinks found")
+var maxBody = flag.Int64("max-body", 10<<20, "max size of the pages read from source sites (in bytes, 0 = no limit)")
+var pageCacheSize = flag.Int("page-cache", 100, "number of recently fetched pages to keep in memory for a minute, so they don't have to be fetched again (0 = off)")
+var scrapeTimeout = flag.Int("scrape-timeout", 120, "most time to spend fetching and scraping a single press release, retries and all (in seconds, 0 = only limited by the end of the cycle)")
+var fetchTimeout = flag.Int("fetch-timeout", 30, "timeout for fetching pages from source sites (in seconds)")
+var concurrency = flag.Int("concurrency", 4, "number of press releases to fetch at once, per source")
+var globalConcurrency = flag.Int("global-concurrency", 16, "most requests to source sites in flight at once, across all the sources (0 = no limit)")
+var requestDelay = flag.Int("request-delay", 1000, "minimum delay between requests to the same host (in milliseconds)")
+var guessFlag = flag.String("guess", "", "fetch an index page and guess the selector for the press release links on it (to help write a -config), then exit")
+var insecureTLS = flag.Bool("insecure-tls", false, "don't check the TLS certificates of source sites (for ones with expired or mismatched certs)")
+var proxyFlag = flag.String("proxy", "", "proxy to fetch source sites through, eg http://proxy:3128 or socks5://localhost:1080")
+var userAgentFlag = flag.String("user-agent", userAgent, "User-Agent to send to source sites")
+var storeFlag = flag.String("store", "sqlite", "where to keep the press releases: sqlite or mem (nothing kept between runs)")
+var dbFlag = flag.String("db", "./prstore.db", "sqlite db file to keep the press releases in (for -store=sqlite)")
+var logLevelFlag = flag.String("log-level", "info", "minimum level of log messages to show: debug, info, warn or error")
+var retriesFlag = flag.Int("retries", maxRetries, "number of times to retry fetching a press release after a transient error")
+var sourcesFlag = flag.String("sources", "", "comma-separated list of the sources to run (default all of them)")
+var archiveDir = flag.String("archive-html", "", "directory to keep a copy of the raw html of each press release in (off if empty)")
+var stripParams = flag.String("strip-params", "utm_*,ref,fbclid,gclid,dclid,msclkid,mc_cid,mc_eid,_ga,_hsenc,_hsmi,jsessionid,phpsessid,aspsessionid*,sessionid", "comma-separated list of query params to strip off the links found on index pages, as they're tracking or session ids (* on the end matches a prefix)")
+var maxPerCycle = flag.Int("max-per-cycle", 100, "most new press releases to fetch per source each time round (the rest wait for later ones, oldest first); 0 = no limit")
+var dedupFlag = flag.Float64("dedup", 0, "treat press releases at least this similar (0-1, eg 0.8) to one already stored from the same source as duplicates (0 = off)")
+var replayBatch = flag.Int("replay-batch", 500, "number of event ids read from the store at a time when replaying the backlog to a client (see Last-Event-ID)")
+var newEventFlag = flag.String("new-event", "new", "sse event type for new press releases (press_release for the old name, or empty to send them as unnamed message events)")
+var jsonDir = flag.String("json-dir", "", "directory to also write each new press release to, as <source>/<id>.json (off if empty)")
+var rescrapeFlag = flag.String("rescrape", "", "re-scrape the stored press releases for a source from their archived html (see -archive-html), then exit")
+var backfillFlag = flag.String("backfill", "", "fetch and stash everything in a source's archives (for a new deployment), then exit")
+var backfillPages = flag.Int("backfill-pages", 0, "max number of archive pages to go through for -backfill (0 = all of them)")
+var configFile = flag.String("config", "", "json file defining extra (selector-based) scrapers")
+var accessLogFlag = flag.Bool("access-log", false, "log every request to the server (at info level), and when event streams open and close")
+var corsFlag = flag.String("cors-origins", "", "comma-separated list of origins allowed to make cross-origin requests (* for any)")
+var authUser = flag.String("auth-user", "", "username required (via HTTP basic auth) to access the server (empty = open to all)")
+var authPass = flag.String("auth-pass", "", "password required (via HTTP basic auth) to access the server")
+var webhookURL = flag.String("webhook-url", "", "url to POST new press releases to, as json")
+var webhookTimeout = flag.Int("webhook-timeout", 10, "timeout for each webhook delivery attempt (in seconds)")
+var minContent = flag.Int("min-content", 200, "minimum length of a press release's text (in chars) - shorter ones are taken to be scraping failures and not stored")
+var heartbeatFlag = flag.Int("heartbeat", 15, "interval between keep-alive comments on idle event streams, to stop proxies dropping them (in seconds, 0 = off)")
+var recheckFlag = flag.Int("recheck", 24, "re-scrape press releases published within this many hours, to pick up edits (0 = off)")
+var retention = flag.Int("retention", 7, "number of days to keep press releases in the store (0 = keep forever)")
+var vacuumFlag = flag.Bool("vacuum", false, "vacuum the store after pruning, to reclaim disk space (blocks stashing while it runs)")
+
+func init() {
+	flag.BoolVar(dryRunFlag, "dry-run", false, "same as -n")
+}
 
 func main() {
 	flag.Parse()
+	err := run()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// streamHandler serves up the server-sent-event stream for a channel.
+// With a lang param (eg ?lang=en) only press releases in that language are
+// sent.
+// A keep-alive comment is sent every -heartbeat seconds (see withHeartbeat).
+func streamHandler(sseSrv *eventsource.Server, store Store, channel string) http.HandlerFunc {
+	all := sseSrv.Handler(channel)
+	return withHeartbeat(func(w http.ResponseWriter, r *http.Request) {
+		param := r.URL.Query().Get("lang")
+		if param == "" {
+			all(w, r)
+			return
+		}
+		lang := normaliseLang(param)
+		if lang == "" {
+			http.Error(w, "bad lang", http.StatusBadRequest)
+			return
+		}
+		// (the per-language channels only get registered when someone
+		// asks for them)
+		ch := langChannel(channel, lang)
+		sseSrv.Register(ch, storeRepository{store, lang})
+		sseSrv.Handler(ch)(w, r)
+	}, time.Duration(*heartbeatFlag)*time.Second)
+}
+
+// updatesHandler serves up the stream of changes to stored press releases
+// for a channel (see updatedChannel), with keep-alives as for streamHandler.
+func updatesHandler(sseSrv *eventsource.Server, channel string) http.HandlerFunc {
+	return withHeartbeat(sseSrv.Handler(updatedChannel(channel)), time.Duration(*heartbeatFlag)*time.Second)
+}
+
+// testRun runs a single scraper (for -t), printing out what it finds.
+// With dryRun set, only the index is fetched, and just the permalinks are
+// printed - handy for checking the list selector on its own.
+func testRun(scraper Scraper, dryRun bool, brief bool) error {
+	ctx := context.Background()
+	pressReleases, err := safeFetchList(ctx, scraper, time.Time{})
+	if err != nil {
+		return err
+	}
+	if dryRun {
+		infof("%s: %d releases", scraper.Name(), len(pressReleases))
+		for _, pr := range pressReleases {
+			fmt.Println(pr.Permalink)
+		}
+		return nil
+	}
+	for _, pr := range pressReleases {
+		if !pr.complete {
+			infof("%s: scrape %s", scraper.Name(), pr.Permalink)
+			err = scrape(ctx, scraper, pr)
+			if err != nil {
+				warnf("%s: scraping %s: %s", scraper.Name(), pr.Permalink, err)
+				continue
+			}
+			pr.complete = true
+		}
+
+		if !brief {
+			fmt.Printf("%s\n %s\n %s\n", pr.Title, pr.PubDate, pr.Permalink)
+			fmt.Println("")
+			fmt.Println(pr.Content)
+			fmt.Println("------------------------------")
+		} else {
+			fmt.Printf("%s %s\n", pr.Title, pr.Permalink)
+		}
+	}
+	return nil
+}
 
+// loadScrapers sets up the scrapers to run: the builtin ones, plus any
+// from the -config file, cut down to the -sources list if there is one.
+// They're all validated (see ValidatingScraper) before being returned.
+// (called again on SIGHUP, to pick up changes to the config file)
+func loadScrapers() (map[string]Scraper, error) {
 	scrapers := make(map[string]Scraper)
 
 	foo := [...]Scraper{
@@ -154,74 +1090,456 @@ func main() {
 		name := scraper.Name()
 		scrapers[name] = scraper
 	}
+	// any extra scrapers from the config file (which can also replace the
+	// builtin ones)
+	if *configFile != "" {
+		cfg, err := loadConfig(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, scraper := range cfg.Scrapers {
+			scrapers[scraper.Name()] = scraper
+		}
+	}
+	if *sourcesFlag != "" {
+		var err error
+		scrapers, err = filterScrapers(scrapers, *sourcesFlag)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := validateScrapers(scrapers); err != nil {
+		return nil, err
+	}
+	return scrapers, nil
+}
+
+// checkScraperNames makes sure the scraper names will do as channel names
+func checkScraperNames(scrapers map[string]Scraper) error {
+	for name := range scrapers {
+		if name == allChannel {
+			return fmt.Errorf("scraper name '%s' is reserved", name)
+		}
+		if strings.Contains(name, ":") {
+			// (used for the per-language channels)
+			return fmt.Errorf("scraper name '%s' can't contain ':'", name)
+		}
+	}
+	return nil
+}
+
+// filterScrapers picks out the scrapers named in a comma-separated list
+// (for -sources). It's an error if any of them don't exist.
+func filterScrapers(scrapers map[string]Scraper, list string) (map[string]Scraper, error) {
+	picked := make(map[string]Scraper)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		scraper, ok := scrapers[name]
+		if !ok {
+			var valid []string
+			for name := range scrapers {
+				valid = append(valid, name)
+			}
+			sort.Strings(valid)
+			return nil, fmt.Errorf("unknown source '%s' (expected one of: %s)", name, strings.Join(valid, ", "))
+		}
+		picked[name] = scraper
+	}
+	if len(picked) == 0 {
+		return nil, errors.New("no sources given to -sources")
+	}
+	return picked, nil
+}
+
+// run does all the work for main, returning any fatal error
+func run() error {
+	var err error
+	logThreshold, err = parseLogLevel(*logLevelFlag)
+	if err != nil {
+		return err
+	}
+	httpClient.Timeout = time.Duration(*fetchTimeout) * time.Second
+	pages = newPageCache(*pageCacheSize, pageCacheTTL)
+	fetchSlots = newFetchBudget(*globalConcurrency)
+	if *proxyFlag != "" || *insecureTLS {
+		httpClient.Transport, err = sourceTransport(*proxyFlag, *insecureTLS)
+		if err != nil {
+			return err
+		}
+	}
+	if *insecureTLS {
+		warnf("-insecure-tls is set: not checking the certificates of source sites")
+	}
+	limiter.delay = time.Duration(*requestDelay) * time.Millisecond
+	userAgent = *userAgentFlag
+	maxRetries = *retriesFlag
+	if *webhookURL != "" {
+		hook = newWebhook(*webhookURL, time.Duration(*webhookTimeout)*time.Second)
+	}
+
+	scrapers, err := loadScrapers()
+	if err != nil {
+		return err
+	}
 
 	if *listFlag {
 		for name, _ := range scrapers {
 			fmt.Println(name)
 		}
-		return
+		return nil
+	}
+
+	if *guessFlag != "" {
+		// help out with writing a config for a new site
+		return guessRun(*guessFlag)
 	}
 
 	if *testScraper != "" {
 		// run a single scraper, without server or store
 		scraper, ok := scrapers[*testScraper]
 		if !ok {
-			log.Fatal("Unknown scraper")
-		}
-		pressReleases, err := scraper.FetchList()
-		if err != nil {
-			panic(err)
-		}
-		for _, pr := range pressReleases {
-			if !pr.complete {
-				log.Printf("%s: scrape %s", scraper.Name(), pr.Permalink)
-				err = scrape(scraper, pr)
-				if err != nil {
-					log.Printf("ERROR '%s' %s\n", err, pr.Permalink)
-					continue
-				}
-				pr.complete = true
-			}
-
-			if !*briefFlag {
-				fmt.Printf("%s\n %s\n %s\n", pr.Title, pr.PubDate, pr.Permalink)
-				fmt.Println("")
-				fmt.Println(pr.Content)
-				fmt.Println("------------------------------")
-			} else {
-				fmt.Printf("%s %s\n", pr.Title, pr.Permalink)
-			}
+			return fmt.Errorf("Unknown scraper '%s'", *testScraper)
 		}
-		return
+		return testRun(scraper, *dryRunFlag, *briefFlag)
 	}
 
 	// set up as server
 	// using a common store for all scrapers
 	// but no reason they couldn't all have their own store
-	store := NewStore("./prstore.db")
+	var store Store
+	switch *storeFlag {
+	case "sqlite":
+		sqliteStore, err := NewSQLiteStore(*dbFlag)
+		if err != nil {
+			return err
+		}
+		store = sqliteStore
+	case "mem":
+		store = NewMemStore()
+	default:
+		return fmt.Errorf("unknown store '%s' (expected sqlite or mem)", *storeFlag)
+	}
+	defer store.Close()
+	if *rescrapeFlag != "" {
+		scraper, ok := scrapers[*rescrapeFlag]
+		if !ok {
+			return fmt.Errorf("Unknown scraper '%s'", *rescrapeFlag)
+		}
+		n, err := rescrape(scraper, store)
+		if err != nil {
+			return err
+		}
+		infof("%s: rescraped, %d releases updated", scraper.Name(), n)
+		return nil
+	}
+	if *backfillFlag != "" {
+		scraper, ok := scrapers[*backfillFlag]
+		if !ok {
+			return fmt.Errorf("Unknown scraper '%s'", *backfillFlag)
+		}
+		n, err := backfill(scraper, store, *backfillPages)
+		if err != nil {
+			return err
+		}
+		infof("%s: backfilled, %d releases stashed", scraper.Name(), n)
+		return nil
+	}
+	if *authUser == "" && *authPass != "" {
+		return errors.New("-auth-pass given without -auth-user")
+	}
+	cors := parseCORSOrigins(*corsFlag)
+	auth := basicAuth{user: *authUser, pass: *authPass, cors: cors}
 	sseSrv := eventsource.NewServer()
-	for name, _ := range scrapers {
-		sseSrv.Register(name, store)
-		http.Handle("/"+name+"/", sseSrv.Handler(name))
+	if err := checkScraperNames(scrapers); err != nil {
+		return err
+	}
+	live := newLiveScrapers(scrapers)
+	// the per-source streams and feeds (never unregistered, but they're
+	// switched off if the source is dropped on a reload)
+	registered := make(map[string]bool)
+	addRoutes := func(scrapers map[string]Scraper) {
+		for name := range scrapers {
+			if registered[name] {
+				continue
+			}
+			registered[name] = true
+			sseSrv.Register(name, storeRepository{store: store})
+			http.Handle("/"+name+"/", cors.wrap(live.gate(name, streamHandler(sseSrv, store, name))))
+			sseSrv.Register(updatedChannel(name), changeRepository{})
+			http.Handle("/"+updatedChannel(name), cors.wrap(live.gate(name, updatesHandler(sseSrv, name))))
+			http.Handle("/"+name+"/rss", cors.wrap(live.gate(name, rssHandler(store, name))))
+		}
 	}
+	addRoutes(scrapers)
+	// combined stream, with releases from every source
+	sseSrv.Register(allChannel, storeRepository{store: store})
+	http.Handle("/"+allChannel+"/", cors.wrap(streamHandler(sseSrv, store, allChannel)))
+	sseSrv.Register(updatedChannel(allChannel), changeRepository{})
+	http.Handle("/"+updatedChannel(allChannel), cors.wrap(updatesHandler(sseSrv, allChannel)))
+	http.Handle("/"+allChannel+"/rss", cors.wrap(rssHandler(store, allChannel)))
+	http.Handle("/opml", cors.wrap(opmlHandler(live)))
+
+	// json api for browsing the archive
+	http.Handle("/api/releases", cors.wrap(releasesHandler(store)))
+	http.Handle("/api/releases/", cors.wrap(releaseHandler(store)))
+	http.Handle("/api/releases/count", cors.wrap(countHandler(store)))
+	http.Handle("/api/releases/latest", cors.wrap(latestHandler(store, live)))
+	http.Handle("/api/search", cors.wrap(searchHandler(store)))
+	http.Handle("/api/sources", cors.wrap(sourcesHandler(store, live)))
+	http.Handle("/api/errors", cors.wrap(http.HandlerFunc(errorsHandler)))
+
+	// html interface for eyeballing the archive
+	http.Handle("/browse/", browseHandler(store, live))
+
+	// for monitoring
+	http.HandleFunc("/metrics", metricsHandler)
+	http.HandleFunc("/healthz", healthzHandler)
+	http.Handle("/status", statusHandler(store, live))
 
 	//
 	l, err := net.Listen("tcp", fmt.Sprintf(":%d", *port))
 	if err != nil {
-		panic(err) //glog.Fatal(err)
+		return err
 	}
 	defer l.Close()
 
 	// cheesy task to periodically run the scrapers
+	ctx, cancel := context.WithCancel(context.Background())
+	scrapingDone := make(chan struct{})
+	reloads := make(chan map[string]Scraper)
 	go func() {
-		for {
-			for _, scraper := range scrapers {
-				doit(scraper, store, sseSrv)
+		scrapeLoop(ctx, scrapers, store, sseSrv, reloads)
+		close(scrapingDone)
+	}()
+
+	srv := &http.Server{Handler: withAccessLog(auth.wrap(http.DefaultServeMux), *accessLogFlag)}
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- srv.Serve(l)
+	}()
+	infof("running on port %d", *port)
+
+	// wait for ctrl-c (or a kill), then shut down cleanly. A SIGHUP
+	// reloads the -config file.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	hups := make(chan os.Signal, 1)
+	signal.Notify(hups, syscall.SIGHUP)
+waiting:
+	for {
+		select {
+		case sig := <-sigs:
+			infof("got %s, shutting down", sig)
+			break waiting
+		case <-hups:
+			scrapers, err := loadScrapers()
+			if err == nil {
+				err = checkScraperNames(scrapers)
+			}
+			if err != nil {
+				errorf("reloading: %s (carrying on with the old config)", err)
+				continue
 			}
-			time.Sleep(time.Duration(*interval) * time.Second)
+			addRoutes(scrapers)
+			live.set(scrapers)
+			reloads <- scrapers
+			infof("reloaded, %d sources", len(scrapers))
+		case err := <-serveErr:
+			cancel()
+			<-scrapingDone
+			return err
 		}
+	}
+
+	// let any scraping already underway finish up
+	cancel()
+	<-scrapingDone
+	if hook != nil {
+		hook.wait()
+	}
+
+	// sse connections never go idle, so close them off first
+	sseSrv.Close()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		errorf("shutting down server: %s", err)
+	}
+	return nil
+}
+
+// scrapeLoop runs each of the scrapers periodically (each on their own
+// schedule, see scrapeInterval), and prunes the store, until ctx is
+// cancelled.
+// The first runs are staggered (see startOffset), rather than all firing
+// off at once.
+// A new set of scrapers can be sent in on reloads (see loadScrapers). New
+// ones are started straight away, dropped ones are stopped, and changed
+// ones are restarted (once any run of the old one underway has finished).
+// Cancelling doesn't interrupt a scraper which is already running - it's
+// left to finish, but no more are started. scrapeLoop returns once they've
+// all stopped.
+func scrapeLoop(ctx context.Context, scrapers map[string]Scraper, store Store, sseSrv *eventsource.Server, reloads <-chan map[string]Scraper) {
+	var wg sync.WaitGroup
+	running := make(map[string]*scrapeRunner)
+	dropped := make(map[string]*scrapeRunner) // (in case they come back)
+	// start runs a scraper, once any previous runner for the source has
+	// stopped (so they never overlap)
+	start := func(name string, scraper Scraper, offset time.Duration, prev *scrapeRunner) {
+		runCtx, cancel := context.WithCancel(ctx)
+		r := &scrapeRunner{scraper: scraper, cancel: cancel, done: make(chan struct{})}
+		running[name] = r
+		debugf("%s: first run in %s", name, offset)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(r.done)
+			if prev != nil {
+				<-prev.done
+			}
+			select {
+			case <-runCtx.Done():
+				return
+			case <-time.After(offset):
+			}
+			every(runCtx, scrapeInterval(scraper), func() {
+				doit(scraper, store, sseSrv)
+			})
+		}()
+	}
+
+	var names []string
+	for name := range scrapers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for i, name := range names {
+		scraper := scrapers[name]
+		start(name, scraper, startOffset(i, len(names), scrapeInterval(scraper)), nil)
+	}
+
+	// housekeeping
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		every(ctx, time.Duration(*interval)*time.Second, func() {
+			resetRobots()
+			if *retention > 0 {
+				n, err := store.Prune(time.Duration(*retention) * 24 * time.Hour)
+				if err != nil {
+					errorf("pruning store: %s", err)
+				} else if n > 0 {
+					infof("pruned %d old releases", n)
+					if *vacuumFlag {
+						if err := store.Vacuum(); err != nil {
+							errorf("vacuuming store: %s", err)
+						}
+					}
+				}
+			}
+		})
 	}()
 
-	log.Printf("running on port %d", *port)
-	http.Serve(l, nil)
+	for {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case scrapers := <-reloads:
+			for name, r := range running {
+				scraper, ok := scrapers[name]
+				switch {
+				case !ok:
+					infof("%s: dropped", name)
+					r.cancel()
+					delete(running, name)
+					dropped[name] = r
+				case !sameScraper(r.scraper, scraper):
+					infof("%s: changed, restarting", name)
+					r.cancel()
+					start(name, scraper, 0, r)
+				}
+			}
+			for name, scraper := range scrapers {
+				if running[name] == nil {
+					infof("%s: added", name)
+					start(name, scraper, 0, dropped[name])
+					delete(dropped, name)
+				}
+			}
+		}
+	}
+}
+
+// every calls fn straight away, then again every d, until ctx is cancelled
+func every(ctx context.Context, d time.Duration, fn func()) {
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+		fn()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d):
+		}
+	}
+}
+
+// startOffset returns how long to wait before the first run of the i'th of
+// n scrapers, to spread them out evenly over their poll interval d (plus a
+// little jitter, up to a tenth of the gap between them). The first one
+// starts straight away.
+func startOffset(i, n int, d time.Duration) time.Duration {
+	if i == 0 || n < 1 || d <= 0 {
+		return 0
+	}
+	gap := d / time.Duration(n)
+	offset := gap * time.Duration(i)
+	if jitter := int64(gap / 10); jitter > 0 {
+		offset += time.Duration(rand.Int63n(jitter))
+	}
+	return offset
+}
+
+// scrapeConcurrency returns how many press releases to fetch from a source
+// at once - the global -concurrency, unless it's overridden by the scraper.
+func scrapeConcurrency(scraper Scraper) int {
+	if s, ok := scraper.(PoliteScraper); ok {
+		if n := s.Politeness().Concurrency; n > 0 {
+			return n
+		}
+	}
+	return *concurrency
+}
+
+// setRequestDelay applies a scraper's own request delay (if it has one) to
+// the host of pageURL. The delay is per host, so if sources share a host
+// the last one to set it wins.
+func setRequestDelay(scraper Scraper, pageURL string) {
+	s, ok := scraper.(PoliteScraper)
+	if !ok {
+		return
+	}
+	d := s.Politeness().Delay
+	if d <= 0 {
+		return
+	}
+	if u, err := url.Parse(pageURL); err == nil && u.Host != "" {
+		limiter.setDelay(u.Host, d)
+	}
+}
+
+// scrapeInterval returns how often a scraper should be run - the global
+// -interval, unless it's overridden by the scraper.
+func scrapeInterval(scraper Scraper) time.Duration {
+	if s, ok := scraper.(IntervalScraper); ok {
+		if d := s.Interval(); d > 0 {
+			return d
+		}
+	}
+	return time.Duration(*interval) * time.Second
 }
@@ -0,0 +1,1096 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/donovanhide/eventsource"
+)
+
+func init() {
+	// no need to be polite to httptest servers
+	limiter.delay = 0
+	retryBackoff = time.Millisecond
+}
+
+// setFlag sets a flag's value for the rest of a test
+func setFlag(t *testing.T, flag *int, value int) {
+	old := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = old })
+}
+
+// fakeScraper is a Scraper which lists nothing, and takes the whole page
+// as the content
+type fakeScraper struct{ name string }
+
+func (f *fakeScraper) Name() string                        { return f.name }
+func (f *fakeScraper) FetchList() ([]*PressRelease, error) { return nil, nil }
+func (f *fakeScraper) Scrape(pr *PressRelease, rawHTML string) error {
+	pr.Content = rawHTML
+	return nil
+}
+
+// listScraper is a fakeScraper which lists the given press releases
+type listScraper struct {
+	fakeScraper
+	list []*PressRelease
+}
+
+func (l *listScraper) FetchList() ([]*PressRelease, error) { return l.list, nil }
+
+// pressPage is a press release page for the test servers
+func pressPage(title string) string {
+	return fmt.Sprintf(`<html><body><h1>%s</h1><div class="body"><p>%s</p></div></body></html>`,
+		title, strings.Repeat(title+" text. ", 40))
+}
+
+// A release held back by -max-per-cycle has to be listed again next time,
+// even if the index page hasn't changed since.
+func TestUncleanRunRelistsIndex(t *testing.T) {
+	setFlag(t, maxPerCycle, 1)
+	notModified := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/news":
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				notModified++
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			fmt.Fprint(w, `<ul><li><a class="pr" href="/one">One</a></li><li><a class="pr" href="/two">Two</a></li></ul>`)
+		default:
+			fmt.Fprint(w, pressPage(r.URL.Path[1:]))
+		}
+	}))
+	defer srv.Close()
+
+	scraper := &ConfigScraper{ScraperName: "relist", URL: srv.URL + "/news", Links: "a.pr", Title: selectorList{"h1"}, Content: selectorList{".body"}}
+	store := NewMemStore()
+	stored := func() int {
+		counts, err := store.SourceCounts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return counts["relist"]
+	}
+
+	doit(scraper, store, eventsource.NewServer())
+	if got := stored(); got != 1 {
+		t.Fatalf("after the first run, got %d stored, want 1", got)
+	}
+	doit(scraper, store, eventsource.NewServer())
+	if got := stored(); got != 2 {
+		t.Fatalf("after the second run, got %d stored, want 2 (index sent back %d 304s)", got, notModified)
+	}
+	if notModified != 0 {
+		t.Errorf("conditional GET after an unclean run (%d 304s)", notModified)
+	}
+	// nothing left over now, so it's back to conditional GETs
+	doit(scraper, store, eventsource.NewServer())
+	if notModified != 1 {
+		t.Errorf("got %d 304s after a clean run, want 1", notModified)
+	}
+	if got := stored(); got != 2 {
+		t.Errorf("got %d stored, want 2", got)
+	}
+}
+
+// panicScraper panics on pages with "bad" in
+type panicScraper struct{ listScraper }
+
+func (p *panicScraper) Scrape(pr *PressRelease, rawHTML string) error {
+	if strings.Contains(rawHTML, "bad") {
+		var oops *PressRelease
+		_ = oops.Title
+	}
+	pr.Title, pr.Content = "Title", rawHTML
+	return nil
+}
+
+type panicLister struct{ fakeScraper }
+
+func (p *panicLister) FetchList() ([]*PressRelease, error) { panic("boom") }
+
+func TestScrapePanic(t *testing.T) {
+	setFlag(t, minContent, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "page ", r.URL.Path)
+	}))
+	defer srv.Close()
+	store := NewMemStore()
+	scraper := &panicScraper{listScraper{fakeScraper{"panicky"}, []*PressRelease{
+		{Source: "panicky", Permalink: srv.URL + "/good"},
+		{Source: "panicky", Permalink: srv.URL + "/bad"},
+		{Source: "panicky", Permalink: srv.URL + "/good2"},
+	}}}
+	before := scrapeErrors.get("panicky")
+	doit(scraper, store, eventsource.NewServer())
+	if counts, _ := store.SourceCounts(); counts["panicky"] != 2 {
+		t.Errorf("got %d stored, want the 2 good ones", counts["panicky"])
+	}
+	if got := scrapeErrors.get("panicky") - before; got != 1 {
+		t.Errorf("got %v errors counted, want 1", got)
+	}
+	problems := scrapeProblems.recent("panicky")
+	if len(problems) == 0 || !strings.Contains(fmt.Sprint(problems), "panicked") {
+		t.Errorf("panic not in the problems: %v", problems)
+	}
+
+	before = scrapeErrors.get("panicky-list")
+	doit(&panicLister{fakeScraper{"panicky-list"}}, store, eventsource.NewServer())
+	if got := scrapeErrors.get("panicky-list") - before; got != 1 {
+		t.Errorf("got %v list errors counted, want 1", got)
+	}
+}
+
+// panicTransport panics instead of fetching anything
+type panicTransport struct{}
+
+func (panicTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	panic("transport trouble")
+}
+
+// A panic fetching the page (outside the scraper) is an error too.
+func TestFetchPanic(t *testing.T) {
+	old := httpClient.Transport
+	httpClient.Transport = panicTransport{}
+	defer func() { httpClient.Transport = old }()
+	// (robots.txt is looked at first, and would panic too)
+	robotsCache.Lock()
+	robotsCache.hosts["http://panicky.example.com"] = robotsRules{}
+	robotsCache.Unlock()
+
+	pr := &PressRelease{Source: "panicky", Permalink: "http://panicky.example.com/1"}
+	err := scrape(context.Background(), &fakeScraper{"panicky"}, pr)
+	if !errors.Is(err, ErrParse) || !strings.Contains(fmt.Sprint(err), "panicked") {
+		t.Errorf("got %v, want a panic error", err)
+	}
+}
+
+func TestRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/old", http.RedirectHandler("/older", http.StatusMovedPermanently))
+	mux.Handle("/older", http.RedirectHandler("/new", http.StatusFound))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, pressPage("Moved"))
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	pr := &PressRelease{Source: "redirecty", Permalink: srv.URL + "/old"}
+	if err := scrape(context.Background(), &fakeScraper{"redirecty"}, pr); err != nil {
+		t.Fatal(err)
+	}
+	if want := srv.URL + "/new"; pr.FinalURL != want {
+		t.Errorf("got final url %q, want %q", pr.FinalURL, want)
+	}
+	// (once stashed, the release counts as seen under either url)
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		if _, err := store.Stash(pr); err != nil {
+			t.Fatal(err)
+		}
+		for _, link := range []string{srv.URL + "/old", srv.URL + "/new"} {
+			unseen, err := store.WhichAreNew([]*PressRelease{{Source: "redirecty", Permalink: link}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(unseen) != 0 {
+				t.Errorf("%T: %s is new", store, link)
+			}
+		}
+	}
+
+	pr = &PressRelease{Source: "redirecty", Permalink: srv.URL + "/loop"}
+	if err := scrape(context.Background(), &fakeScraper{"redirecty"}, pr); !errors.Is(err, ErrFetch) {
+		t.Errorf("redirect loop: got %v, want a fetch error", err)
+	}
+}
+
+func TestScrapeAll(t *testing.T) {
+	const workers = 4
+	var mu sync.Mutex
+	inFlight, most := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	var prs []*PressRelease
+	for i := 0; i < 20; i++ {
+		prs = append(prs, &PressRelease{Permalink: fmt.Sprintf("%s/%d", srv.URL, i)})
+	}
+	// (nothing listening)
+	prs = append(prs, &PressRelease{Permalink: "http://127.0.0.1:1/broken"})
+	ok := scrapeAll(context.Background(), &fakeScraper{"pool"}, prs, workers)
+	for i, pr := range prs[:20] {
+		if !ok[i] {
+			t.Errorf("%s not ok", pr.Permalink)
+		}
+		// (each one ends up with its own page)
+		if want := fmt.Sprintf("/%d", i); pr.Content != want {
+			t.Errorf("got content %q for %s, want %q", pr.Content, pr.Permalink, want)
+		}
+	}
+	if ok[20] {
+		t.Errorf("the broken one is ok")
+	}
+	if most > workers {
+		t.Errorf("got %d fetches at once, want at most %d", most, workers)
+	}
+	if most < 2 {
+		t.Errorf("fetches weren't done in parallel")
+	}
+}
+
+// blockingScraper's FetchList holds on until it's let go
+type blockingScraper struct {
+	fakeScraper
+	started chan struct{}
+	release chan struct{}
+	runs    int
+}
+
+func (b *blockingScraper) FetchList() ([]*PressRelease, error) {
+	b.runs++
+	close(b.started)
+	<-b.release
+	return nil, nil
+}
+
+// On shutdown, a run already underway is finished off, but no more are
+// started.
+func TestScrapeLoopShutdown(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	scraper := &blockingScraper{fakeScraper: fakeScraper{"blocking"}, started: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan struct{})
+	go func() {
+		scrapeLoop(ctx, map[string]Scraper{"blocking": scraper}, NewMemStore(), eventsource.NewServer(), nil)
+		close(done)
+	}()
+
+	<-scraper.started
+	cancel()
+	select {
+	case <-done:
+		t.Fatal("scrapeLoop returned with a run still going")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(scraper.release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("scrapeLoop didn't stop")
+	}
+	if scraper.runs != 1 {
+		t.Errorf("got %d runs, want 1", scraper.runs)
+	}
+}
+
+// errorScraper fails to fetch its list
+type errorScraper struct{ fakeScraper }
+
+func (e *errorScraper) FetchList() ([]*PressRelease, error) {
+	return nil, errors.New("index page borked")
+}
+
+// brokenStore can't check what's new
+type brokenStore struct{ *MemStore }
+
+func (b brokenStore) WhichAreNew(incoming []*PressRelease) ([]*PressRelease, error) {
+	return nil, errors.New("disk on fire")
+}
+
+// Errors are logged and counted, rather than taking the server down.
+func TestDoitErrors(t *testing.T) {
+	store := NewMemStore()
+	before := scrapeErrors.get("broken-list")
+	doit(&errorScraper{fakeScraper{"broken-list"}}, store, eventsource.NewServer())
+	if got := scrapeErrors.get("broken-list") - before; got != 1 {
+		t.Errorf("got %v errors counted, want 1", got)
+	}
+	if problems := fmt.Sprint(scrapeProblems.recent("broken-list")); !strings.Contains(problems, "index page borked") {
+		t.Errorf("error not in the problems: %s", problems)
+	}
+
+	lister := &listScraper{fakeScraper{"broken-store"}, []*PressRelease{{Source: "broken-store", Permalink: "http://example.com/1"}}}
+	doit(lister, brokenStore{store}, eventsource.NewServer())
+	if counts, _ := store.SourceCounts(); counts["broken-store"] != 0 {
+		t.Errorf("got %d stored, want none", counts["broken-store"])
+	}
+	scrapeStatus.Lock()
+	lastError := scrapeStatus.lastError["broken-store"]
+	scrapeStatus.Unlock()
+	if lastError != "disk on fire" {
+		t.Errorf("got last error %q, want the store's", lastError)
+	}
+}
+
+// A press release spread over several pages is scraped as one.
+func TestMultiPageScrape(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "["+r.URL.Path+"]")
+	}))
+	defer srv.Close()
+	urls := []string{srv.URL + "/news/1", srv.URL + "/news/1/page2"}
+	pr := &PressRelease{Source: "multi", Permalink: urls[0], URLs: urls}
+	if err := scrape(context.Background(), &fakeScraper{"multi"}, pr); err != nil {
+		t.Fatal(err)
+	}
+	if want := "[/news/1][/news/1/page2]"; pr.Content != want {
+		t.Errorf("got content %q, want %q", pr.Content, want)
+	}
+
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		ev, err := store.Stash(pr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Get("multi", fmt.Sprint(ev.id))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprint(got.URLs) != fmt.Sprint(urls) {
+			t.Errorf("%T: got urls %v, want %v", store, got.URLs, urls)
+		}
+	}
+}
+
+// tickScraper counts its runs (noting when the first was), and has its own
+// poll interval
+type tickScraper struct {
+	fakeScraper
+	interval time.Duration
+	sync.Mutex
+	runs  int
+	first time.Time
+}
+
+func (s *tickScraper) FetchList() ([]*PressRelease, error) {
+	s.Lock()
+	defer s.Unlock()
+	if s.runs == 0 {
+		s.first = time.Now()
+	}
+	s.runs++
+	return nil, nil
+}
+
+func (s *tickScraper) Interval() time.Duration { return s.interval }
+
+// runCount returns how many times a tickScraper has been run so far
+func (s *tickScraper) runCount() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.runs
+}
+
+func TestScrapeIntervals(t *testing.T) {
+	fast := &tickScraper{fakeScraper: fakeScraper{"fast"}, interval: 20 * time.Millisecond}
+	slow := &tickScraper{fakeScraper: fakeScraper{"slow"}, interval: time.Hour}
+	if got := scrapeInterval(fast); got != fast.interval {
+		t.Errorf("got interval %s, want %s", got, fast.interval)
+	}
+	if got, want := scrapeInterval(&fakeScraper{"default"}), time.Duration(*interval)*time.Second; got != want {
+		t.Errorf("got interval %s, want the default %s", got, want)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	scrapeLoop(ctx, map[string]Scraper{"fast": fast, "slow": slow}, NewMemStore(), eventsource.NewServer(), nil)
+	// ("slow" is second, so its first run is staggered half an hour in)
+	if fast.runs < 10 {
+		t.Errorf("fast scraper ran %d times, want at least 10", fast.runs)
+	}
+	if slow.runs != 0 {
+		t.Errorf("slow scraper ran %d times, want none yet", slow.runs)
+	}
+}
+
+// captureStdout returns whatever fn prints
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- string(b)
+	}()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	fn()
+	w.Close()
+	return <-out
+}
+
+func TestDryRun(t *testing.T) {
+	var mu sync.Mutex
+	pages := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/news/":
+			fmt.Fprint(w, `<html><body><a class="news" href="/news/1">1</a><a class="news" href="/news/2">2</a></body></html>`)
+		case "/robots.txt":
+			http.NotFound(w, r)
+		default:
+			mu.Lock()
+			pages++
+			mu.Unlock()
+			fmt.Fprint(w, pressPage("Release "+r.URL.Path))
+		}
+	}))
+	defer srv.Close()
+	scraper := &ConfigScraper{ScraperName: "dry", URL: srv.URL + "/news/", Links: "a.news", Title: []string{"h1"}, Content: []string{".body"}}
+
+	var err error
+	out := captureStdout(t, func() { err = testRun(scraper, true, true) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := srv.URL + "/news/1\n" + srv.URL + "/news/2\n"; out != want {
+		t.Errorf("dry run: got %q, want %q", out, want)
+	}
+	if pages != 0 {
+		t.Errorf("dry run: fetched %d pages, want none", pages)
+	}
+
+	out = captureStdout(t, func() { err = testRun(scraper, false, true) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Release /news/1 " + srv.URL + "/news/1\nRelease /news/2 " + srv.URL + "/news/2\n"; out != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	if pages != 2 {
+		t.Errorf("fetched %d pages, want 2", pages)
+	}
+}
+
+// Releases with less than -min-content chars of text aren't stashed, but
+// are logged as problems.
+func TestMinContent(t *testing.T) {
+	setFlag(t, minContent, 11)
+	scrapeProblems = newProblemLog()
+	defer func() { scrapeProblems = newProblemLog() }()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// (counted in chars, not bytes)
+		var n int
+		fmt.Sscanf(r.URL.Path, "/%d", &n)
+		fmt.Fprint(w, strings.Repeat("é", n))
+	}))
+	defer srv.Close()
+	var list []*PressRelease
+	for _, n := range []int{10, 11, 12} {
+		list = append(list, &PressRelease{Source: "min-content", Permalink: fmt.Sprintf("%s/%d", srv.URL, n)})
+	}
+	store := NewMemStore()
+	doit(&listScraper{fakeScraper{"min-content"}, list}, store, eventsource.NewServer())
+
+	stashed, err := store.Query(QueryOptions{Source: "min-content"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, pr := range stashed {
+		got = append(got, strings.TrimPrefix(pr.Permalink, srv.URL))
+	}
+	sort.Strings(got)
+	if want := "[/11 /12]"; fmt.Sprint(got) != want {
+		t.Errorf("got %v stashed, want %s", got, want)
+	}
+	problems := scrapeProblems.recent("min-content")
+	if len(problems) != 1 || problems[0].URL != srv.URL+"/10" || problems[0].Reason != "too short (10 chars of text)" {
+		t.Errorf("got problems %+v", problems)
+	}
+}
+
+func TestStartOffset(t *testing.T) {
+	for _, test := range []struct {
+		i, n     int
+		d        time.Duration
+		min, max time.Duration
+	}{
+		{0, 4, time.Minute, 0, 0},
+		{1, 4, time.Minute, 15 * time.Second, 16500 * time.Millisecond},
+		{3, 4, time.Minute, 45 * time.Second, 46500 * time.Millisecond},
+		{1, 1, time.Minute, time.Minute, 66 * time.Second},
+		{1, 0, time.Minute, 0, 0},
+		{1, 4, 0, 0, 0},
+	} {
+		if got := startOffset(test.i, test.n, test.d); got < test.min || got > test.max {
+			t.Errorf("startOffset(%d, %d, %s) = %s, want %s to %s", test.i, test.n, test.d, got, test.min, test.max)
+		}
+	}
+}
+
+// With their own tickers, the scrapers' first runs are spread out over the
+// poll interval.
+func TestStaggeredStart(t *testing.T) {
+	scrapers := make(map[string]Scraper)
+	var ticks []*tickScraper
+	for _, name := range []string{"stagger-a", "stagger-b", "stagger-c", "stagger-d"} {
+		s := &tickScraper{fakeScraper: fakeScraper{name}, interval: 400 * time.Millisecond}
+		scrapers[name] = s
+		ticks = append(ticks, s)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 390*time.Millisecond)
+	defer cancel()
+	scrapeLoop(ctx, scrapers, NewMemStore(), eventsource.NewServer(), nil)
+
+	var firsts []time.Time
+	for _, s := range ticks {
+		if s.runs != 1 {
+			t.Fatalf("%s ran %d times, want once", s.name, s.runs)
+		}
+		firsts = append(firsts, s.first)
+	}
+	sort.Slice(firsts, func(i, j int) bool { return firsts[i].Before(firsts[j]) })
+	// (100ms apart, give or take the jitter)
+	for i := 1; i < len(firsts); i++ {
+		if gap := firsts[i].Sub(firsts[i-1]); gap < 80*time.Millisecond {
+			t.Errorf("first runs %d and %d only %s apart", i-1, i, gap)
+		}
+	}
+}
+
+func TestFilterScrapers(t *testing.T) {
+	all := map[string]Scraper{"tesco": &fakeScraper{"tesco"}, "asda": &fakeScraper{"asda"}, "waitrose": &fakeScraper{"waitrose"}}
+	for _, test := range []struct {
+		list, want, err string
+	}{
+		{"tesco,asda", "[asda tesco]", ""},
+		{" waitrose, ", "[waitrose]", ""},
+		{"tesco,lidl", "", "unknown source 'lidl' (expected one of: asda, tesco, waitrose)"},
+		{",", "", "no sources given to -sources"},
+	} {
+		picked, err := filterScrapers(all, test.list)
+		if test.err != "" {
+			if err == nil || err.Error() != test.err {
+				t.Errorf("%q: got error %v, want %q", test.list, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", test.list, err)
+			continue
+		}
+		var got []string
+		for name, scraper := range picked {
+			if scraper != all[name] {
+				t.Errorf("%q: got the wrong scraper for %s", test.list, name)
+			}
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if fmt.Sprint(got) != test.want {
+			t.Errorf("%q: got %v, want %s", test.list, got, test.want)
+		}
+	}
+}
+
+// datedScraper is a listScraper which dates everything it scrapes now
+type datedScraper struct{ listScraper }
+
+func (d *datedScraper) Scrape(pr *PressRelease, rawHTML string) error {
+	pr.Content = rawHTML
+	pr.PubDate = time.Now()
+	return nil
+}
+
+// A fresh release which has changed since it was stashed is updated in
+// place (keeping its id and publication date), with the old version kept
+// as a revision.
+func TestRecheck(t *testing.T) {
+	setFlag(t, minContent, 0)
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		var version int
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "version ", version)
+		}))
+		permalink := srv.URL + "/1"
+		scraper := &datedScraper{listScraper{fakeScraper{"recheck"}, nil}}
+		before := releasesUpdated.get("recheck")
+
+		var first *pressReleaseEvent
+		// (the last cycle brings nothing new)
+		for _, version = range []int{1, 2, 3, 3} {
+			scraper.list = []*PressRelease{{Source: "recheck", Permalink: permalink}}
+			doit(scraper, store, eventsource.NewServer())
+			if first == nil {
+				var err error
+				if first, err = store.Lookup("recheck", permalink); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		srv.Close()
+
+		ev, err := store.Lookup("recheck", permalink)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.id != first.id || ev.payload.Content != "version 3" || !ev.payload.PubDate.Equal(first.payload.PubDate) || ev.payload.LastModified.IsZero() {
+			t.Errorf("%T: got %d %+v", store, ev.id, ev.payload)
+		}
+		if got := releasesUpdated.get("recheck") - before; got != 2 {
+			t.Errorf("%T: counted %v updates, want 2", store, got)
+		}
+		if _, err := store.Update(9999, ev.payload); err != errNotFound {
+			t.Errorf("%T: updating a missing release: got %v, want %v", store, err, errNotFound)
+		}
+		if sqlite, ok := store.(*SQLiteStore); ok {
+			var revisions int
+			if err := sqlite.db.QueryRow("SELECT COUNT(*) FROM press_release_revision").Scan(&revisions); err != nil {
+				t.Fatal(err)
+			}
+			if revisions != 2 {
+				t.Errorf("got %d revisions kept, want 2", revisions)
+			}
+		}
+	}
+}
+
+// canonScraper is a listScraper which scrapes the page properly (picking
+// out its canonical url)
+type canonScraper struct{ listScraper }
+
+func (c *canonScraper) Scrape(pr *PressRelease, rawHTML string) error {
+	return GenericScrape(c.name, pr, rawHTML, []string{"h1"}, []string{".body"}, "", nil)
+}
+
+// Variants of a url (with tracking params etc) are spotted as the same
+// release by their canonical url, even if the content differs a bit.
+func TestCanonicalDedup(t *testing.T) {
+	setFlag(t, minContent, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<html><head><link rel="canonical" href="/news/1"></head><body><h1>Title</h1><div class="body"><p>Body (%s)</p></div></body></html>`, r.URL.RawQuery)
+	}))
+	defer srv.Close()
+	canonical := srv.URL + "/news/1"
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		scraper := &canonScraper{listScraper{fakeScraper{"canonical"}, nil}}
+		for _, query := range []string{"?utm_source=a", "?utm_source=b"} {
+			scraper.list = []*PressRelease{{Source: "canonical", Permalink: canonical + query}}
+			doit(scraper, store, eventsource.NewServer())
+		}
+		counts, err := store.SourceCounts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts["canonical"] != 1 {
+			t.Errorf("%T: got %d stashed, want 1", store, counts["canonical"])
+		}
+		// (and the canonical url itself is known up front)
+		if n := countNew(t, store, &PressRelease{Source: "canonical", Permalink: canonical}); n != 0 {
+			t.Errorf("%T: canonical url is new", store)
+		}
+		ev, err := store.Lookup("canonical", canonical)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.payload.CanonicalURL != canonical || ev.payload.Permalink != canonical+"?utm_source=a" {
+			t.Errorf("%T: got %+v", store, ev.payload)
+		}
+	}
+}
+
+// With more new releases than -max-per-cycle, the oldest are done first,
+// and the rest are left for the next cycles.
+func TestMaxPerCycle(t *testing.T) {
+	setFlag(t, minContent, 0)
+	setFlag(t, maxPerCycle, 50)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "page ", r.URL.Path)
+	}))
+	defer srv.Close()
+	var list []*PressRelease
+	for i := 0; i < 150; i++ {
+		list = append(list, &PressRelease{Source: "max-per-cycle", Permalink: fmt.Sprintf("%s/%d", srv.URL, i)})
+	}
+	store := NewMemStore()
+	scraper := &listScraper{fakeScraper{"max-per-cycle"}, list}
+	for cycle, want := range []int{50, 100, 150, 150} {
+		doit(scraper, store, eventsource.NewServer())
+		counts, err := store.SourceCounts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts["max-per-cycle"] != want {
+			t.Errorf("cycle %d: got %d stashed, want %d", cycle, counts["max-per-cycle"], want)
+		}
+		if cycle == 0 {
+			// (undated, so the ones at the end of the list are the oldest)
+			for _, i := range []int{100, 149} {
+				if _, err := store.Lookup("max-per-cycle", fmt.Sprintf("%s/%d", srv.URL, i)); err != nil {
+					t.Errorf("release %d not done first: %s", i, err)
+				}
+			}
+		}
+	}
+}
+
+func TestOldestN(t *testing.T) {
+	now := time.Now()
+	dated := []*PressRelease{{Title: "0", PubDate: now}, {Title: "1", PubDate: now.Add(-time.Hour)}, {Title: "2", PubDate: now.Add(-time.Hour)}, {Title: "3", PubDate: now.Add(-2 * time.Hour)}}
+	undated := []*PressRelease{{Title: "0"}, {Title: "1"}, {Title: "2"}}
+	mixed := []*PressRelease{{Title: "0", PubDate: now.Add(-2 * time.Hour)}, {Title: "1"}, {Title: "2"}}
+	for _, test := range []struct {
+		prs  []*PressRelease
+		n    int
+		want string
+	}{
+		// (in list order, with ties going to the first ones)
+		{dated, 2, "[1 3]"},
+		{dated, 3, "[1 2 3]"},
+		{dated, 10, "[0 1 2 3]"},
+		{undated, 2, "[1 2]"},
+		{mixed, 1, "[2]"},
+	} {
+		var got []string
+		for _, pr := range oldestN(test.prs, test.n) {
+			got = append(got, pr.Title)
+		}
+		if fmt.Sprint(got) != test.want {
+			t.Errorf("oldest %d: got %v, want %s", test.n, got, test.want)
+		}
+	}
+}
+
+// politeScraper is a listScraper with its own politeness settings
+type politeScraper struct {
+	listScraper
+	politeness Politeness
+}
+
+func (p *politeScraper) Politeness() Politeness { return p.politeness }
+
+func TestPoliteness(t *testing.T) {
+	setFlag(t, minContent, 0)
+	var mu sync.Mutex
+	inFlight := make(map[string]int)
+	peak := make(map[string]int)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		source := r.URL.Query().Get("source")
+		mu.Lock()
+		inFlight[source]++
+		if inFlight[source] > peak[source] {
+			peak[source] = inFlight[source]
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		inFlight[source]--
+		mu.Unlock()
+		fmt.Fprint(w, "page ", r.URL)
+	}))
+	defer srv.Close()
+	polite := func(name string, concurrency int) *politeScraper {
+		var list []*PressRelease
+		for i := 0; i < 8; i++ {
+			list = append(list, &PressRelease{Source: name, Permalink: fmt.Sprintf("%s/%d?source=%s", srv.URL, i, name)})
+		}
+		return &politeScraper{listScraper{fakeScraper{name}, list}, Politeness{Concurrency: concurrency}}
+	}
+
+	// (run side by side, with the same global -concurrency)
+	store := NewMemStore()
+	var wg sync.WaitGroup
+	for _, scraper := range []*politeScraper{polite("fragile", 1), polite("sturdy", 4)} {
+		wg.Add(1)
+		go func(scraper *politeScraper) {
+			defer wg.Done()
+			doit(scraper, store, eventsource.NewServer())
+		}(scraper)
+	}
+	wg.Wait()
+	if peak["fragile"] != 1 {
+		t.Errorf("fragile source had %d requests in flight at once, want 1", peak["fragile"])
+	}
+	if peak["sturdy"] < 2 || peak["sturdy"] > 4 {
+		t.Errorf("sturdy source had %d requests in flight at once, want 2 to 4", peak["sturdy"])
+	}
+	if got := scrapeConcurrency(&fakeScraper{"default"}); got != *concurrency {
+		t.Errorf("got concurrency %d, want the default %d", got, *concurrency)
+	}
+
+	delayed := polite("delayed", 0)
+	delayed.politeness.Delay = 5 * time.Millisecond
+	setRequestDelay(delayed, "http://delayed.example.com/news")
+	setRequestDelay(polite("undelayed", 0), "http://undelayed.example.com/news")
+	limiter.Lock()
+	d, ok := limiter.delays["delayed.example.com"]
+	_, undelayed := limiter.delays["undelayed.example.com"]
+	limiter.Unlock()
+	if !ok || d != 5*time.Millisecond {
+		t.Errorf("got delay %s for the host, want 5ms", d)
+	}
+	if undelayed {
+		t.Errorf("a delay was set for a source without one")
+	}
+}
+
+// outageScraper's index is always showing an error
+type outageScraper struct{ fakeScraper }
+
+func (o *outageScraper) FetchList() ([]*PressRelease, error) { return nil, errProbableOutage }
+
+func TestOutage(t *testing.T) {
+	scrapeProblems = newProblemLog()
+	defer func() { scrapeProblems = newProblemLog() }()
+	errs := scrapeErrors.get("outage")
+	doit(&outageScraper{fakeScraper{"outage"}}, NewMemStore(), eventsource.NewServer())
+	problems := scrapeProblems.recent("outage")
+	if len(problems) != 1 || !strings.Contains(problems[0].Reason, "probable outage") {
+		t.Errorf("got problems %+v", problems)
+	}
+	scrapeStatus.Lock()
+	lastError := scrapeStatus.lastError["outage"]
+	scrapeStatus.Unlock()
+	if lastError != errProbableOutage.Error() {
+		t.Errorf("got last error %q in the status", lastError)
+	}
+	// (it's the site's problem, not the scraper's)
+	if got := scrapeErrors.get("outage"); got != errs {
+		t.Errorf("counted %v scrape errors", got-errs)
+	}
+}
+
+// racyStore is a Store which always says everything's new, as if
+// another run stashed things in between WhichAreNew and Stash
+type racyStore struct{ Store }
+
+func (r racyStore) WhichAreNew(incoming []*PressRelease) ([]*PressRelease, error) {
+	return incoming, nil
+}
+
+func TestStashAndPublishOnce(t *testing.T) {
+	setFlag(t, minContent, 0)
+	store := racyStore{NewMemStore()}
+	scraper := &fakeScraper{"publishonce"}
+	errs := scrapeErrors.get("publishonce")
+	published := 0
+	for i := 0; i < 2; i++ {
+		pr := &PressRelease{Title: "x", Source: "publishonce", Permalink: "http://example.com/1", PubDate: time.Now(), Content: "<p>x</p>"}
+		if stashAndPublish(scraper, store, eventsource.NewServer(), pr) != nil {
+			published++
+		}
+	}
+	if published != 1 {
+		t.Errorf("published %d times, want once", published)
+	}
+	if n, err := store.Count(QueryOptions{Source: "publishonce"}); err != nil || n != 1 {
+		t.Errorf("got %d stored (%v), want 1", n, err)
+	}
+	// (being beaten to it isn't a failure)
+	if got := scrapeErrors.get("publishonce"); got != errs {
+		t.Errorf("counted %v scrape errors", got-errs)
+	}
+}
+
+// Changes go out on the updated channels, which new press releases never do.
+func TestChangeChannels(t *testing.T) {
+	pr := &PressRelease{Source: "tesco", Lang: "en"}
+	if got := changeChannels(pr); fmt.Sprint(got) != "[tesco/updated all/updated]" {
+		t.Errorf("got change channels %v", got)
+	}
+	for _, channel := range eventChannels(pr) {
+		for _, updated := range changeChannels(pr) {
+			if channel == updated {
+				t.Errorf("%s carries new press releases too", channel)
+			}
+		}
+	}
+}
+
+// stuckScraper spins in Scrape, and blocks in FetchList, without a thought
+// for any context (until the test's over)
+type stuckScraper struct {
+	fakeScraper
+	stop chan struct{}
+}
+
+func newStuckScraper(t *testing.T) *stuckScraper {
+	s := &stuckScraper{fakeScraper{"stuck"}, make(chan struct{})}
+	t.Cleanup(func() { close(s.stop) })
+	return s
+}
+
+func (s *stuckScraper) Scrape(pr *PressRelease, rawHTML string) error {
+	for {
+		pr.Content = "spinning"
+		select {
+		case <-s.stop:
+			return nil
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+func (s *stuckScraper) FetchList() ([]*PressRelease, error) {
+	<-s.stop
+	return nil, nil
+}
+
+// contextScraper waits in ScrapeContext until it's told to give up
+type contextScraper struct {
+	fakeScraper
+	gaveUp chan bool
+}
+
+func (c *contextScraper) ScrapeContext(ctx context.Context, pr *PressRelease, rawHTML string) error {
+	<-ctx.Done()
+	c.gaveUp <- true
+	return ctx.Err()
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<p>hi</p>")
+	}))
+	defer srv.Close()
+	defer func(old time.Duration) { limiter.delay = old }(limiter.delay)
+	limiter.delay = 0
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	pr := &PressRelease{Permalink: srv.URL + "/1", Title: "Original"}
+	start := time.Now()
+	err := scrape(ctx, newStuckScraper(t), pr)
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("took %s to give up", took)
+	}
+	if !errors.Is(err, ErrTimeout) || !errors.Is(err, context.DeadlineExceeded) || !retryable(err) {
+		t.Errorf("got %v, want a retryable %v", err, ErrTimeout)
+	}
+	// (the scraper's still going, but it's got a copy to itself)
+	if pr.Content != "" || pr.Title != "Original" {
+		t.Errorf("got %+v after giving up", pr)
+	}
+
+	// a ContextScraper is told
+	cs := &contextScraper{fakeScraper{"stuck"}, make(chan bool, 1)}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := scrape(ctx, cs, &PressRelease{Permalink: srv.URL + "/2"}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want %v", err, ErrTimeout)
+	}
+	select {
+	case <-cs.gaveUp:
+	case <-time.After(time.Second):
+		t.Errorf("ContextScraper wasn't told to give up")
+	}
+
+	// -scrape-timeout applies without a deadline of its own
+	setFlag(t, scrapeTimeout, 1)
+	start = time.Now()
+	if err := scrape(context.Background(), newStuckScraper(t), &PressRelease{Permalink: srv.URL + "/3"}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("got %v, want %v", err, ErrTimeout)
+	}
+	if took := time.Since(start); took > 3*time.Second {
+		t.Errorf("took %s with -scrape-timeout=1", took)
+	}
+
+	// and fetching the list is cut off too
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := safeFetchList(ctx, newStuckScraper(t), time.Time{}); !errors.Is(err, ErrTimeout) {
+		t.Errorf("FetchList: got %v, want %v", err, ErrTimeout)
+	}
+}
+
+// Once the run's out of time, scrapeAll leaves the rest for next time.
+func TestScrapeAllOutOfTime(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<p>hi</p>")
+	}))
+	defer srv.Close()
+	defer func(old time.Duration) { limiter.delay = old }(limiter.delay)
+	limiter.delay = 0
+
+	var prs []*PressRelease
+	for i := 0; i < 10; i++ {
+		prs = append(prs, &PressRelease{Permalink: fmt.Sprintf("%s/%d", srv.URL, i)})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	ok := scrapeAll(ctx, newStuckScraper(t), prs, 2)
+	if took := time.Since(start); took > time.Second {
+		t.Errorf("took %s to stop", took)
+	}
+	for i, o := range ok {
+		if o {
+			t.Errorf("release %d done", i)
+		}
+	}
+}
+
+// The watermark only applies after a clean run, and goes back far enough
+// for the releases due a recheck.
+func TestListWatermark(t *testing.T) {
+	scraper := &ConfigScraper{ScraperName: "listwatermark", URL: "http://example.com/news", Links: "a.pr", IndexDate: ".date"}
+	store := NewMemStore()
+	setFlag(t, recheckFlag, 0)
+	defer cleanRuns.set("listwatermark", false)
+
+	cleanRuns.set("listwatermark", true)
+	if got := listWatermark(scraper, store); !got.IsZero() {
+		t.Errorf("with nothing stored: got %s, want zero", got)
+	}
+	published := time.Date(2024, 3, 12, 15, 0, 0, 0, time.UTC)
+	if _, err := store.Stash(&PressRelease{Source: "listwatermark", Permalink: "1", PubDate: published}); err != nil {
+		t.Fatal(err)
+	}
+	if got := listWatermark(scraper, store); !got.Equal(published) {
+		t.Errorf("got %s, want %s", got, published)
+	}
+	// (going by the one stashed most recently)
+	setFlag(t, recheckFlag, 24)
+	if got := listWatermark(scraper, store); !got.Equal(published) {
+		t.Errorf("with an earlier release: got %s, want %s", got, published)
+	}
+	if _, err := store.Stash(&PressRelease{Source: "listwatermark", Permalink: "2", PubDate: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if got := listWatermark(scraper, store); time.Since(got) < 23*time.Hour || time.Since(got) > 25*time.Hour {
+		t.Errorf("got %s, want the start of the recheck window", got)
+	}
+
+	cleanRuns.set("listwatermark", false)
+	if got := listWatermark(scraper, store); !got.IsZero() {
+		t.Errorf("after an unclean run: got %s, want zero", got)
+	}
+	cleanRuns.set("listwatermark", true)
+	if got := listWatermark(&fakeScraper{"listwatermark"}, store); !got.IsZero() {
+		t.Errorf("for a scraper which can't use it: got %s, want zero", got)
+	}
+}
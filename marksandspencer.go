@@ -16,6 +16,10 @@ func (scraper *MarksAndSpencerScraper) Name() string {
 	return "marksandspencer"
 }
 
+func (scraper *MarksAndSpencerScraper) Meta() ScraperMeta {
+	return ScraperMeta{DisplayName: "Marks & Spencer", BaseURL: "http://corporate.marksandspencer.com/"}
+}
+
 // fetches a list of latest press releases from MarksAndSpencer
 func (scraper *MarksAndSpencerScraper) FetchList() ([]*PressRelease, error) {
 	url := "http://corporate.marksandspencer.com/media/press_releases"
@@ -24,10 +28,12 @@ func (scraper *MarksAndSpencerScraper) FetchList() ([]*PressRelease, error) {
 }
 
 func (scraper *MarksAndSpencerScraper) Scrape(pr *PressRelease, raw_html string) error {
-	title := "#main h2"
-	content := "#pr_article"
-	// TODO: kill everything after: "-ENDS-"
-	cruft := "p.back-top, p.reference"
-	pubDate := "#main" // TODO: a more specific selector would be nice!
-	return GenericScrape(scraper.Name(), pr, raw_html, title, content, cruft, pubDate)
+	spec := ScrapeSpec{
+		Title:     []string{"#main h2"},
+		Content:   []string{"#pr_article"},
+		Cruft:     []string{"p.back-top", "p.reference"},
+		PubDate:   []string{"#main"}, // TODO: a more specific selector would be nice!
+		EndMarker: DefaultEndMarker,
+	}
+	return spec.Scrape(scraper.Name(), pr, raw_html)
 }
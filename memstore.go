@@ -0,0 +1,444 @@
+package main
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// MemStore is a Store which just keeps everything in memory.
+// Handy for tests, or for running the server without a db file.
+type MemStore struct {
+	sync.Mutex
+	entries []*memEntry // in stash (and id) order
+	nextId  int
+}
+
+// memEntry is a single press release held in a MemStore
+type memEntry struct {
+	id      int
+	pr      *PressRelease
+	stashed time.Time
+	// earlier versions, if it's been updated (oldest first)
+	revisions []*PressRelease
+	// urls of near-duplicates (see LinkDuplicate)
+	duplicates []string
+}
+
+func NewMemStore() *MemStore {
+	return &MemStore{nextId: 1}
+}
+
+// matches returns true if the stored press release looks like the same one
+// as pr, going by permalink, final url, canonical url or content hash.
+func (entry *memEntry) matches(pr *PressRelease) bool {
+	got := entry.pr
+	if got.Source != pr.Source {
+		return false
+	}
+	for _, u := range pr.aliases() {
+		for _, g := range append(got.aliases(), entry.duplicates...) {
+			if u == g {
+				return true
+			}
+		}
+	}
+	return pr.ContentHash != "" && pr.ContentHash == got.ContentHash
+}
+
+// returns a list of press releases with the ones already in the store culled out
+// (and near-duplicates, with -dedup)
+func (store *MemStore) WhichAreNew(incoming []*PressRelease) ([]*PressRelease, error) {
+	store.Lock()
+	defer store.Unlock()
+	var unseen []*PressRelease
+	for _, pr := range incoming {
+		seen := false
+		for _, entry := range store.entries {
+			if entry.matches(pr) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			if id := store.nearDuplicate(pr); id != 0 {
+				pr.duplicateOf = id
+				seen = true
+			}
+		}
+		if !seen {
+			unseen = append(unseen, pr)
+		}
+	}
+	return unseen, nil
+}
+
+// nearDuplicate returns the id of a recent press release which pr looks
+// like a near-duplicate of (see -dedup), or 0 if there isn't one
+func (store *MemStore) nearDuplicate(pr *PressRelease) int {
+	if *dedupFlag <= 0 || pr.simhash == 0 {
+		return 0
+	}
+	checked := 0
+	for i := len(store.entries) - 1; i >= 0 && checked < dedupWindow; i-- {
+		entry := store.entries[i]
+		if entry.pr.Source != pr.Source {
+			continue
+		}
+		checked++
+		if nearDuplicate(pr.simhash, entry.pr.simhash) {
+			return entry.id
+		}
+	}
+	return 0
+}
+
+// LinkDuplicate records url as a near-duplicate of the stored press
+// release id.
+func (store *MemStore) LinkDuplicate(id int, url string) error {
+	store.Lock()
+	defer store.Unlock()
+	entry := store.find(allChannel, id)
+	if entry == nil {
+		return errNotFound
+	}
+	entry.duplicates = append(entry.duplicates, url)
+	return nil
+}
+
+// Stash adds a press release into the store (unless it's already there -
+// see Store)
+func (store *MemStore) Stash(pr *PressRelease) (*pressReleaseEvent, error) {
+	store.Lock()
+	defer store.Unlock()
+	for _, entry := range store.entries {
+		if entry.pr.Source == pr.Source && (entry.pr.Permalink == pr.Permalink || (pr.ContentHash != "" && entry.pr.ContentHash == pr.ContentHash)) {
+			return nil, errAlreadyStashed
+		}
+	}
+	// keep our own copy, so the caller can't change it under us
+	cpy := *pr
+	cpy.URLs = append([]string(nil), pr.URLs...)
+	cpy.Tags = append([]string(nil), pr.Tags...)
+	cpy.PubDate = pr.PubDate.UTC()
+	cpy.complete = true
+	cpy.id = store.nextId
+	entry := &memEntry{id: store.nextId, pr: &cpy, stashed: time.Now()}
+	store.nextId++
+	store.entries = append(store.entries, entry)
+	return &pressReleaseEvent{payload: pr, id: entry.id}, nil
+}
+
+// Lookup finds the stored press release for a url (its permalink, where
+// that ended up, or its canonical url).
+func (store *MemStore) Lookup(source, url string) (*pressReleaseEvent, error) {
+	store.Lock()
+	defer store.Unlock()
+	for i := len(store.entries) - 1; i >= 0; i-- {
+		entry := store.entries[i]
+		pr := entry.pr
+		if pr.Source != source {
+			continue
+		}
+		for _, u := range pr.aliases() {
+			if u == url {
+				cpy := *pr
+				return &pressReleaseEvent{payload: &cpy, id: entry.id}, nil
+			}
+		}
+	}
+	return nil, errNotFound
+}
+
+// Update replaces a stored press release with a newer revision of it,
+// keeping the old one.
+func (store *MemStore) Update(id int, pr *PressRelease) (*pressReleaseEvent, error) {
+	store.Lock()
+	defer store.Unlock()
+	entry := store.find(allChannel, id)
+	if entry == nil {
+		return nil, errNotFound
+	}
+	pr.LastModified = time.Now()
+	cpy := *pr
+	cpy.URLs = append([]string(nil), pr.URLs...)
+	cpy.Tags = append([]string(nil), pr.Tags...)
+	cpy.Source = entry.pr.Source
+	cpy.PubDate = entry.pr.PubDate
+	cpy.complete = true
+	cpy.id = id
+	entry.revisions = append(entry.revisions, entry.pr)
+	entry.pr = &cpy
+	return &pressReleaseEvent{payload: pr, id: id, updated: true}, nil
+}
+
+// Query fetches press releases from the store, most recently stashed first.
+func (store *MemStore) Query(opts QueryOptions) ([]*PressRelease, error) {
+	store.Lock()
+	defer store.Unlock()
+	out := []*PressRelease{}
+	skipped := 0
+	for i := len(store.entries) - 1; i >= 0; i-- {
+		pr := store.entries[i].pr
+		if !store.entries[i].picked(opts) {
+			continue
+		}
+		if opts.Limit > 0 && skipped < opts.Offset {
+			skipped++
+			continue
+		}
+		cpy := *pr
+		out = append(out, &cpy)
+		if opts.Limit > 0 && len(out) >= opts.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// Count returns the number of press releases which Query would return for
+// opts (ignoring Limit and Offset).
+func (store *MemStore) Count(opts QueryOptions) (int, error) {
+	store.Lock()
+	defer store.Unlock()
+	n := 0
+	for _, entry := range store.entries {
+		if entry.picked(opts) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// picked returns true if the entry is one of the press releases picked out
+// by opts (ignoring Limit and Offset)
+func (entry *memEntry) picked(opts QueryOptions) bool {
+	pr := entry.pr
+	switch {
+	case opts.AfterID > 0 && entry.id >= opts.AfterID:
+		return false
+	case opts.Source != "" && pr.Source != opts.Source:
+		return false
+	case opts.Lang != "" && pr.Lang != opts.Lang:
+		return false
+	case opts.Tag != "" && !hasTag(pr, opts.Tag):
+		return false
+	case !opts.Since.IsZero() && pr.PubDate.Before(opts.Since):
+		return false
+	}
+	return true
+}
+
+// Search fetches press releases matching a search query (see
+// parseSearchQuery), most recently stashed first.
+// Just a case-insensitive match on whole words in the title and text, with
+// no stemming or anything clever.
+func (store *MemStore) Search(q string, opts QueryOptions) ([]*PressRelease, error) {
+	terms := parseSearchQuery(q)
+	for i, term := range terms {
+		terms[i] = normaliseWords(term)
+	}
+	if len(terms) == 0 {
+		return []*PressRelease{}, nil
+	}
+	matchOpts := opts
+	matchOpts.Limit = 0
+	matchOpts.Offset = 0
+	candidates, err := store.Query(matchOpts)
+	if err != nil {
+		return nil, err
+	}
+	out := []*PressRelease{}
+	skipped := 0
+	for _, pr := range candidates {
+		txt := normaliseWords(pr.Title + " " + plainText(pr.Content))
+		matched := true
+		for _, term := range terms {
+			if !strings.Contains(txt, term) {
+				matched = false
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		if opts.Limit > 0 && skipped < opts.Offset {
+			skipped++
+			continue
+		}
+		out = append(out, pr)
+		if opts.Limit > 0 && len(out) >= opts.Limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+// normaliseWords lowercases s and boils it down to just the words, with
+// single spaces around each one (so whole words or phrases can be searched
+// for with strings.Contains)
+func normaliseWords(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return " " + strings.Join(words, " ") + " "
+}
+
+// hasTag returns true if pr has the tag (ignoring case)
+func hasTag(pr *PressRelease, tag string) bool {
+	for _, t := range pr.Tags {
+		if strings.EqualFold(t, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// SourceCounts returns the number of stored press releases for each source.
+func (store *MemStore) SourceCounts() (map[string]int, error) {
+	store.Lock()
+	defer store.Unlock()
+	counts := make(map[string]int)
+	for _, entry := range store.entries {
+		counts[entry.pr.Source]++
+	}
+	return counts, nil
+}
+
+// Prune deletes press releases stashed more than maxAge ago, and returns the
+// number of press releases removed.
+func (store *MemStore) Prune(maxAge time.Duration) (int, error) {
+	store.Lock()
+	defer store.Unlock()
+	cutoff := time.Now().Add(-maxAge)
+	// entries are in stash order, so the old ones are all at the front
+	n := sort.Search(len(store.entries), func(i int) bool {
+		return !store.entries[i].stashed.Before(cutoff)
+	})
+	store.entries = store.entries[n:]
+	return n, nil
+}
+
+// Vacuum is a no-op for MemStore (pruned entries are just garbage
+// collected).
+func (store *MemStore) Vacuum() error {
+	return nil
+}
+
+// Stats reports the number of press releases for each source. There's
+// nothing on disk, so Bytes is always 0.
+func (store *MemStore) Stats() (StoreStats, error) {
+	counts, err := store.SourceCounts()
+	if err != nil {
+		return StoreStats{}, err
+	}
+	store.Lock()
+	defer store.Unlock()
+	lastIDs := make(map[string]int)
+	for _, entry := range store.entries {
+		// (entries are in id order)
+		lastIDs[entry.pr.Source] = entry.id
+	}
+	return StoreStats{Counts: counts, LastIDs: lastIDs}, nil
+}
+
+// Close shuts down the store, once it's finished with. A no-op for MemStore.
+func (store *MemStore) Close() error {
+	return nil
+}
+
+// find returns the entry with the given id on a channel, or nil
+func (store *MemStore) find(channel string, id int) *memEntry {
+	i := sort.Search(len(store.entries), func(i int) bool {
+		return store.entries[i].id >= id
+	})
+	if i < len(store.entries) {
+		entry := store.entries[i]
+		if entry.id == id && (channel == allChannel || entry.pr.Source == channel) {
+			return entry
+		}
+	}
+	return nil
+}
+
+// Get fetches a single press release by id.
+// note: source can be allChannel, to match any source
+func (store *MemStore) Get(source, eventId string) (*PressRelease, error) {
+	id, err := strconv.Atoi(eventId)
+	if err != nil {
+		return nil, errBadId
+	}
+	store.Lock()
+	defer store.Unlock()
+	entry := store.find(source, id)
+	if entry == nil {
+		return nil, errNotFound
+	}
+	cpy := *entry.pr
+	return &cpy, nil
+}
+
+// Replay to handle last-event-id catchups
+// note: channel contains the source (eg 'tesco'...) or allChannel
+// If lastEventId predates the oldest press release (ie it's been pruned),
+// everything is replayed.
+func (store *MemStore) Replay(channel, lastEventId string) (chan string, error) {
+	store.Lock()
+	after := 0
+	if lastEventId != "" {
+		var err error
+		after, err = strconv.Atoi(lastEventId)
+		if err != nil {
+			store.Unlock()
+			return nil, errBadId
+		}
+		if after >= store.nextId {
+			store.Unlock()
+			return nil, errNotFound
+		}
+	}
+	// (as for SQLiteStore, only what's already there, a batch at a time)
+	upTo := store.nextId - 1
+	store.Unlock()
+
+	ids := make(chan string)
+	go func() {
+		defer close(ids)
+		for {
+			batch := store.replayBatch(channel, after, upTo)
+			for _, id := range batch {
+				ids <- strconv.Itoa(id)
+			}
+			if len(batch) < replayBatchSize() {
+				return
+			}
+			after = batch[len(batch)-1]
+		}
+	}()
+	return ids, nil
+}
+
+// replayBatch returns the next batch of ids for Replay: those on channel
+// after after, up to and including upTo
+func (store *MemStore) replayBatch(channel string, after, upTo int) []int {
+	store.Lock()
+	defer store.Unlock()
+	n := replayBatchSize()
+	var batch []int
+	i := sort.Search(len(store.entries), func(i int) bool {
+		return store.entries[i].id > after
+	})
+	for ; i < len(store.entries) && len(batch) < n; i++ {
+		entry := store.entries[i]
+		if entry.id > upTo {
+			break
+		}
+		if channel == allChannel || entry.pr.Source == channel {
+			batch = append(batch, entry.id)
+		}
+	}
+	return batch
+}
@@ -0,0 +1,135 @@
+package main
+
+// Bare-bones prometheus-style metrics, served up at /metrics in the
+// prometheus text format. Everything is labelled by source.
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// counterVec is a set of counters, one per source
+type counterVec struct {
+	sync.Mutex
+	name   string
+	help   string
+	values map[string]float64
+}
+
+// histogramVec is a set of histograms, one per source
+type histogramVec struct {
+	sync.Mutex
+	name    string
+	help    string
+	buckets []float64 // upper bounds, ascending
+	counts  map[string][]uint64
+	sums    map[string]float64
+	totals  map[string]uint64
+}
+
+var (
+	releasesFetched = newCounterVec("ukpr_releases_fetched_total", "Press releases returned by FetchList.")
+	releasesStashed = newCounterVec("ukpr_releases_stashed_total", "New press releases stashed.")
+	releasesUpdated = newCounterVec("ukpr_releases_updated_total", "Press releases which have been changed by the source since being stashed.")
+	scrapeErrors    = newCounterVec("ukpr_scrape_errors_total", "Errors fetching lists, scraping or stashing press releases.")
+	fetchDuration   = newHistogramVec("ukpr_fetch_duration_seconds", "Time taken to fetch press release pages.",
+		[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30})
+)
+
+func newCounterVec(name, help string) *counterVec {
+	return &counterVec{name: name, help: help, values: make(map[string]float64)}
+}
+
+func (c *counterVec) add(source string, n float64) {
+	c.Lock()
+	defer c.Unlock()
+	c.values[source] += n
+}
+
+func (c *counterVec) inc(source string) {
+	c.add(source, 1)
+}
+
+func (c *counterVec) get(source string) float64 {
+	c.Lock()
+	defer c.Unlock()
+	return c.values[source]
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.Lock()
+	defer c.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", c.name, c.help, c.name)
+	for _, source := range sortedKeys(c.values) {
+		fmt.Fprintf(w, "%s{source=%q} %s\n", c.name, source, formatFloat(c.values[source]))
+	}
+}
+
+func newHistogramVec(name, help string, buckets []float64) *histogramVec {
+	return &histogramVec{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make(map[string][]uint64),
+		sums:    make(map[string]float64),
+		totals:  make(map[string]uint64),
+	}
+}
+
+func (h *histogramVec) observe(source string, v float64) {
+	h.Lock()
+	defer h.Unlock()
+	counts, ok := h.counts[source]
+	if !ok {
+		counts = make([]uint64, len(h.buckets))
+		h.counts[source] = counts
+	}
+	for i, upper := range h.buckets {
+		if v <= upper {
+			counts[i]++
+		}
+	}
+	h.sums[source] += v
+	h.totals[source]++
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.Lock()
+	defer h.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, source := range sortedKeys(h.sums) {
+		for i, upper := range h.buckets {
+			fmt.Fprintf(w, "%s_bucket{source=%q,le=\"%s\"} %d\n", h.name, source, formatFloat(upper), h.counts[source][i])
+		}
+		fmt.Fprintf(w, "%s_bucket{source=%q,le=\"+Inf\"} %d\n", h.name, source, h.totals[source])
+		fmt.Fprintf(w, "%s_sum{source=%q} %s\n", h.name, source, formatFloat(h.sums[source]))
+		fmt.Fprintf(w, "%s_count{source=%q} %d\n", h.name, source, h.totals[source])
+	}
+}
+
+func sortedKeys(m map[string]float64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// metricsHandler serves up all the metrics in prometheus text format
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	releasesFetched.write(w)
+	releasesStashed.write(w)
+	releasesUpdated.write(w)
+	scrapeErrors.write(w)
+	fetchDuration.write(w)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/donovanhide/eventsource"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogramVec("test_seconds", "Test.", []float64{0.1, 1})
+	for _, v := range []float64{0.05, 0.5, 5} {
+		h.observe("tesco", v)
+	}
+	var out strings.Builder
+	h.write(&out)
+	want := `# HELP test_seconds Test.
+# TYPE test_seconds histogram
+test_seconds_bucket{source="tesco",le="0.1"} 1
+test_seconds_bucket{source="tesco",le="1"} 2
+test_seconds_bucket{source="tesco",le="+Inf"} 3
+test_seconds_sum{source="tesco"} 5.55
+test_seconds_count{source="tesco"} 3
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	setFlag(t, minContent, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer srv.Close()
+	// (the metrics are global, so each run gets a source of its own)
+	source := fmt.Sprint("metrics", time.Now().UnixNano())
+	scraper := &listScraper{fakeScraper{source}, []*PressRelease{
+		{Source: source, Permalink: srv.URL + "/1"},
+		{Source: source, Permalink: srv.URL + "/2"},
+		{Source: source, Permalink: srv.URL + "/gone"},
+	}}
+	doit(scraper, NewMemStore(), eventsource.NewServer())
+	for _, test := range []struct {
+		name      string
+		got, want float64
+	}{
+		{"fetched", releasesFetched.get(source), 3},
+		{"stashed", releasesStashed.get(source), 2},
+		{"errors", scrapeErrors.get(source), 1},
+	} {
+		if test.got != test.want {
+			t.Errorf("got %v %s, want %v", test.got, test.name, test.want)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		fmt.Sprintf(`ukpr_releases_fetched_total{source="%s"} 3`, source),
+		fmt.Sprintf(`ukpr_releases_stashed_total{source="%s"} 2`, source),
+		fmt.Sprintf(`ukpr_scrape_errors_total{source="%s"} 1`, source),
+		fmt.Sprintf(`ukpr_fetch_duration_seconds_count{source="%s"} 2`, source),
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("no %s in:\n%s", want, w.Body.String())
+		}
+	}
+}
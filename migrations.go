@@ -0,0 +1,258 @@
+package main
+
+// Schema migrations for SQLiteStore, so existing dbs get upgraded in place
+// as columns are added.
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration is a single step in upgrading the schema. Steps should be safe
+// to run on a db which already has the change (dbs from before versioning
+// was added might have some of the columns already).
+type migration func(tx *sql.Tx) error
+
+// sqliteMigrations are applied in order - the schema version is the number
+// of them which have been applied. Only ever add to the end!
+var sqliteMigrations = []migration{
+	// 1: the original table
+	execMigration(`CREATE TABLE IF NOT EXISTS press_release (
+         id INTEGER PRIMARY KEY,
+         title TEXT NOT NULL,
+         source TEXT NOT NULL,
+         permalink TEXT NOT NULL,
+         pubdate DATETIME NOT NULL,
+         content TEXT NOT NULL )`),
+	// 2: stash times, for pruning (anything already there is taken to have
+	// just been stashed)
+	func(tx *sql.Tx) error {
+		err := addColumn(tx, "press_release", "stashed", "DATETIME NOT NULL DEFAULT ''")
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("UPDATE press_release SET stashed=$1 WHERE stashed=''", time.Now().UTC())
+		return err
+	},
+	// 3-10: the columns added since
+	addColumnMigration("urls", "TEXT NOT NULL DEFAULT ''"),
+	addColumnMigration("final_url", "TEXT NOT NULL DEFAULT ''"),
+	addColumnMigration("content_hash", "TEXT NOT NULL DEFAULT ''"),
+	addColumnMigration("text", "TEXT NOT NULL DEFAULT ''"),
+	addColumnMigration("notes", "TEXT NOT NULL DEFAULT ''"),
+	addColumnMigration("image_url", "TEXT NOT NULL DEFAULT ''"),
+	addColumnMigration("lang", "TEXT NOT NULL DEFAULT ''"),
+	addColumnMigration("tags", "TEXT NOT NULL DEFAULT ''"),
+	// 11-13: indexes for WhichAreNew
+	execMigration(`CREATE INDEX IF NOT EXISTS press_release_permalink ON press_release (source, permalink)`),
+	execMigration(`CREATE INDEX IF NOT EXISTS press_release_final_url ON press_release (source, final_url)`),
+	execMigration(`CREATE INDEX IF NOT EXISTS press_release_content_hash ON press_release (source, content_hash)`),
+	// 14-16: updates, with the earlier versions kept in press_release_revision
+	addColumnMigration("last_modified", "DATETIME"),
+	execMigration(`CREATE TABLE IF NOT EXISTS press_release_revision (
+         id INTEGER PRIMARY KEY,
+         release_id INTEGER NOT NULL,
+         title TEXT NOT NULL,
+         urls TEXT NOT NULL,
+         final_url TEXT NOT NULL,
+         content TEXT NOT NULL,
+         text TEXT NOT NULL,
+         notes TEXT NOT NULL,
+         image_url TEXT NOT NULL,
+         lang TEXT NOT NULL,
+         tags TEXT NOT NULL,
+         content_hash TEXT NOT NULL,
+         last_modified DATETIME,
+         replaced DATETIME NOT NULL )`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS press_release_revision_delete AFTER DELETE ON press_release BEGIN
+         DELETE FROM press_release_revision WHERE release_id=old.id;
+         END`),
+	// 17-18: archived html (see -archive-html)
+	addColumnMigration("raw_html_path", "TEXT NOT NULL DEFAULT ''"),
+	func(tx *sql.Tx) error {
+		return addColumn(tx, "press_release_revision", "raw_html_path", "TEXT NOT NULL DEFAULT ''")
+	},
+	// 19-20: canonical urls, for dedup
+	addColumnMigration("canonical_url", "TEXT NOT NULL DEFAULT ''"),
+	execMigration(`CREATE INDEX IF NOT EXISTS press_release_canonical_url ON press_release (source, canonical_url)`),
+	// 21-24: simhashes, and the urls of near-duplicates (see -dedup)
+	addColumnMigration("simhash", "INTEGER NOT NULL DEFAULT 0"),
+	execMigration(`CREATE TABLE IF NOT EXISTS press_release_duplicate (
+         id INTEGER PRIMARY KEY,
+         release_id INTEGER NOT NULL,
+         url TEXT NOT NULL )`),
+	execMigration(`CREATE INDEX IF NOT EXISTS press_release_duplicate_url ON press_release_duplicate (url)`),
+	execMigration(`CREATE TRIGGER IF NOT EXISTS press_release_duplicate_delete AFTER DELETE ON press_release BEGIN
+         DELETE FROM press_release_duplicate WHERE release_id=old.id;
+         END`),
+	// 25-26: author bylines
+	addColumnMigration("author", "TEXT NOT NULL DEFAULT ''"),
+	func(tx *sql.Tx) error {
+		return addColumn(tx, "press_release_revision", "author", "TEXT NOT NULL DEFAULT ''")
+	},
+	// 27: AUTOINCREMENT ids, so they're never handed out again (without it,
+	// sqlite reuses the highest id if it's deleted, and starts again from
+	// 1 if the table is pruned right down - and clients resuming with an
+	// older Last-Event-ID would miss the new releases)
+	autoIncrementMigration,
+	// 28-30: one press release per permalink, so Stash can't add the same
+	// one twice (any duplicates which crept in earlier are dropped, keeping
+	// the first)
+	execMigration(`DELETE FROM press_release WHERE id NOT IN (SELECT MIN(id) FROM press_release GROUP BY source, permalink)`),
+	execMigration(`DROP INDEX IF EXISTS press_release_permalink`),
+	execMigration(`CREATE UNIQUE INDEX IF NOT EXISTS press_release_permalink_unique ON press_release (source, permalink)`),
+	// 31-32: excerpts
+	addColumnMigration("excerpt", "TEXT NOT NULL DEFAULT ''"),
+	func(tx *sql.Tx) error {
+		return addColumn(tx, "press_release_revision", "excerpt", "TEXT NOT NULL DEFAULT ''")
+	},
+}
+
+// autoIncrementMigration rebuilds press_release with an AUTOINCREMENT id
+// (sqlite can't alter a column in place). The ids are kept as they are.
+// The indexes and triggers go with the old table, so they're put back as
+// per the earlier migrations (the search trigger is taken care of by
+// setupSearch).
+func autoIncrementMigration(tx *sql.Tx) error {
+	const columns = "id,title,source,permalink,pubdate,content,stashed,urls,final_url,content_hash,text,notes,image_url,lang,tags,last_modified,raw_html_path,canonical_url,simhash,author"
+	steps := []string{
+		`DROP TRIGGER IF EXISTS press_release_revision_delete`,
+		`DROP TRIGGER IF EXISTS press_release_duplicate_delete`,
+		`DROP TRIGGER IF EXISTS press_release_fts_delete`,
+		`CREATE TABLE press_release_new (
+         id INTEGER PRIMARY KEY AUTOINCREMENT,
+         title TEXT NOT NULL,
+         source TEXT NOT NULL,
+         permalink TEXT NOT NULL,
+         pubdate DATETIME NOT NULL,
+         content TEXT NOT NULL,
+         stashed DATETIME NOT NULL DEFAULT '',
+         urls TEXT NOT NULL DEFAULT '',
+         final_url TEXT NOT NULL DEFAULT '',
+         content_hash TEXT NOT NULL DEFAULT '',
+         text TEXT NOT NULL DEFAULT '',
+         notes TEXT NOT NULL DEFAULT '',
+         image_url TEXT NOT NULL DEFAULT '',
+         lang TEXT NOT NULL DEFAULT '',
+         tags TEXT NOT NULL DEFAULT '',
+         last_modified DATETIME,
+         raw_html_path TEXT NOT NULL DEFAULT '',
+         canonical_url TEXT NOT NULL DEFAULT '',
+         simhash INTEGER NOT NULL DEFAULT 0,
+         author TEXT NOT NULL DEFAULT '' )`,
+		`INSERT INTO press_release_new (` + columns + `) SELECT ` + columns + ` FROM press_release`,
+		`DROP TABLE press_release`,
+		`ALTER TABLE press_release_new RENAME TO press_release`,
+		// (as for migrations 11-13, 16, 20 and 24)
+		`CREATE INDEX press_release_permalink ON press_release (source, permalink)`,
+		`CREATE INDEX press_release_final_url ON press_release (source, final_url)`,
+		`CREATE INDEX press_release_content_hash ON press_release (source, content_hash)`,
+		`CREATE INDEX press_release_canonical_url ON press_release (source, canonical_url)`,
+		`CREATE TRIGGER press_release_revision_delete AFTER DELETE ON press_release BEGIN
+         DELETE FROM press_release_revision WHERE release_id=old.id;
+         END`,
+		`CREATE TRIGGER press_release_duplicate_delete AFTER DELETE ON press_release BEGIN
+         DELETE FROM press_release_duplicate WHERE release_id=old.id;
+         END`,
+	}
+	for _, q := range steps {
+		if _, err := tx.Exec(q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execMigration is a migration which just runs some sql
+func execMigration(q string) migration {
+	return func(tx *sql.Tx) error {
+		_, err := tx.Exec(q)
+		return err
+	}
+}
+
+// addColumnMigration is a migration which adds a column to press_release
+func addColumnMigration(column, def string) migration {
+	return func(tx *sql.Tx) error {
+		return addColumn(tx, "press_release", column, def)
+	}
+}
+
+// addColumn adds a column to a table, unless it's already there
+func addColumn(tx *sql.Tx, table, column, def string) error {
+	rows, err := tx.Query("PRAGMA table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	found := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var dflt sql.NullString
+		err = rows.Scan(&cid, &name, &colType, &notNull, &dflt, &pk)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			found = true
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil || found {
+		return err
+	}
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, def))
+	return err
+}
+
+// migrate brings the schema up to date, applying any migrations which
+// haven't been yet (each in its own transaction, along with the bump to
+// schema_version).
+func (store *SQLiteStore) migrate() error {
+	_, err := store.db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`)
+	if err != nil {
+		return err
+	}
+	var version int
+	err = store.db.QueryRow("SELECT version FROM schema_version").Scan(&version)
+	if err == sql.ErrNoRows {
+		_, err = store.db.Exec("INSERT INTO schema_version (version) VALUES (0)")
+	}
+	if err != nil {
+		return err
+	}
+	if version > len(sqliteMigrations) {
+		return fmt.Errorf("db schema version %d is newer than this build knows about (%d)", version, len(sqliteMigrations))
+	}
+	if version > 0 && version < len(sqliteMigrations) {
+		infof("migrating db from schema version %d to %d", version, len(sqliteMigrations))
+	}
+	for ; version < len(sqliteMigrations); version++ {
+		err = store.applyMigration(version)
+		if err != nil {
+			return fmt.Errorf("migrating db to schema version %d: %s", version+1, err)
+		}
+	}
+	return nil
+}
+
+// applyMigration runs sqliteMigrations[i], and updates schema_version to
+// match
+func (store *SQLiteStore) applyMigration(i int) error {
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	err = sqliteMigrations[i](tx)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE schema_version SET version=$1", i+1)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// oldDB makes an sqlite db with the given schema, as left by an older build
+func oldDB(t *testing.T, schema ...string) string {
+	filename := t.TempDir() + "/prstore.db"
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt, time.Now().UTC()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filename
+}
+
+func TestMigrate(t *testing.T) {
+	// the original schema, with a release in it
+	filename := oldDB(t,
+		`CREATE TABLE press_release (id INTEGER PRIMARY KEY, title TEXT NOT NULL, source TEXT NOT NULL, permalink TEXT NOT NULL, pubdate DATETIME NOT NULL, content TEXT NOT NULL)`,
+		`INSERT INTO press_release (title,source,permalink,pubdate,content) VALUES ('Old','tesco','http://example.com/old',$1,'<p>old</p>')`,
+	)
+	// (the second time round it's already up to date)
+	for i := 0; i < 2; i++ {
+		store, err := NewSQLiteStore(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var version int
+		if err := store.db.QueryRow("SELECT version FROM schema_version").Scan(&version); err != nil {
+			t.Fatal(err)
+		}
+		if version != len(sqliteMigrations) {
+			t.Errorf("open %d: got schema version %d, want %d", i, version, len(sqliteMigrations))
+		}
+		prs, err := store.Query(QueryOptions{Source: "tesco"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(prs) != i+1 || prs[len(prs)-1].Title != "Old" || prs[len(prs)-1].Content != "<p>old</p>" {
+			t.Errorf("open %d: got %v", i, prs)
+		}
+		if n := countNew(t, store, &PressRelease{Source: "tesco", Permalink: "http://example.com/old"}); n != 0 {
+			t.Errorf("open %d: old release is new again", i)
+		}
+		// (the new columns work)
+		pr := &PressRelease{Title: "New", Source: "tesco", Permalink: fmt.Sprintf("http://example.com/new%d", i), Tags: []string{"food"}, ImageURL: "http://example.com/1.jpg"}
+		if got := roundTrip(t, store, pr); got.ImageURL != pr.ImageURL || len(got.Tags) != 1 {
+			t.Errorf("open %d: got %+v", i, got)
+		}
+		store.Close()
+	}
+}
+
+// A db from partway along (with some of the columns, but from before the
+// schema was versioned) migrates too.
+func TestMigrateUnversioned(t *testing.T) {
+	filename := oldDB(t,
+		`CREATE TABLE press_release (id INTEGER PRIMARY KEY, title TEXT NOT NULL, source TEXT NOT NULL, permalink TEXT NOT NULL, urls TEXT NOT NULL DEFAULT '', pubdate DATETIME NOT NULL, content TEXT NOT NULL, stashed DATETIME NOT NULL)`,
+		`INSERT INTO press_release (title,source,permalink,pubdate,content,stashed) VALUES ('Mid','asda','http://example.com/mid',$1,'<p>mid</p>',$1)`,
+	)
+	store, err := NewSQLiteStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	prs, err := store.Query(QueryOptions{})
+	if err != nil || len(prs) != 1 || prs[0].Title != "Mid" {
+		t.Errorf("got %v (%v)", prs, err)
+	}
+}
+
+func TestMigrateTooNew(t *testing.T) {
+	filename := t.TempDir() + "/prstore.db"
+	store, err := NewSQLiteStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.db.Exec("UPDATE schema_version SET version=version+1"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	if store, err := NewSQLiteStore(filename); err == nil {
+		store.Close()
+		t.Errorf("no error opening a db from a newer build")
+	}
+}
+
+// Any duplicate permalinks from before Stash was idempotent are dropped
+// (keeping the first) when the unique index goes on.
+func TestMigrateDuplicatePermalinks(t *testing.T) {
+	filename := t.TempDir() + "/prstore.db"
+	store, err := NewSQLiteStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Stash(&PressRelease{Title: "first", Source: "tesco", Permalink: "http://example.com/1", PubDate: time.Now(), Content: "<p>x</p>"}); err != nil {
+		t.Fatal(err)
+	}
+	// (back to before migration 28, with a duplicate)
+	for _, stmt := range []string{
+		`DROP INDEX press_release_permalink_unique`,
+		`INSERT INTO press_release (title,source,permalink,pubdate,content,stashed) VALUES ('second','tesco','http://example.com/1',datetime('now'),'<p>x</p>',datetime('now'))`,
+		`UPDATE schema_version SET version=27`,
+	} {
+		if _, err := store.db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+
+	store, err = NewSQLiteStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	prs, err := store.Query(QueryOptions{})
+	if err != nil || len(prs) != 1 || prs[0].Title != "first" {
+		t.Errorf("got %v (%v), want just the first", prs, err)
+	}
+}
@@ -17,6 +17,10 @@ func (scraper *MorrisonsScraper) Name() string {
 	return "morrisons"
 }
 
+func (scraper *MorrisonsScraper) Meta() ScraperMeta {
+	return ScraperMeta{DisplayName: "Morrisons", BaseURL: "http://www.morrisons-corporate.com/"}
+}
+
 // TODO: morrisons press releases don't have dates on the individual pages.
 // should extract dates during FetchList()
 
@@ -35,6 +39,5 @@ func (scraper *MorrisonsScraper) Scrape(pr *PressRelease, raw_html string) error
 	title := ".morrisons-header h2"
 	content := ".morrisons-content .inside_left_block"
 	cruft := "script, .button_divider, .featured_funnels, .block2Inner"
-	pubDate := ""
-	return GenericScrape(scraper.Name(), pr, raw_html, title, content, cruft, pubDate)
+	return GenericScrape(scraper.Name(), pr, raw_html, []string{title}, []string{content}, cruft, nil)
 }
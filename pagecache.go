@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// pageCache keeps the most recently fetched pages from the source sites in
+// memory for a little while (see -page-cache), so pages which get asked for
+// again soon after (eg by retries, or overlapping backfills) don't have to
+// be fetched again. Only successful GETs are kept. It's safe for concurrent
+// use.
+type pageCache struct {
+	sync.Mutex
+	size  int // max number of pages kept (0 = off)
+	ttl   time.Duration
+	lru   *list.List // of *cachedPage, most recently used first
+	pages map[string]*list.Element
+}
+
+// cachedPage is a response, with the body read in
+type cachedPage struct {
+	url      string
+	fetched  time.Time
+	status   int
+	header   http.Header
+	body     []byte
+	finalURL *url.URL // where the request ended up, after any redirects
+}
+
+// how long pages are kept for - short enough that an index page will have
+// been fetched afresh by the time its source is next polled
+const pageCacheTTL = time.Minute
+
+// pages is the cache used by politeDo (off until run sets the size)
+var pages = newPageCache(0, pageCacheTTL)
+
+func newPageCache(size int, ttl time.Duration) *pageCache {
+	return &pageCache{size: size, ttl: ttl, lru: list.New(), pages: make(map[string]*list.Element)}
+}
+
+// get returns a fresh copy of the response cached for a url, if there's
+// one which hasn't expired. req is the request it's being used to answer.
+func (pc *pageCache) get(req *http.Request) (*http.Response, bool) {
+	if req.Method != "GET" {
+		return nil, false
+	}
+	pc.Lock()
+	defer pc.Unlock()
+	el, ok := pc.pages[req.URL.String()]
+	if !ok {
+		return nil, false
+	}
+	page := el.Value.(*cachedPage)
+	if time.Since(page.fetched) > pc.ttl {
+		pc.lru.Remove(el)
+		delete(pc.pages, page.url)
+		return nil, false
+	}
+	pc.lru.MoveToFront(el)
+
+	final := *req
+	final.URL = page.finalURL
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", page.status, http.StatusText(page.status)),
+		StatusCode:    page.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        page.header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(page.body)),
+		ContentLength: int64(len(page.body)),
+		Request:       &final,
+	}, true
+}
+
+// keep reads in the body of a response to req and caches it (if it's
+// worth keeping), returning the response with a body which can still be
+// read as normal.
+func (pc *pageCache) keep(req *http.Request, resp *http.Response) (*http.Response, error) {
+	if pc.size <= 0 || req.Method != "GET" || resp.StatusCode != http.StatusOK {
+		return resp, nil
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	page := &cachedPage{
+		url:      req.URL.String(),
+		fetched:  time.Now(),
+		status:   resp.StatusCode,
+		header:   resp.Header.Clone(),
+		body:     body,
+		finalURL: resp.Request.URL,
+	}
+	pc.Lock()
+	defer pc.Unlock()
+	if el, ok := pc.pages[page.url]; ok {
+		pc.lru.Remove(el)
+	}
+	pc.pages[page.url] = pc.lru.PushFront(page)
+	for pc.lru.Len() > pc.size {
+		oldest := pc.lru.Back()
+		pc.lru.Remove(oldest)
+		delete(pc.pages, oldest.Value.(*cachedPage).url)
+	}
+	return resp, nil
+}
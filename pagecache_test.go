@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPageCache(t *testing.T) {
+	defer func(old *pageCache) { pages = old }(pages)
+	pages = newPageCache(2, time.Minute)
+
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	hitsFor := func(path string) int {
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[path]
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/old" {
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+			return
+		}
+		fmt.Fprintf(w, "page %s", r.URL.Path)
+	}))
+	defer srv.Close()
+	get := func(path string) string {
+		resp, err := politeGet(httpClient, srv.URL+path)
+		if err != nil {
+			t.Error(err)
+			return ""
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	if got := get("/a"); got != "page /a" {
+		t.Fatalf("got %q", got)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := get("/a"); got != "page /a" {
+				t.Errorf("got %q from the cache", got)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := hitsFor("/a"); n != 1 {
+		t.Errorf("/a fetched %d times, want once", n)
+	}
+
+	// (where a page redirected to is remembered too)
+	scraper := &fakeScraper{"pagecache"}
+	for i := 0; i < 2; i++ {
+		html, final, err := fetchPage(scraper, srv.URL+"/old")
+		if err != nil || html != "page /new" || final != srv.URL+"/new" {
+			t.Errorf("fetch %d: got %q from %s (%v)", i+1, html, final, err)
+		}
+	}
+	if n := hitsFor("/old"); n != 1 {
+		t.Errorf("/old fetched %d times, want once", n)
+	}
+
+	// the least recently used page goes first
+	get("/b")
+	get("/c")
+	get("/a")
+	if n := hitsFor("/a"); n != 2 {
+		t.Errorf("/a fetched %d times, want twice", n)
+	}
+	// and once they've expired, pages are fetched again
+	pages.ttl = 0
+	time.Sleep(time.Millisecond)
+	get("/c")
+	if n := hitsFor("/c"); n != 2 {
+		t.Errorf("/c fetched %d times, want twice", n)
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+// A log of recent scraping problems for each source (errors, and releases
+// which came back with no content), for working out why a scraper has
+// broken. Served up as json at /api/errors.
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// number of problems remembered for each source (the oldest are dropped)
+const problemsPerSource = 50
+
+// scrapeProblem is a single problem scraping a source
+type scrapeProblem struct {
+	Source string    `json:"source"`
+	URL    string    `json:"url,omitempty"` // empty if it wasn't down to a particular page
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+}
+
+// problemLog keeps the most recent problems for each source, in a ring
+// buffer per source
+type problemLog struct {
+	sync.Mutex
+	rings map[string][]scrapeProblem
+	next  map[string]int // where the next problem goes in each ring
+}
+
+var scrapeProblems = newProblemLog()
+
+func newProblemLog() *problemLog {
+	return &problemLog{
+		rings: make(map[string][]scrapeProblem),
+		next:  make(map[string]int),
+	}
+}
+
+// add records a problem, pushing out the oldest one for the source if its
+// ring is full
+func (pl *problemLog) add(source, url, reason string) {
+	pl.Lock()
+	defer pl.Unlock()
+	p := scrapeProblem{Source: source, URL: url, Time: time.Now(), Reason: reason}
+	ring := pl.rings[source]
+	if len(ring) < problemsPerSource {
+		pl.rings[source] = append(ring, p)
+		return
+	}
+	ring[pl.next[source]] = p
+	pl.next[source] = (pl.next[source] + 1) % problemsPerSource
+}
+
+// recent returns the remembered problems for a source (or for every source,
+// if source is empty), most recent first
+func (pl *problemLog) recent(source string) []scrapeProblem {
+	pl.Lock()
+	defer pl.Unlock()
+	out := []scrapeProblem{}
+	for name, ring := range pl.rings {
+		if source == "" || name == source {
+			out = append(out, ring...)
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Time.After(out[j].Time) })
+	return out
+}
+
+// isEmpty returns true if a scraped press release has no content to speak
+// of (usually a sign the site's layout has changed under the scraper)
+func isEmpty(pr *PressRelease) bool {
+	if pr.Text != "" {
+		return strings.TrimSpace(pr.Text) == ""
+	}
+	return strings.TrimSpace(plainText(pr.Content)) == ""
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// emptyScraper scrapes every release to nothing but whitespace
+type emptyScraper struct{ fakeScraper }
+
+func (e *emptyScraper) Scrape(pr *PressRelease, s string) error {
+	pr.Content = "<p> </p>"
+	return nil
+}
+
+func TestEmptyContentProblem(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html></html>")
+	}))
+	defer srv.Close()
+	scrapeProblems = newProblemLog()
+	defer func() { scrapeProblems = newProblemLog() }()
+
+	pr := &PressRelease{Source: "problems-empty", Permalink: srv.URL + "/1"}
+	if ok := scrapeAll(context.Background(), &emptyScraper{fakeScraper{"problems-empty"}}, []*PressRelease{pr}, 1); !ok[0] {
+		t.Fatal("empty scrape failed")
+	}
+	scrapeProblems.add("problems-other", "", "fetching list: oops")
+
+	w := httptest.NewRecorder()
+	errorsHandler(w, httptest.NewRequest("GET", "/api/errors?source=problems-empty", nil))
+	var problems []scrapeProblem
+	if err := json.Unmarshal(w.Body.Bytes(), &problems); err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 {
+		t.Fatalf("got %d problems, want 1: %+v", len(problems), problems)
+	}
+	if p := problems[0]; p.Source != "problems-empty" || p.URL != pr.Permalink || p.Reason != "empty content" || p.Time.IsZero() {
+		t.Errorf("got %+v", p)
+	}
+}
+
+func TestProblemLog(t *testing.T) {
+	pl := newProblemLog()
+	for i := 0; i < problemsPerSource+10; i++ {
+		pl.add("a", "", fmt.Sprint(i))
+	}
+	pl.add("b", "", "b")
+
+	a := pl.recent("a")
+	if len(a) != problemsPerSource {
+		t.Fatalf("got %d problems, want %d", len(a), problemsPerSource)
+	}
+	// (newest first, with the oldest pushed out)
+	if first, last := a[0].Reason, a[len(a)-1].Reason; first != fmt.Sprint(problemsPerSource+9) || last != "10" {
+		t.Errorf("got problems %s to %s, want %d to 10", first, last, problemsPerSource+9)
+	}
+	if n := len(pl.recent("")); n != problemsPerSource+1 {
+		t.Errorf("got %d problems for every source, want %d", n, problemsPerSource+1)
+	}
+	if n := len(pl.recent("c")); n != 0 {
+		t.Errorf("got %d problems for an unknown source", n)
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	for _, test := range []struct {
+		pr   PressRelease
+		want bool
+	}{
+		{PressRelease{Content: "<p> </p>"}, true},
+		{PressRelease{Content: "<p>Hello</p>"}, false},
+		{PressRelease{Content: "<p>Hello</p>", Text: " \n"}, true},
+		{PressRelease{Text: "Hello"}, false},
+	} {
+		if got := isEmpty(&test.pr); got != test.want {
+			t.Errorf("%+v: got %v, want %v", test.pr, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// liveScrapers holds the set of scrapers currently being run, for the
+// handlers which list the sources. It's swapped out wholesale when the
+// -config file is reloaded (on SIGHUP), so the maps it hands out are never
+// changed.
+type liveScrapers struct {
+	sync.RWMutex
+	scrapers map[string]Scraper
+}
+
+func newLiveScrapers(scrapers map[string]Scraper) *liveScrapers {
+	return &liveScrapers{scrapers: scrapers}
+}
+
+// current returns the scrapers, by name (not to be modified)
+func (live *liveScrapers) current() map[string]Scraper {
+	live.RLock()
+	defer live.RUnlock()
+	return live.scrapers
+}
+
+// set swaps in a new set of scrapers
+func (live *liveScrapers) set(scrapers map[string]Scraper) {
+	live.Lock()
+	defer live.Unlock()
+	live.scrapers = scrapers
+}
+
+// names returns the names of the scrapers, sorted
+func (live *liveScrapers) names() []string {
+	var names []string
+	for name := range live.current() {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// gate wraps the handler for a single source, so it 404s if the source has
+// been dropped on a reload (http handlers can't be unregistered)
+func (live *liveScrapers) gate(name string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := live.current()[name]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// staleAfter returns how long each source can go without a successful
+// scrape before it's flagged as unhealthy (three of its poll intervals)
+func (live *liveScrapers) staleAfter() map[string]time.Duration {
+	stale := make(map[string]time.Duration)
+	for name, scraper := range live.current() {
+		stale[name] = 3 * scrapeInterval(scraper)
+	}
+	return stale
+}
+
+// scrapeRunner is a scraper being run periodically by scrapeLoop
+type scrapeRunner struct {
+	scraper Scraper
+	cancel  func()
+	done    chan struct{} // closed once it's stopped
+}
+
+// sameScraper returns true if a reloaded scraper is no different from the
+// one already running (builtin scrapers are recreated on each reload, and
+// config ones reread, so they can't just be compared as pointers)
+func sameScraper(a, b Scraper) bool {
+	return reflect.DeepEqual(a, b)
+}
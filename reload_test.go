@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/donovanhide/eventsource"
+)
+
+// Scrapers can be added and dropped while the loop is running.
+func TestScrapeLoopReload(t *testing.T) {
+	a := &tickScraper{fakeScraper: fakeScraper{"reload-a"}, interval: 20 * time.Millisecond}
+	b := &tickScraper{fakeScraper: fakeScraper{"reload-b"}, interval: time.Hour}
+	reloads := make(chan map[string]Scraper)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		scrapeLoop(ctx, map[string]Scraper{"reload-a": a}, NewMemStore(), eventsource.NewServer(), reloads)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if a.runCount() == 0 || b.runCount() != 0 {
+		t.Fatalf("got %d and %d runs before the reload", a.runCount(), b.runCount())
+	}
+	// (a newly added one runs straight away)
+	reloads <- map[string]Scraper{"reload-a": a, "reload-b": b}
+	time.Sleep(50 * time.Millisecond)
+	if b.runCount() != 1 {
+		t.Errorf("added scraper ran %d times, want once", b.runCount())
+	}
+	reloads <- map[string]Scraper{"reload-b": b}
+	time.Sleep(30 * time.Millisecond)
+	dropped := a.runCount()
+	time.Sleep(100 * time.Millisecond)
+	if a.runCount() != dropped {
+		t.Errorf("dropped scraper ran %d more times", a.runCount()-dropped)
+	}
+}
+
+func TestLoadScrapersReload(t *testing.T) {
+	const extra = `{"scrapers": [{"name": "extra", "url": "http://example.com/", "links": "a", "title": "h1", "content": ".body", "interval": %s}]}`
+	filename := writeConfig(t, fmt.Sprintf(extra, "60"))
+	old := *configFile
+	*configFile = filename
+	defer func() { *configFile = old }()
+
+	scrapers, err := loadScrapers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if scrapers["extra"] == nil || scrapers["tesco"] == nil {
+		t.Fatalf("got scrapers %v", scrapers)
+	}
+	again, err := loadScrapers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"extra", "tesco"} {
+		if !sameScraper(scrapers[name], again[name]) {
+			t.Errorf("%s changed on reloading the same config", name)
+		}
+	}
+
+	if err := ioutil.WriteFile(filename, []byte(fmt.Sprintf(extra, "120")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := loadScrapers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sameScraper(scrapers["extra"], changed["extra"]) {
+		t.Errorf("new interval not picked up")
+	}
+	live := newLiveScrapers(scrapers)
+	live.set(changed)
+	if got := live.staleAfter()["extra"]; got != 6*time.Minute {
+		t.Errorf("got stale after %s, want 6m", got)
+	}
+
+	// (a broken config is an error, so the old one can be kept)
+	if err := ioutil.WriteFile(filename, []byte(`{"scrapers": [{"name": "broken"}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadScrapers(); err == nil {
+		t.Errorf("no error for a broken config")
+	}
+}
+
+// Dropped sources' handlers 404.
+func TestLiveScrapersGate(t *testing.T) {
+	live := newLiveScrapers(map[string]Scraper{"tesco": &fakeScraper{"tesco"}})
+	h := live.gate("tesco", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, want := range []int{http.StatusOK, http.StatusNotFound} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/tesco", nil))
+		if w.Code != want {
+			t.Errorf("got %d, want %d", w.Code, want)
+		}
+		live.set(map[string]Scraper{"asda": &fakeScraper{"asda"}})
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// errDisallowed is returned when robots.txt forbids fetching a url
+var errDisallowed = errors.New("disallowed by robots.txt")
+
+// a single Allow or Disallow line from robots.txt
+type robotsRule struct {
+	allow   bool
+	pattern string
+	pat     *regexp.Regexp
+}
+
+// robotsRules holds the rules from a robots.txt which apply to us
+type robotsRules []robotsRule
+
+// allowed checks a path (plus query) against the rules.
+// The longest matching pattern wins, with Allow beating Disallow on a tie.
+func (rules robotsRules) allowed(path string) bool {
+	allow := true
+	longest := -1
+	for _, rule := range rules {
+		if !rule.pat.MatchString(path) {
+			continue
+		}
+		if len(rule.pattern) > longest || (len(rule.pattern) == longest && rule.allow) {
+			allow = rule.allow
+			longest = len(rule.pattern)
+		}
+	}
+	return allow
+}
+
+// robotsCache holds the parsed robots.txt rules for each host we've hit,
+// and is reset at the start of each scrape cycle
+var robotsCache = struct {
+	sync.Mutex
+	hosts map[string]robotsRules
+}{hosts: make(map[string]robotsRules)}
+
+// resetRobots forgets all the cached robots.txt files
+func resetRobots() {
+	robotsCache.Lock()
+	defer robotsCache.Unlock()
+	robotsCache.hosts = make(map[string]robotsRules)
+}
+
+// robotsAllowed checks if robots.txt on the host lets userAgent fetch pageURL.
+// A missing robots.txt means everything is allowed, but one the server
+// fails to serve (a 5xx) means nothing is, as per RFC 9309.
+func robotsAllowed(pageURL, userAgent string) (bool, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil {
+		return false, err
+	}
+	host := u.Scheme + "://" + u.Host
+
+	robotsCache.Lock()
+	rules, got := robotsCache.hosts[host]
+	robotsCache.Unlock()
+	if !got {
+		rules, err = fetchRobots(host, userAgent)
+		if err != nil {
+			return false, err
+		}
+		robotsCache.Lock()
+		robotsCache.hosts[host] = rules
+		robotsCache.Unlock()
+	}
+	return rules.allowed(u.RequestURI()), nil
+}
+
+// fetchRobots grabs robots.txt from a host and parses out the rules
+// which apply to userAgent
+func fetchRobots(host, userAgent string) (robotsRules, error) {
+	resp, err := politeGet(httpClient, host+"/robots.txt")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		// (the site's having trouble - best to keep off until the next
+		// cycle)
+		warnf("%s/robots.txt: %d %s, not fetching from there", host, resp.StatusCode, http.StatusText(resp.StatusCode))
+		return disallowAll, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		// no robots.txt (or it's borked) - treat as allow-all
+		return nil, nil
+	}
+	return parseRobots(resp.Body, userAgent), nil
+}
+
+// disallowAll is the rules for a robots.txt which can't be fetched
+var disallowAll = robotsRules{{allow: false, pattern: "/", pat: robotsPattern("/")}}
+
+// parseRobots extracts the rules which apply to userAgent from a robots.txt.
+// If there's a group naming us specifically, that's used. Otherwise it falls
+// back to the "*" group.
+func parseRobots(r io.Reader, userAgent string) robotsRules {
+	userAgent = strings.ToLower(userAgent)
+	var ours, wildcard robotsRules
+	gotOurs := false
+	// agents for the group currently being read
+	var agents []string
+	inRules := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		field := strings.ToLower(strings.TrimSpace(parts[0]))
+		value := strings.TrimSpace(parts[1])
+
+		switch field {
+		case "user-agent":
+			if inRules {
+				// starting a new group
+				agents = nil
+				inRules = false
+			}
+			agent := strings.ToLower(value)
+			agents = append(agents, agent)
+			if agent != "" && agent != "*" && strings.Contains(userAgent, agent) {
+				// (even if the group turns out to have no rules, or
+				// only an empty Disallow, it still applies instead of
+				// the "*" one)
+				gotOurs = true
+			}
+		case "allow", "disallow":
+			inRules = true
+			if value == "" {
+				// empty Disallow means allow everything
+				continue
+			}
+			rule := robotsRule{allow: field == "allow", pattern: value, pat: robotsPattern(value)}
+			for _, agent := range agents {
+				if agent == "*" {
+					wildcard = append(wildcard, rule)
+				} else if agent != "" && strings.Contains(userAgent, agent) {
+					ours = append(ours, rule)
+				}
+			}
+		}
+	}
+	if gotOurs {
+		return ours
+	}
+	return wildcard
+}
+
+// robotsPattern compiles a robots.txt path pattern (which can use '*'
+// wildcards and a '$' end anchor) into a regexp
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	expr := "^" + strings.Replace(regexp.QuoteMeta(pattern), `\*`, `.*`, -1)
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
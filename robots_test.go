@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseRobots(t *testing.T) {
+	tests := []struct {
+		name    string
+		robots  string
+		allowed map[string]bool
+	}{
+		{
+			"our group",
+			"User-agent: *\nDisallow: /\n\nUser-agent: ukpr\nDisallow: /private\nAllow: /private/ok$\n",
+			map[string]bool{"/news": true, "/private/x": false, "/private/ok": true, "/private/ok2": false},
+		},
+		{
+			"wildcard group",
+			"User-agent: googlebot\nDisallow: /\n\nUser-agent: *\nDisallow: /files/*.pdf\n",
+			map[string]bool{"/": true, "/files/a/b.pdf": false, "/files/a.html": true},
+		},
+		{
+			// an empty Disallow lets us have everything, whatever "*" says
+			"our group allows all",
+			"User-agent: *\nDisallow: /\n\nUser-agent: ukpr\nDisallow:\n",
+			map[string]bool{"/": true, "/news": true},
+		},
+		{
+			// (user-agent lines in a row all share the rules after)
+			"shared group",
+			"User-agent: ukpr\n\nUser-agent: *\nDisallow: /\n",
+			map[string]bool{"/news": false},
+		},
+		{
+			"longest match wins",
+			"User-agent: *\nDisallow: /news\nAllow: /news/press\n",
+			map[string]bool{"/news/sport": false, "/news/press/1": true},
+		},
+	}
+	for _, test := range tests {
+		rules := parseRobots(strings.NewReader(test.robots), "ukpr-bot/1.0")
+		for path, want := range test.allowed {
+			if got := rules.allowed(path); got != want {
+				t.Errorf("%s: %s allowed = %v, want %v", test.name, path, got, want)
+			}
+		}
+	}
+}
+
+func TestRobotsAllowed(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		robots  string
+		allowed bool
+	}{
+		{"allow", http.StatusOK, "User-agent: *\nDisallow: /private\n", true},
+		{"disallow", http.StatusOK, "User-agent: *\nDisallow: /news\n", false},
+		{"missing", http.StatusNotFound, "", true},
+		{"server error", http.StatusInternalServerError, "", false},
+	}
+	for _, test := range tests {
+		fetches := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				fetches++
+				w.WriteHeader(test.status)
+				fmt.Fprint(w, test.robots)
+			}
+		}))
+		for i := 0; i < 2; i++ {
+			allowed, err := robotsAllowed(srv.URL+"/news/1", userAgent)
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			}
+			if allowed != test.allowed {
+				t.Errorf("%s: got allowed %v, want %v", test.name, allowed, test.allowed)
+			}
+		}
+		srv.Close()
+		if fetches != 1 {
+			t.Errorf("%s: robots.txt fetched %d times, want once (then cached)", test.name, fetches)
+		}
+	}
+}
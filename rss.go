@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/xml"
+	"html"
+	"net/http"
+	"time"
+)
+
+// number of releases included in an rss feed
+const rssItemCount = 50
+
+// press releases with more content than this (in bytes) just get their
+// excerpt as the description in the feeds, to keep them a sensible size
+const rssMaxContent = 10000
+
+// bare-bones RSS 2.0 structure, just enough for encoding/xml
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Guid        string   `xml:"guid"`
+	PubDate     string   `xml:"pubDate"`
+	Description rssCDATA `xml:"description"`
+}
+
+// rssCDATA wraps html content up in a CDATA section
+type rssCDATA struct {
+	Text string `xml:",cdata"`
+}
+
+// rssHandler serves up the most recent press releases for a source
+// as an RSS 2.0 feed
+func rssHandler(store Store, source string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		opts := QueryOptions{Source: source, Limit: rssItemCount}
+		if source == allChannel {
+			opts.Source = ""
+		}
+		pressReleases, err := store.Query(opts)
+		if err != nil {
+			errorf("querying store: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		feed := rssFeed{
+			Version: "2.0",
+			Channel: rssChannel{
+				Title:       source + " press releases",
+				Link:        "http://" + r.Host + "/" + source + "/",
+				Description: "Latest press releases from " + source,
+			},
+		}
+		for _, pr := range pressReleases {
+			desc := pr.Content
+			if len(desc) > rssMaxContent && pr.Excerpt != "" {
+				desc = "<p>" + html.EscapeString(pr.Excerpt) + "</p>"
+			}
+			feed.Channel.Items = append(feed.Channel.Items, rssItem{
+				Title:       pr.Title,
+				Link:        pr.Permalink,
+				Guid:        pr.Permalink,
+				PubDate:     pr.PubDate.UTC().Format(time.RFC1123Z),
+				Description: rssCDATA{desc},
+			})
+		}
+
+		out, err := xml.MarshalIndent(feed, "", "  ")
+		if err != nil {
+			errorf("encoding rss: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		w.Write(out)
+	}
+}
+
+// OPML 1.0 subscription list, for feed readers
+type opmlDoc struct {
+	XMLName xml.Name      `xml:"opml"`
+	Version string        `xml:"version,attr"`
+	Title   string        `xml:"head>title"`
+	Outline []opmlOutline `xml:"body>outline"`
+}
+
+type opmlOutline struct {
+	Type    string `xml:"type,attr"`
+	Text    string `xml:"text,attr"`
+	Title   string `xml:"title,attr"`
+	XMLURL  string `xml:"xmlUrl,attr"`
+	HTMLURL string `xml:"htmlUrl,attr,omitempty"`
+}
+
+// opmlHandler serves up an OPML list of the rss feeds, one per source
+// (sorted by name), so they can all be subscribed to in one go.
+func opmlHandler(live *liveScrapers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scrapers := live.current()
+		doc := opmlDoc{Version: "1.0", Title: "UK press releases"}
+		for _, name := range live.names() {
+			meta := scraperMeta(scrapers[name])
+			doc.Outline = append(doc.Outline, opmlOutline{
+				Type:    "rss",
+				Text:    meta.DisplayName,
+				Title:   meta.DisplayName,
+				XMLURL:  "http://" + r.Host + "/" + name + "/rss",
+				HTMLURL: meta.BaseURL,
+			})
+		}
+
+		out, err := xml.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			errorf("encoding opml: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+		w.Write([]byte(xml.Header))
+		w.Write(out)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/xml"
+	rss "github.com/jteeuwen/go-pkg-rss"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRSSHandler(t *testing.T) {
+	store := NewMemStore()
+	published := time.Date(2014, 3, 12, 9, 30, 0, 0, time.FixedZone("BST", 3600))
+	for _, pr := range []*PressRelease{
+		// (the content has to survive being wrapped up in CDATA)
+		{Title: "Fish & chips", Source: "tesco", Permalink: "http://example.com/1", PubDate: published, Content: "<p>x]]>y</p>"},
+		{Title: "Second", Source: "tesco", Permalink: "http://example.com/2", PubDate: published, Content: "<p>z</p>"},
+		{Title: "Elsewhere", Source: "asda", Permalink: "http://example.com/3", PubDate: published, Content: "<p>a</p>"},
+		{Title: "Long", Source: "asda", Permalink: "http://example.com/4", PubDate: published, Content: "<p>" + strings.Repeat("x", rssMaxContent) + "</p>", Excerpt: "Short & sweet"},
+	} {
+		if _, err := store.Stash(pr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// (read back with the same rss package the scrapers use)
+	items := func(source string) []*rss.Item {
+		w := httptest.NewRecorder()
+		rssHandler(store, source)(w, httptest.NewRequest("GET", "/"+source+"/rss", nil))
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/rss+xml") {
+			t.Errorf("%s: got content type %q", source, ct)
+		}
+		feed := rss.New(0, false, nil, nil)
+		if err := feed.FetchBytes("http://ukpr.example.com/"+source+"/rss", w.Body.Bytes(), nil); err != nil {
+			t.Fatalf("%s: %s in %s", source, err, w.Body.String())
+		}
+		if len(feed.Channels) != 1 {
+			t.Fatalf("%s: got %d channels, want 1", source, len(feed.Channels))
+		}
+		return feed.Channels[0].Items
+	}
+
+	got := items("tesco")
+	if len(got) != 2 {
+		t.Fatalf("got %d tesco items, want 2", len(got))
+	}
+	first := got[1]
+	if first.Title != "Fish & chips" || len(first.Links) == 0 || first.Links[0].Href != "http://example.com/1" || first.Description != "<p>x]]>y</p>" {
+		t.Errorf("got item %+v", first)
+	}
+	if first.PubDate != "Wed, 12 Mar 2014 08:30:00 +0000" {
+		t.Errorf("got pubDate %q", first.PubDate)
+	}
+
+	got = items(allChannel)
+	if len(got) != 4 {
+		t.Fatalf("got %d items on %s, want 4", len(got), allChannel)
+	}
+	// (big ones are cut down to their excerpt)
+	if got[0].Description != "<p>Short &amp; sweet</p>" {
+		t.Errorf("got description %.50q for a long release", got[0].Description)
+	}
+}
+
+func TestOPMLHandler(t *testing.T) {
+	live := newLiveScrapers(map[string]Scraper{
+		"opml-b": &metaScraper{fakeScraper{"opml-b"}, ScraperMeta{DisplayName: "Source B", BaseURL: "http://b.example.com/"}},
+		"opml-a": &fakeScraper{"opml-a"},
+	})
+	w := httptest.NewRecorder()
+	opmlHandler(live)(w, httptest.NewRequest("GET", "http://ukpr.example.com/opml", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/x-opml; charset=utf-8" {
+		t.Errorf("got content type %q", ct)
+	}
+	var doc struct {
+		Outlines []struct {
+			Type    string `xml:"type,attr"`
+			Text    string `xml:"text,attr"`
+			XMLURL  string `xml:"xmlUrl,attr"`
+			HTMLURL string `xml:"htmlUrl,attr"`
+		} `xml:"body>outline"`
+	}
+	if err := xml.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if len(doc.Outlines) != 2 {
+		t.Fatalf("got %d outlines, want 2", len(doc.Outlines))
+	}
+	a, b := doc.Outlines[0], doc.Outlines[1]
+	if a.Type != "rss" || a.Text != "opml-a" || a.XMLURL != "http://ukpr.example.com/opml-a/rss" {
+		t.Errorf("got %+v", a)
+	}
+	if b.Text != "Source B" || b.XMLURL != "http://ukpr.example.com/opml-b/rss" || b.HTMLURL != "http://b.example.com/" {
+		t.Errorf("got %+v", b)
+	}
+}
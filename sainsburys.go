@@ -16,6 +16,10 @@ func (scraper *SainsburysScraper) Name() string {
 	return "sainsburys"
 }
 
+func (scraper *SainsburysScraper) Meta() ScraperMeta {
+	return ScraperMeta{DisplayName: "Sainsbury's", BaseURL: "http://www.j-sainsbury.co.uk/"}
+}
+
 // fetches a list of latest press releases from Sainsburys
 func (scraper *SainsburysScraper) FetchList() ([]*PressRelease, error) {
 	url := "http://www.j-sainsbury.co.uk/media/latest-stories/"
@@ -29,5 +33,5 @@ func (scraper *SainsburysScraper) Scrape(pr *PressRelease, raw_html string) erro
 	// TODO: kill everything after: "Notes to Editors"
 	cruft := ""
 	pubDate := "#page_container .nm_right .list_plain, #page_container .blog_author"
-	return GenericScrape(scraper.Name(), pr, raw_html, title, content, cruft, pubDate)
+	return GenericScrape(scraper.Name(), pr, raw_html, []string{title}, []string{content}, cruft, []string{pubDate})
 }
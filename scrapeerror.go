@@ -0,0 +1,102 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// The kinds of scraping failure, for telling them apart with errors.Is.
+// The errors returned by scrape, GenericFetchList and GenericScrape (and
+// ScrapeSpec.Scrape) are ScrapeErrors of one of these kinds.
+var (
+	// the page couldn't be fetched (network trouble, an error status,
+	// robots.txt says no, too big...)
+	ErrFetch = errors.New("fetch failed")
+	// a selector the scraper needs (eg the title or content) didn't match
+	// anything on the page
+	ErrSelectorNotFound = errors.New("selector not found")
+	// the page was fetched, but couldn't be made sense of
+	ErrParse = errors.New("parse failed")
+	// fetching and scraping it took too long (see -scrape-timeout), or
+	// the run it was part of ran out of time
+	ErrTimeout = errors.New("timed out")
+)
+
+// ScrapeError is a failure to fetch or scrape a page, of one of the kinds
+// above. errors.Is matches it against its kind as well as its cause.
+type ScrapeError struct {
+	Kind error  // ErrFetch, ErrSelectorNotFound, ErrParse or ErrTimeout
+	URL  string // the page which failed
+	Err  error  // what went wrong
+}
+
+// (just the cause - it usually says enough, and often has the url in)
+func (e *ScrapeError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ScrapeError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ScrapeError) Is(target error) bool {
+	return target == e.Kind
+}
+
+// scrapeError wraps err up as a ScrapeError of the given kind, unless it's
+// already a ScrapeError (or nil)
+func scrapeError(kind error, url string, err error) error {
+	var se *ScrapeError
+	if err == nil || errors.As(err, &se) {
+		return err
+	}
+	return &ScrapeError{Kind: kind, URL: url, Err: err}
+}
+
+// retryable returns true if a failed fetch or scrape is worth trying again
+// next time round. A page the scraper can't make sense of will just fail
+// the same way until the scraper (or the page) is fixed, but network
+// trouble, an unhappy server or anything else might well clear up.
+func retryable(err error) bool {
+	return !errors.Is(err, ErrSelectorNotFound) && !errors.Is(err, ErrParse)
+}
+
+// how long a press release which can't be scraped is left alone for (see
+// givenUp), before having another go in case the page has been fixed
+const giveUpFor = 24 * time.Hour
+
+// givenUp keeps track of the press releases which have failed to scrape in
+// a way which isn't retryable, so they're not fetched again every time
+// round (and don't keep taking up a share of -max-per-cycle).
+var givenUp = struct {
+	sync.Mutex
+	until map[string]time.Time // keyed by source and permalink
+}{until: make(map[string]time.Time)}
+
+// giveUp leaves a press release from source alone for giveUpFor
+func giveUp(source string, pr *PressRelease) {
+	givenUp.Lock()
+	defer givenUp.Unlock()
+	givenUp.until[source+" "+pr.Permalink] = time.Now().Add(giveUpFor)
+}
+
+// skipGivenUp returns the press releases from source which haven't been
+// given up on
+func skipGivenUp(source string, prs []*PressRelease) []*PressRelease {
+	givenUp.Lock()
+	defer givenUp.Unlock()
+	now := time.Now()
+	out := make([]*PressRelease, 0, len(prs))
+	for _, pr := range prs {
+		key := source + " " + pr.Permalink
+		if t, ok := givenUp.until[key]; ok {
+			if now.Before(t) {
+				continue
+			}
+			delete(givenUp.until, key)
+		}
+		out = append(out, pr)
+	}
+	return out
+}
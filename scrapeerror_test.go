@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// specScraper is a fakeScraper which scrapes with a ScrapeSpec
+type specScraper struct {
+	fakeScraper
+	spec ScrapeSpec
+}
+
+func (s *specScraper) Scrape(pr *PressRelease, rawHTML string) error {
+	return s.spec.Scrape(s.name, pr, rawHTML)
+}
+
+var errBadDate = errors.New("bad date")
+
+// badDateScraper is a fakeScraper which fails in a way of its own
+type badDateScraper struct{ fakeScraper }
+
+func (b *badDateScraper) Scrape(pr *PressRelease, rawHTML string) error { return errBadDate }
+
+// errorServer serves a missing page at /gone, one with no title at
+// /notitle, and a good one anywhere else
+func errorServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			http.NotFound(w, r)
+		case "/notitle":
+			fmt.Fprint(w, `<html><body><div class="body">words</div></body></html>`)
+		default:
+			fmt.Fprint(w, pressPage("good"))
+		}
+	}))
+}
+
+func TestScrapeErrorKinds(t *testing.T) {
+	srv := errorServer()
+	defer srv.Close()
+	spec := &specScraper{fakeScraper{"errorkinds"}, ScrapeSpec{Title: []string{"h1"}, Content: []string{".body"}}}
+
+	for _, test := range []struct {
+		name      string
+		scraper   Scraper
+		path      string
+		kind      error
+		retryable bool
+	}{
+		{"missing page", spec, "/gone", ErrFetch, true},
+		{"no title", spec, "/notitle", ErrSelectorNotFound, false},
+		{"bad date", &badDateScraper{fakeScraper{"errorkinds"}}, "/ok", ErrParse, false},
+	} {
+		err := scrape(context.Background(), test.scraper, &PressRelease{Permalink: srv.URL + test.path})
+		var se *ScrapeError
+		if !errors.As(err, &se) || !errors.Is(err, test.kind) {
+			t.Errorf("%s: got %#v, want a %v ScrapeError", test.name, err, test.kind)
+			continue
+		}
+		if se.URL != srv.URL+test.path {
+			t.Errorf("%s: got url %s", test.name, se.URL)
+		}
+		if retryable(err) != test.retryable {
+			t.Errorf("%s: got retryable %v, want %v", test.name, retryable(err), test.retryable)
+		}
+	}
+
+	// (the causes are still there underneath)
+	err := scrape(context.Background(), spec, &PressRelease{Permalink: srv.URL + "/gone"})
+	var status *statusError
+	if !errors.As(err, &status) || status.code != http.StatusNotFound {
+		t.Errorf("got %v, want a 404 status error underneath", err)
+	}
+	err = scrape(context.Background(), &badDateScraper{fakeScraper{"errorkinds"}}, &PressRelease{Permalink: srv.URL + "/ok"})
+	if !errors.Is(err, errBadDate) {
+		t.Errorf("got %v, want %v underneath", err, errBadDate)
+	}
+
+	if _, err := GenericFetchList("errorkinds", "http://127.0.0.1:1/", "a"); !errors.Is(err, ErrFetch) {
+		t.Errorf("GenericFetchList: got %v, want %v", err, ErrFetch)
+	}
+	err = GenericScrape("errorkinds", &PressRelease{}, `<p>x</p>`, []string{"h1"}, []string{".body"}, "", nil)
+	if !errors.Is(err, ErrSelectorNotFound) || err.Error() != "no title (h1)" {
+		t.Errorf("GenericScrape: got %v, want %v", err, ErrSelectorNotFound)
+	}
+}
+
+// A release which can't be scraped is left alone for a while, but one
+// which just couldn't be fetched is tried again next time.
+func TestGivenUp(t *testing.T) {
+	srv := errorServer()
+	defer srv.Close()
+	spec := &specScraper{fakeScraper{"givenup"}, ScrapeSpec{Title: []string{"h1"}, Content: []string{".body"}}}
+	prs := []*PressRelease{{Permalink: srv.URL + "/gone"}, {Permalink: srv.URL + "/notitle"}, {Permalink: srv.URL + "/ok"}}
+	ok := scrapeAll(context.Background(), spec, prs, 1)
+	if fmt.Sprint(ok) != "[false false true]" {
+		t.Errorf("got ok %v", ok)
+	}
+
+	left := skipGivenUp("givenup", prs)
+	if len(left) != 2 || left[0] != prs[0] || left[1] != prs[2] {
+		t.Errorf("got %d left after giving up", len(left))
+	}
+	// (only for that source)
+	if left := skipGivenUp("other", prs); len(left) != 3 {
+		t.Errorf("got %d left for another source, want 3", len(left))
+	}
+}
@@ -4,12 +4,20 @@ import (
 	"bytes"
 	"code.google.com/p/cascadia"
 	"code.google.com/p/go.net/html"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
 	"github.com/bcampbell/fuzzytime"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 )
 
 // getAttr retrieved the value of an attribute on a node.
@@ -47,6 +55,50 @@ func contains(container *html.Node, n *html.Node) bool {
 	return false
 }
 
+// selectors holds the css selectors compiled by compileSelector, by their
+// source (nil for ones which don't compile)
+var selectors = struct {
+	sync.Mutex
+	compiled map[string]cascadia.Selector
+}{compiled: make(map[string]cascadia.Selector)}
+
+// compileSelector compiles a css selector once, rather than every time
+// it's used. An invalid one is logged (the first time) and returns nil.
+func compileSelector(selector string) cascadia.Selector {
+	selectors.Lock()
+	defer selectors.Unlock()
+	if sel, ok := selectors.compiled[selector]; ok {
+		return sel
+	}
+	sel, err := cascadia.Compile(selector)
+	if err != nil {
+		errorf("bad selector '%s': %s", selector, err)
+		sel = nil
+	}
+	selectors.compiled[selector] = sel
+	return sel
+}
+
+// querySelector returns the first node under root which matches a css
+// selector, or nil if there's no match (or the selector is invalid).
+func querySelector(root *html.Node, selector string) *html.Node {
+	sel := compileSelector(selector)
+	if sel == nil {
+		return nil
+	}
+	return sel.MatchFirst(root)
+}
+
+// querySelectorAll returns all the nodes under root which match a css
+// selector, in document order (none if the selector is invalid).
+func querySelectorAll(root *html.Node, selector string) []*html.Node {
+	sel := compileSelector(selector)
+	if sel == nil {
+		return nil
+	}
+	return sel.MatchAll(root)
+}
+
 // compressSpace reduces all whitespace sequences (space, tabs, newlines etc) in a string to a single space.
 // Leading/trailing space is trimmed.
 // Has the effect of converting multiline strings to one line.
@@ -56,81 +108,1017 @@ func compressSpace(s string) string {
 	return s
 }
 
+// normaliseSpace is like compressSpace, but also counts non-ascii spaces
+// (eg &nbsp;) and zero-width spaces as whitespace. It's used for the
+// titles and dates picked out of pages, where they turn up a lot.
+// (compressSpace is left alone, as the content hashes rely on it)
+func normaliseSpace(s string) string {
+	return strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || r == '\u200b' || r == '\ufeff'
+	}), " ")
+}
+
+// cleanTitle tidies up a title scraped from a page: whitespace is
+// normalised, and the site's name tacked on the end (if suffix matches
+// it) is stripped off. If stripping would leave nothing, it's not done.
+func cleanTitle(title string, suffix *regexp.Regexp) string {
+	title = normaliseSpace(title)
+	if suffix != nil {
+		if stripped := normaliseSpace(suffix.ReplaceAllString(title, "")); stripped != "" {
+			title = stripped
+		}
+	}
+	return title
+}
+
+// plainText strips the tags out of a fragment of html, leaving the text
+// all on one line.
+func plainText(fragment string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), nil)
+	if err != nil {
+		return ""
+	}
+	txt := ""
+	for _, n := range nodes {
+		txt += getTextContent(n) + " "
+	}
+	return compressSpace(txt)
+}
+
+// elements which start a new paragraph, as far as htmlText is concerned
+var blockElements = map[string]bool{
+	"p": true, "div": true, "h1": true, "h2": true, "h3": true, "h4": true,
+	"h5": true, "h6": true, "ul": true, "ol": true, "li": true, "table": true,
+	"tr": true, "blockquote": true, "pre": true, "hr": true, "section": true,
+	"article": true, "header": true, "footer": true, "dl": true, "dt": true,
+	"dd": true,
+}
+
+var (
+	spaceRunPat   = regexp.MustCompile(`[ \t\r\f\v\x{a0}]+`)
+	newlineRunPat = regexp.MustCompile(`\n{3,}`)
+)
+
+// htmlText returns the text of a node as plaintext, with the whitespace
+// tidied up but paragraphs kept apart (separated by blank lines).
+func htmlText(n *html.Node) string {
+	var buf bytes.Buffer
+	writeText(&buf, n)
+	lines := strings.Split(buf.String(), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSpace(spaceRunPat.ReplaceAllString(line, " "))
+	}
+	txt := newlineRunPat.ReplaceAllString(strings.Join(lines, "\n"), "\n\n")
+	return strings.TrimSpace(txt)
+}
+
+// writeText does the work for htmlText
+func writeText(buf *bytes.Buffer, n *html.Node) {
+	switch n.Type {
+	case html.TextNode:
+		// newlines in the html source are just whitespace
+		buf.WriteString(strings.Replace(n.Data, "\n", " ", -1))
+		return
+	case html.ElementNode:
+		if n.Data == "br" {
+			buf.WriteString("\n")
+			return
+		}
+		if n.Data == "script" || n.Data == "style" {
+			return
+		}
+	}
+	block := n.Type == html.ElementNode && blockElements[n.Data]
+	if block {
+		buf.WriteString("\n\n")
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		writeText(buf, child)
+	}
+	if block {
+		buf.WriteString("\n\n")
+	}
+}
+
+// fragmentText is htmlText for a fragment of html in a string
+func fragmentText(fragment string) string {
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), nil)
+	if err != nil {
+		return ""
+	}
+	parts := make([]string, 0, len(nodes))
+	for _, n := range nodes {
+		if txt := htmlText(n); txt != "" {
+			parts = append(parts, txt)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// contentHash returns a sha256 (hex-encoded) of the title and content of a
+// press release, with whitespace normalised so trivial reformatting doesn't
+// change the hash.
+func contentHash(pr *PressRelease) string {
+	h := sha256.New()
+	io.WriteString(h, compressSpace(pr.Title))
+	io.WriteString(h, "\n")
+	io.WriteString(h, compressSpace(pr.Content))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// date layouts seen on the various press centres, tried in order by
+// parsePubDate
+var pubDateLayouts = []string{
+	"2 January 2006 15:04",
+	"2 January 2006 3:04pm",
+	"2 January 2006",
+	"2 Jan 2006 15:04",
+	"2 Jan 2006",
+	"Monday 2 January 2006",
+	"Monday, 2 January 2006",
+	"Mon 2 Jan 2006",
+	"January 2, 2006",
+	"Jan 2, 2006",
+	"January 2 2006",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006-01-02",
+	"02/01/2006 15:04",
+	"2/1/2006",
+	"02/01/2006",
+	"02.01.2006",
+	"02-01-2006",
+	"2/1/06",
+	"02/01/06",
+}
+
+// ordinal suffixes on day numbers ("12th March 2014")
+var ordinalPat = regexp.MustCompile(`\b(\d{1,2})(st|nd|rd|th)\b`)
+
+// ukTime is the timezone for dates and times which don't say (GMT or BST,
+// depending on the time of year). Falls back to UTC if the timezone
+// database isn't available.
+var ukTime = loadUKTime()
+
+func loadUKTime() *time.Location {
+	loc, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		warnf("can't load Europe/London timezone, taking bare times as UTC: %s", err)
+		return time.UTC
+	}
+	return loc
+}
+
+// parsePubDate picks a publication date out of some text scraped from a page.
+// The text only needs to contain a date somewhere - it doesn't matter if
+// there's other crap in there too (eg "Posted by Bob on 12 March 2014").
+// Falls back to fuzzytime if none of pubDateLayouts match.
+// Times without a timezone are taken to be UK time (see ukTime). The
+// result is always in UTC.
+func parsePubDate(raw string) (time.Time, error) {
+	raw = normaliseSpace(raw)
+	txt := ordinalPat.ReplaceAllString(raw, "$1")
+
+	// try runs of words, longest first so times get picked up along with
+	// the dates
+	words := strings.Split(txt, " ")
+	for n := 6; n > 0; n-- {
+		for i := 0; i+n <= len(words); i++ {
+			candidate := strings.Trim(strings.Join(words[i:i+n], " "), ",.;:()|-")
+			for _, layout := range pubDateLayouts {
+				t, err := time.ParseInLocation(layout, candidate, ukTime)
+				if err == nil {
+					return t.UTC(), nil
+				}
+			}
+		}
+	}
+
+	t, err := fuzzytime.Parse(raw)
+	return t.UTC(), err
+}
+
+// elements which scrubHTML removes entirely
+var dodgyElements = map[string]bool{
+	"script": true,
+	"style":  true,
+	"iframe": true,
+}
+
+// scrubHTML cleans up extracted content, in place.
+// style, id, class and on* event-handler attributes are stripped, script,
+// style and iframe elements (and comments) are removed outright, and spans
+// left with no attributes are unwrapped.
+// Returns n, for convenience.
+func scrubHTML(n *html.Node) *html.Node {
+	if n.Type == html.ElementNode {
+		var attrs []html.Attribute
+		for _, a := range n.Attr {
+			key := strings.ToLower(a.Key)
+			if key == "style" || key == "id" || key == "class" || strings.HasPrefix(key, "on") {
+				continue
+			}
+			attrs = append(attrs, a)
+		}
+		n.Attr = attrs
+	}
+
+	var next *html.Node
+	for child := n.FirstChild; child != nil; child = next {
+		next = child.NextSibling
+		if child.Type == html.CommentNode || (child.Type == html.ElementNode && dodgyElements[child.Data]) {
+			n.RemoveChild(child)
+			continue
+		}
+		scrubHTML(child)
+		if child.Type == html.ElementNode && child.Data == "span" && len(child.Attr) == 0 {
+			// unwrap - hoist the children up in place of the span
+			for gc := child.FirstChild; gc != nil; gc = child.FirstChild {
+				child.RemoveChild(gc)
+				n.InsertBefore(gc, child)
+			}
+			n.RemoveChild(child)
+		}
+	}
+	return n
+}
+
 // GenericFetchList extracts links from a given page.
+// Each link is only returned once, in the order they first appear.
+// If the page hasn't changed since the last time it was fetched, an empty
+// list is returned. Failures are ScrapeErrors.
 func GenericFetchList(scraperName, pageUrl, linkSelector string) ([]*PressRelease, error) {
-	page, err := url.Parse(pageUrl)
+	return fetchLinks(scraperName, pageUrl, linkSelector, true, OutageMarkers{}, nil)
+}
+
+// GenericFetchListSince is GenericFetchList for index pages in date order
+// (newest first), with a date by each link. It stops collecting links at
+// the first one dated before since (see watermark), so the ones which must
+// already be in the store aren't looked at again. A zero since gets all
+// of them.
+func GenericFetchListSince(scraperName, pageUrl, linkSelector, dateSelector string, since time.Time) ([]*PressRelease, error) {
+	return fetchLinks(scraperName, pageUrl, linkSelector, true, OutageMarkers{}, newWatermark(dateSelector, since))
+}
+
+// watermark tells fetchLinks to stop at the first link on the page which
+// is dated before since. A link's date is the first match for dateSelector
+// in the closest element around the link which has one (eg the <li> it's
+// in). Links with no date, or one which can't be parsed, don't stop it.
+// Index pages often only give the day, so since is taken back to the start
+// of its day - releases from the same day are still listed (and culled as
+// usual by the store).
+type watermark struct {
+	dateSelector string
+	since        time.Time
+	reached      bool // set once fetchLinks has stopped at it
+}
+
+// newWatermark returns nil (ie no watermark) if since is zero
+func newWatermark(dateSelector string, since time.Time) *watermark {
+	if since.IsZero() || dateSelector == "" {
+		return nil
+	}
+	t := since.In(ukTime)
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, ukTime)
+	return &watermark{dateSelector: dateSelector, since: day}
+}
+
+// passed returns true if a link is dated before the watermark
+func (wm *watermark) passed(a *html.Node) bool {
+	for n := a; n != nil; n = n.Parent {
+		dates := querySelectorAll(n, wm.dateSelector)
+		if len(dates) == 0 {
+			continue
+		}
+		t, err := parsePubDate(getTextContent(dates[0]))
+		return err == nil && t.Before(wm.since)
+	}
+	return false
+}
+
+// errProbableOutage is returned for an index page with no links on it
+// which is showing an error or "no results" state (see OutageMarkers),
+// rather than just having nothing on it.
+var errProbableOutage = errors.New("index page is showing an error or \"no results\" (probable outage)")
+
+// OutageMarkers describe how to recognise a site's error or "no results"
+// index page, so a site having problems isn't mistaken for a quiet day.
+// Either or both can be set.
+type OutageMarkers struct {
+	Selector string // elements which only turn up on such pages, eg ".error-banner"
+	Text     string // a regexp matched against the page's text, eg "(?i)no results found"
+}
+
+// present returns true if any of the markers are on the page
+func (markers *OutageMarkers) present(root *html.Node) bool {
+	if markers.Selector != "" && querySelector(root, markers.Selector) != nil {
+		return true
+	}
+	if markers.Text != "" {
+		body := querySelector(root, "body")
+		if body == nil {
+			body = root
+		}
+		pat, err := compilePattern(markers.Text)
+		if err != nil {
+			errorf("bad outage text '%s': %s", markers.Text, err)
+			return false
+		}
+		return pat.MatchString(normaliseSpace(getTextContent(body)))
+	}
+	return false
+}
+
+// GenericFetchListChecked is GenericFetchList for sites with a
+// recognisable error or "no results" page. If none of the links are found
+// and the page has any of the markers, errProbableOutage is returned
+// instead of an empty list.
+func GenericFetchListChecked(scraperName, pageUrl, linkSelector string, markers OutageMarkers) ([]*PressRelease, error) {
+	return fetchLinks(scraperName, pageUrl, linkSelector, true, markers, nil)
+}
+
+// fetchLinks does the work for GenericFetchList. If conditional is set, a
+// conditional GET is used to skip pages we've already seen (unless the
+// source's last run wasn't clean). wm can be nil.
+func fetchLinks(scraperName, pageUrl, linkSelector string, conditional bool, markers OutageMarkers, wm *watermark) ([]*PressRelease, error) {
+	_, err := url.Parse(pageUrl)
 	if err != nil {
-		return nil, err // TODO: wrap up as ScrapeError?
+		return nil, scrapeError(ErrFetch, pageUrl, err)
 	}
 
-	linkSel := cascadia.MustCompile(linkSelector)
-	resp, err := http.Get(pageUrl)
+	allowed, err := robotsAllowed(pageUrl, userAgent)
 	if err != nil {
-		return nil, err
+		return nil, scrapeError(ErrFetch, pageUrl, err)
+	}
+	if !allowed {
+		return nil, scrapeError(ErrFetch, pageUrl, errDisallowed)
+	}
+
+	var resp *http.Response
+	if conditional && !cleanRuns.wasClean(scraperName) {
+		// the last run left releases for this one to pick up (see
+		// cleanRuns), which a 304 would hide
+		forgetValidators(pageUrl)
+	}
+	if conditional {
+		resp, err = conditionalGet(httpClient, pageUrl)
+	} else {
+		resp, err = politeGet(httpClient, pageUrl)
 	}
-	defer resp.Body.Close()
-	root, err := html.Parse(resp.Body)
 	if err != nil {
-		return nil, err // TODO: wrap up as ScrapeError?
+		return nil, scrapeError(ErrFetch, pageUrl, err)
 	}
+	defer resp.Body.Close()
 	docs := make([]*PressRelease, 0)
-	for _, a := range linkSel.MatchAll(root) {
-		link, err := page.Parse(getAttr(a, "href")) // extend to absolute url if needed
+	if resp.StatusCode == http.StatusNotModified {
+		// nothing new since last time
+		return docs, nil
+	}
+	body, err := utf8Body(resp)
+	if err != nil {
+		return nil, scrapeError(ErrFetch, pageUrl, err)
+	}
+	// (read in first, so a failed read isn't taken for a parse error)
+	raw, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, scrapeError(ErrFetch, pageUrl, err)
+	}
+	root, err := html.Parse(bytes.NewReader(raw))
+	if err != nil {
+		return nil, scrapeError(ErrParse, pageUrl, err)
+	}
+	// relative links are relative to wherever we ended up after any
+	// redirects (or a <base> tag, if there is one)
+	base := resp.Request.URL
+	if baseEl := querySelector(root, "head base[href]"); baseEl != nil {
+		if b, err := base.Parse(getAttr(baseEl, "href")); err == nil {
+			base = b
+		}
+	}
+	// (index pages often link to the same press release more than once)
+	seen := make(map[string]*PressRelease)
+	for _, a := range querySelectorAll(root, linkSelector) {
+		link, err := resolveLink(base, getAttr(a, "href"))
 		if err != nil {
-			// TODO: log a warning?
+			debugf("%s: skipping link on %s: %s", scraperName, pageUrl, err)
 			continue
 		}
-		pr := PressRelease{Source: scraperName, Permalink: link.String()}
+		link = normalizePermalink(link)
+		if wm != nil && wm.passed(a) {
+			debugf("%s: reached releases from before %s on %s, stopping there", scraperName, wm.since.Format("2 Jan 2006"), pageUrl)
+			wm.reached = true
+			break
+		}
+		// the link text is kept as a provisional title, in case the
+		// title can't be found when the page itself is scraped
+		title := linkTitle(a)
+		if pr := seen[link]; pr != nil {
+			if pr.Title == "" {
+				pr.Title = title
+			}
+			continue
+		}
+		pr := PressRelease{Source: scraperName, Permalink: link, Title: title}
+		seen[link] = &pr
 		docs = append(docs, &pr)
 	}
+	if len(docs) == 0 && !(wm != nil && wm.reached) && markers.present(root) {
+		// (forget the page, so a 304 next time round doesn't pass for a
+		// quiet day)
+		forgetValidators(pageUrl)
+		return nil, errProbableOutage
+	}
 	return docs, nil
 }
 
-// scrape a press release based on a bunch of css selector strings
-func GenericScrape(source string, pr *PressRelease, raw_html, title, content, cruft, pubDate string) error {
-	titleSel := cascadia.MustCompile(title)
-	contentSel := cascadia.MustCompile(content)
+// link texts which say nothing about the press release, so won't do as
+// titles
+var vagueLinkTexts = map[string]bool{
+	"more":             true,
+	"more...":          true,
+	"read more":        true,
+	"read more...":     true,
+	"read more »":      true,
+	"full story":       true,
+	"click here":       true,
+	"continue reading": true,
+	"view":             true,
+	"details":          true,
+}
+
+// linkTitle returns the text of a link on an index page, for use as a
+// provisional title (or "" if it's one of the vagueLinkTexts).
+func linkTitle(a *html.Node) string {
+	title := normaliseSpace(getTextContent(a))
+	if vagueLinkTexts[strings.ToLower(title)] {
+		return ""
+	}
+	return title
+}
+
+// resolveLink turns a (possibly relative or protocol-relative) href into an
+// absolute url. Only http and https links are accepted.
+func resolveLink(base *url.URL, href string) (string, error) {
+	href = strings.TrimSpace(href)
+	if href == "" {
+		return "", errors.New("empty href")
+	}
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", err
+	}
+	link := base.ResolveReference(ref)
+	if link.Scheme != "http" && link.Scheme != "https" {
+		return "", fmt.Errorf("not a http link: %s", href)
+	}
+	return link.String(), nil
+}
+
+// normalizePermalink strips the tracking and session parameters (see
+// -strip-params) and any fragment off a url, so that a press release
+// linked with different ones from poll to poll still has the one
+// permalink. Other parameters are left alone, in their original order.
+// Urls which don't parse are returned as they are.
+func normalizePermalink(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return raw
+	}
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.RawQuery != "" {
+		var kept []string
+		for _, param := range strings.Split(u.RawQuery, "&") {
+			name := param
+			if i := strings.Index(param, "="); i >= 0 {
+				name = param[:i]
+			}
+			if unescaped, err := url.QueryUnescape(name); err == nil {
+				name = unescaped
+			}
+			if param != "" && !isTrackingParam(name) {
+				kept = append(kept, param)
+			}
+		}
+		u.RawQuery = strings.Join(kept, "&")
+	}
+	u.ForceQuery = false
+	return u.String()
+}
+
+// isTrackingParam returns true if a query parameter is one of those
+// stripped by normalizePermalink. The names in -strip-params are matched
+// case-insensitively, and can end in * to match a prefix (eg utm_*).
+func isTrackingParam(name string) bool {
+	name = strings.ToLower(name)
+	for _, pat := range strings.Split(strings.ToLower(*stripParams), ",") {
+		pat = strings.TrimSpace(pat)
+		switch {
+		case pat == "":
+		case strings.HasSuffix(pat, "*"):
+			if strings.HasPrefix(name, strings.TrimSuffix(pat, "*")) {
+				return true
+			}
+		case name == pat:
+			return true
+		}
+	}
+	return false
+}
+
+// GenericFetchListPaged extracts links from a run of paginated index pages,
+// for digging back into a site's archives.
+// pageUrlTemplate has a %d, which is replaced with the page number
+// (starting at 1), eg "http://www.72point.com/coverage/page/%d/".
+// Stops after maxPages, or at the first page which yields no links.
+// Links which turn up on more than one page are only returned once.
+func GenericFetchListPaged(scraperName, pageUrlTemplate, linkSelector string, maxPages int) ([]*PressRelease, error) {
+	return GenericFetchListPagedSince(scraperName, pageUrlTemplate, linkSelector, "", maxPages, time.Time{})
+}
+
+// GenericFetchListPagedSince is GenericFetchListPaged with a watermark (as
+// for GenericFetchListSince): it stops at the first link dated before
+// since, without going on to any more pages.
+func GenericFetchListPagedSince(scraperName, pageUrlTemplate, linkSelector, dateSelector string, maxPages int, since time.Time) ([]*PressRelease, error) {
+	docs := make([]*PressRelease, 0)
+	seen := make(map[string]bool)
+	for pageNum := 1; pageNum <= maxPages; pageNum++ {
+		wm := newWatermark(dateSelector, since)
+		pageDocs, err := fetchLinks(scraperName, fmt.Sprintf(pageUrlTemplate, pageNum), linkSelector, false, OutageMarkers{}, wm)
+		if err != nil {
+			return nil, err
+		}
+		if len(pageDocs) == 0 {
+			break
+		}
+		for _, pr := range pageDocs {
+			if seen[pr.Permalink] {
+				continue
+			}
+			seen[pr.Permalink] = true
+			docs = append(docs, pr)
+		}
+		if wm != nil && wm.reached {
+			break
+		}
+	}
+	return docs, nil
+}
+
+// GenericFetchArchivePage extracts links from a single page of a site's
+// paginated archives (for an ArchiveScraper). pageUrlTemplate is as for
+// GenericFetchListPaged.
+// (no conditional GETs here - an unchanged page shouldn't end the run)
+func GenericFetchArchivePage(scraperName, pageUrlTemplate, linkSelector string, page int) ([]*PressRelease, error) {
+	return fetchLinks(scraperName, fmt.Sprintf(pageUrlTemplate, page), linkSelector, false, OutageMarkers{}, nil)
+}
+
+// ScrapeSpec describes how to scrape a press release from a page, as a bunch
+// of css selector strings. Title and Content are required, the rest are
+// optional.
+// Title, Content and PubDate can each have a few candidate selectors, to
+// cope with sites which use more than one template (or redesign now and
+// again). They're tried in order, and the first one which matches a
+// non-empty element is used.
+// If the page has schema.org JSON-LD describing the article, the headline,
+// date, body and image from that are used in preference to the selectors.
+type ScrapeSpec struct {
+	Title   []string
+	Content []string
+	// stuff to remove from the content (share buttons, related links, ads
+	// etc) - everything matching any of them goes
+	Cruft   []string
+	PubDate []string
+	// categories/tags - the text of each matching element becomes a tag
+	Tags string
+	// the lead image, looked for within the content (after the cruft is
+	// removed). Defaults to the first <img> there.
+	Image string
+	// the author/spokesperson byline. Falls back to the page's
+	// article:author or author meta tags.
+	Author string
+	// a regexp marking the end of the press release proper - the content is
+	// cut off at the first match, and anything after goes into Notes
+	// instead. Defaults to DefaultEndMarker.
+	EndMarker string
+	// a regexp matching the site name tacked onto the end of titles, to be
+	// stripped off, eg `\s*\|\s*Tesco PLC`
+	TitleSuffix string
+}
+
+// DefaultEndMarker matches the "-ENDS-" line most UK press releases finish
+// with (before the contact details and notes to editors).
+// It also matches a bare "ENDS", but only on a line by itself.
+const DefaultEndMarker = `(?im)-\s*ends\s*-|^\s*ends\s*$`
+
+// patterns holds the regexps compiled by compilePattern, by their source
+var patterns = struct {
+	sync.Mutex
+	compiled map[string]*regexp.Regexp
+}{compiled: make(map[string]*regexp.Regexp)}
+
+// compilePattern compiles a regexp from a scraper (eg an end marker), once
+// rather than for every page scraped
+func compilePattern(expr string) (*regexp.Regexp, error) {
+	patterns.Lock()
+	defer patterns.Unlock()
+	if pat, ok := patterns.compiled[expr]; ok {
+		return pat, nil
+	}
+	pat, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	patterns.compiled[expr] = pat
+	return pat, nil
+}
+
+// scrape a press release based on a bunch of css selector strings (see
+// ScrapeSpec, which also cuts it off at the end marker). Tags are optional. cruft can be empty - for more than one
+// cruft selector, use a ScrapeSpec (or a selector group, eg ".share, .ad").
+func GenericScrape(source string, pr *PressRelease, raw_html string, title, content []string, cruft string, pubDate []string, tags ...string) error {
+	spec := ScrapeSpec{Title: title, Content: content, PubDate: pubDate, Tags: strings.Join(tags, ", ")}
+	if cruft != "" {
+		spec.Cruft = []string{cruft}
+	}
+	return spec.Scrape(source, pr, raw_html)
+}
+
+// Scrape fills out a press release from raw html, according to the spec.
+// Failures are ScrapeErrors (ErrSelectorNotFound if the title or content
+// can't be found).
+func (spec *ScrapeSpec) Scrape(source string, pr *PressRelease, raw_html string) error {
+	endMarker := spec.EndMarker
+	if endMarker == "" {
+		endMarker = DefaultEndMarker
+	}
+	endPat, err := compilePattern(endMarker)
+	if err != nil {
+		return scrapeError(ErrParse, pr.Permalink, fmt.Errorf("bad end marker: %s", err))
+	}
+	var suffix *regexp.Regexp
+	if spec.TitleSuffix != "" {
+		suffix, err = compilePattern("(?:" + spec.TitleSuffix + ")$")
+		if err != nil {
+			return scrapeError(ErrParse, pr.Permalink, fmt.Errorf("bad title suffix: %s", err))
+		}
+	}
 
 	r := strings.NewReader(string(raw_html))
 	root, err := html.Parse(r)
 	if err != nil {
-		return err // TODO: wrap up as ScrapeError?
+		return scrapeError(ErrParse, pr.Permalink, err)
 	}
 
 	pr.Source = source
+	if htmlEl := querySelector(root, "html[lang]"); htmlEl != nil {
+		pr.Lang = normaliseLang(getAttr(htmlEl, "lang"))
+	}
+	pr.CanonicalURL = findCanonical(root, pr)
 
-	// title
-	pr.Title = compressSpace(getTextContent(titleSel.MatchAll(root)[0]))
+	// schema.org metadata, if the page has it, takes priority over the
+	// selectors
+	ld := findArticleLD(root)
+	if ld == nil {
+		ld = &ldArticle{}
+	}
 
-	// pubdate - only needs to contain a valid date string, doesn't matter
-	// if there's other crap in there too.
-	if pubDate != "" {
-		pubDateSel := cascadia.MustCompile(pubDate)
-		dateTxt := getTextContent(pubDateSel.MatchAll(root)[0])
-		pr.PubDate, err = fuzzytime.Parse(dateTxt)
+	// title (if it can't be found, any provisional one from the index page
+	// is kept)
+	if headline := cleanTitle(ld.Headline, suffix); headline != "" {
+		pr.Title = headline
+	} else {
+		title := ""
+		if titleEl := firstMatch(source, "title", root, spec.Title); titleEl != nil {
+			title = cleanTitle(getTextContent(titleEl), suffix)
+		}
+		switch {
+		case title != "":
+			pr.Title = title
+		case pr.Title != "":
+			warnf("%s: no title found (%s), using the link text", source, strings.Join(spec.Title, " | "))
+		default:
+			return scrapeError(ErrSelectorNotFound, pr.Permalink, fmt.Errorf("no title (%s)", strings.Join(spec.Title, " | ")))
+		}
+	}
+
+	if ld.DatePublished != "" {
+		t, err := parsePubDate(ld.DatePublished)
 		if err != nil {
-			return err
+			warnf("%s: couldn't parse json-ld date '%s' (%s)", source, ld.DatePublished, err)
+		} else {
+			pr.PubDate = t
 		}
-	} else {
-		// if time isn't already set, just fudge using current time
-		if pr.PubDate.IsZero() {
-			pr.PubDate = time.Now()
+	}
+	// pubdate - only needs to contain a valid date string, doesn't matter
+	// if there's other crap in there too.
+	if pr.PubDate.IsZero() && len(spec.PubDate) > 0 {
+		if dateEl := firstMatch(source, "pubdate", root, spec.PubDate); dateEl == nil {
+			warnf("%s: no date found (%s)", source, strings.Join(spec.PubDate, " | "))
+		} else {
+			dateTxt := getTextContent(dateEl)
+			t, err := parsePubDate(dateTxt)
+			if err != nil {
+				warnf("%s: couldn't parse date '%s' (%s)", source, normaliseSpace(dateTxt), err)
+			} else {
+				pr.PubDate = t
+			}
 		}
 	}
+	// if time isn't already set, just fudge using current time
+	if pr.PubDate.IsZero() {
+		pr.PubDate = time.Now()
+	}
+
+	pr.Tags = nil
+	if spec.Tags != "" {
+		pr.Tags = findTags(root, spec.Tags)
+	}
+	pr.Author = findAuthor(root, spec.Author)
 
 	// content
-	contentEl := contentSel.MatchAll(root)[0]
-	if cruft != "" {
-		cruftSel := cascadia.MustCompile(cruft)
-		for _, cruft := range cruftSel.MatchAll(contentEl) {
+	var contentEl *html.Node
+	if ld.ArticleBody != "" {
+		contentEl = ldBody(ld.ArticleBody)
+	} else {
+		contentEl = firstMatch(source, "content", root, spec.Content)
+		if contentEl == nil {
+			return scrapeError(ErrSelectorNotFound, pr.Permalink, fmt.Errorf("no content (%s)", strings.Join(spec.Content, " | ")))
+		}
+	}
+	for _, sel := range spec.Cruft {
+		if sel == "" {
+			continue
+		}
+		for _, cruft := range querySelectorAll(contentEl, sel) {
 			cruft.Parent.RemoveChild(cruft)
 		}
 	}
+	notesEl := splitAt(contentEl, endPat)
+
+	pr.ImageURL = findImage(root, contentEl, spec.Image, pr)
+	if ld.Image != "" {
+		if link, err := resolveLink(releaseBase(pr), ld.Image); err == nil {
+			pr.ImageURL = link
+		}
+	}
+
+	pr.Content, err = renderScrubbed(contentEl)
+	if err != nil {
+		return scrapeError(ErrParse, pr.Permalink, err)
+	}
+	pr.Text = htmlText(contentEl)
+	pr.Excerpt = findExcerpt(root, pr.Text)
+	// whatever came after the end marker (contacts, notes to editors etc)
+	pr.Notes = ""
+	if notesEl != nil && notesEl.FirstChild != nil {
+		pr.Notes, err = renderScrubbed(notesEl)
+		if err != nil {
+			return scrapeError(ErrParse, pr.Permalink, err)
+		}
+	}
+	return nil
+}
+
+// findCanonical returns the (absolute) url given by the page's
+// <link rel="canonical">, or "" if there isn't a usable one
+func findCanonical(root *html.Node, pr *PressRelease) string {
+	el := querySelector(root, `link[rel="canonical"][href]`)
+	if el == nil {
+		return ""
+	}
+	link, err := resolveLink(releaseBase(pr), strings.TrimSpace(getAttr(el, "href")))
+	if err != nil || !strings.HasPrefix(link, "http") {
+		return ""
+	}
+	return link
+}
+
+// releaseBase returns the url to resolve relative links in a press release
+// against - wherever it ended up after redirects, if known
+func releaseBase(pr *PressRelease) *url.URL {
+	if pr.FinalURL != "" {
+		if base, err := url.Parse(pr.FinalURL); err == nil {
+			return base
+		}
+	}
+	base, err := url.Parse(pr.Permalink)
+	if err != nil {
+		return &url.URL{}
+	}
+	return base
+}
+
+// findTags collects up the (trimmed) text of each element matching
+// selector, skipping empty and duplicate ones
+func findTags(root *html.Node, selector string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	for _, n := range querySelectorAll(root, selector) {
+		tag := compressSpace(getTextContent(n))
+		if tag == "" || seen[strings.ToLower(tag)] {
+			continue
+		}
+		seen[strings.ToLower(tag)] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
+// firstMatch tries each of the selectors in turn, returning the first
+// element under root which matches and has some text (or an image) in it.
+// Returns nil if none of them match. what is just for the log.
+func firstMatch(source, what string, root *html.Node, selectors []string) *html.Node {
+	for _, sel := range selectors {
+		for _, n := range querySelectorAll(root, sel) {
+			if strings.TrimSpace(getTextContent(n)) == "" && querySelector(n, "img") == nil {
+				continue
+			}
+			debugf("%s: %s matched '%s'", source, what, sel)
+			return n
+		}
+	}
+	return nil
+}
+
+// renderScrubbed runs n through scrubHTML and renders it out as html
+func renderScrubbed(n *html.Node) (string, error) {
+	scrubHTML(n)
 	var out bytes.Buffer
-	err = html.Render(&out, contentEl)
+	err := html.Render(&out, n)
 	if err != nil {
-		return err
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// splitAt cuts off everything in n from the first match of endPat
+// onwards, and returns it as a separate tree (with the same structure, so
+// any elements which get split in two are duplicated). The matched text
+// itself is discarded.
+// Matches are only looked for within individual text nodes, and the cut is
+// done on the tree, so tags are kept balanced. Elements left empty by the
+// cut are removed.
+// Returns nil if there was no match.
+func splitAt(n *html.Node, endPat *regexp.Regexp) *html.Node {
+	txt := findText(n, endPat)
+	if txt == nil {
+		return nil
+	}
+	loc := endPat.FindStringIndex(txt.Data)
+	var carried *html.Node
+	if after := strings.TrimLeft(txt.Data[loc[1]:], " \t\r\n"); after != "" {
+		carried = &html.Node{Type: html.TextNode, Data: after}
+	}
+	txt.Data = txt.Data[:loc[0]]
+
+	// move everything following over to the new tree, all the way up
+	var rest *html.Node
+	for cur := txt; cur != n; cur = cur.Parent {
+		parent := cur.Parent
+		rest = &html.Node{Type: parent.Type, Data: parent.Data, DataAtom: parent.DataAtom, Namespace: parent.Namespace}
+		rest.Attr = append(rest.Attr, parent.Attr...)
+		if carried != nil {
+			rest.AppendChild(carried)
+		}
+		for cur.NextSibling != nil {
+			next := cur.NextSibling
+			parent.RemoveChild(next)
+			rest.AppendChild(next)
+		}
+		carried = rest
+	}
+	pruneEmpty(rest)
+
+	// tidy up any leftover empties
+	for cur := txt; cur != n && cur.FirstChild == nil && (cur.Type != html.TextNode || strings.TrimSpace(cur.Data) == ""); {
+		parent := cur.Parent
+		parent.RemoveChild(cur)
+		cur = parent
+	}
+	return rest
+}
+
+// elements which are allowed to be empty
+var voidElements = map[string]bool{
+	"br":  true,
+	"hr":  true,
+	"img": true,
+}
+
+// pruneEmpty removes any elements under n with no text or images in them
+func pruneEmpty(n *html.Node) {
+	var next *html.Node
+	for child := n.FirstChild; child != nil; child = next {
+		next = child.NextSibling
+		if child.Type != html.ElementNode || voidElements[child.Data] {
+			continue
+		}
+		if strings.TrimSpace(getTextContent(child)) == "" && querySelector(child, "img") == nil {
+			n.RemoveChild(child)
+			continue
+		}
+		pruneEmpty(child)
+	}
+}
+
+// findText returns the first text node under n (in document order) which
+// matches pat, or nil if none.
+func findText(n *html.Node, pat *regexp.Regexp) *html.Node {
+	if n.Type == html.TextNode {
+		if pat.MatchString(n.Data) {
+			return n
+		}
+		return nil
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findText(child, pat); found != nil {
+			return found
+		}
 	}
-	pr.Content = out.String()
 	return nil
 }
+
+// findAuthor returns the (whitespace-compressed) text of the first element
+// matching selector, or failing that, the page's article:author or author
+// meta tag. Meta tags which are just a url (eg a link to a profile page)
+// are skipped. Returns "" if there's no author.
+func findAuthor(root *html.Node, selector string) string {
+	if selector != "" {
+		for _, n := range querySelectorAll(root, selector) {
+			if author := compressSpace(getTextContent(n)); author != "" {
+				return author
+			}
+		}
+	}
+	for _, sel := range []string{`meta[property="article:author"]`, `meta[name="author"]`} {
+		for _, meta := range querySelectorAll(root, sel) {
+			author := compressSpace(getAttr(meta, "content"))
+			if author != "" && !strings.HasPrefix(author, "http://") && !strings.HasPrefix(author, "https://") {
+				return author
+			}
+		}
+	}
+	return ""
+}
+
+// excerptLength is the most runes an Excerpt runs to (before the ellipsis)
+const excerptLength = 200
+
+// excerpt cuts text down to a one-line summary of at most excerptLength
+// runes, on a word boundary, with an ellipsis if anything was cut off.
+func excerpt(text string) string {
+	text = normaliseSpace(text)
+	runes := []rune(text)
+	if len(runes) <= excerptLength {
+		return text
+	}
+	cut := string(runes[:excerptLength])
+	// (unless it happens to end on one, back up to the last word boundary)
+	if !unicode.IsSpace(runes[excerptLength]) {
+		if i := strings.LastIndex(cut, " "); i > 0 {
+			cut = cut[:i]
+		}
+	}
+	return strings.TrimRight(cut, " ,;:.-–—") + "…"
+}
+
+// findExcerpt returns the excerpt for a press release - the page's own
+// summary, from its description or og:description meta tag, or failing
+// that, the start of text.
+func findExcerpt(root *html.Node, text string) string {
+	for _, sel := range []string{`meta[name="description"]`, `meta[property="og:description"]`} {
+		for _, meta := range querySelectorAll(root, sel) {
+			if desc := excerpt(getAttr(meta, "content")); desc != "" {
+				return desc
+			}
+		}
+	}
+	return excerpt(text)
+}
+
+// findImage picks out the absolute url of the lead image for a press
+// release - the first image matching imageSelector (default "img") within
+// the content, or failing that, the og:image of the page.
+// Returns an empty string if there's no image.
+func findImage(root, contentEl *html.Node, imageSelector string, pr *PressRelease) string {
+	if imageSelector == "" {
+		imageSelector = "img"
+	}
+	base := releaseBase(pr)
+	var candidates []string
+	if img := querySelector(contentEl, imageSelector); img != nil {
+		candidates = append(candidates, getAttr(img, "src"))
+	}
+	if meta := querySelector(root, `head meta[property="og:image"]`); meta != nil {
+		candidates = append(candidates, getAttr(meta, "content"))
+	}
+	for _, src := range candidates {
+		link, err := resolveLink(base, src)
+		if err == nil {
+			return link
+		}
+	}
+	return ""
+}
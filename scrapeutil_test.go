@@ -0,0 +1,873 @@
+package main
+
+import (
+	"code.google.com/p/go.net/html"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScrubHTML(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			"attributes",
+			`<p style="color: red" id="x" class="c" onclick="steal()" title="kept">Hi</p>`,
+			`<div><p title="kept">Hi</p></div>`,
+		},
+		{
+			"dodgy elements",
+			`<p>Hi</p><script>steal()</script><style>p {}</style><iframe src="ad"></iframe><!-- comment -->`,
+			`<div><p>Hi</p></div>`,
+		},
+		{
+			"bare spans unwrapped",
+			`<p>Hi <span class="x">there <b>you</b></span> <span lang="cy">Helo</span></p>`,
+			`<div><p>Hi there <b>you</b> <span lang="cy">Helo</span></p></div>`,
+		},
+		{
+			"nested",
+			`<ul><li><span style="a"><span>deep</span></span><script>bad()</script></li></ul>`,
+			`<div><ul><li>deep</li></ul></div>`,
+		},
+	}
+	for _, test := range tests {
+		root, err := html.Parse(strings.NewReader(`<div id="content" class="main">` + test.in + `</div>`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := renderScrubbed(querySelector(root, "#content"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestEndMarker(t *testing.T) {
+	tests := []struct {
+		name, content, notes string
+	}{
+		{
+			"in a paragraph",
+			`<p>Keep me. The offer ends soon.</p><p>Also me <b>bold</b> -ENDS- Contact: press office</p><p>Notes to editors</p>`,
+			`<div><p>Contact: press office</p><p>Notes to editors</p></div>`,
+		},
+		{
+			"own element",
+			`<p>Keep me. The offer ends soon.</p><p>Also me <b>bold</b></p><p><strong>- ENDS -</strong></p><p>Notes to editors</p>`,
+			`<div><p>Notes to editors</p></div>`,
+		},
+		{
+			"bare line",
+			`<p>Keep me. The offer ends soon.</p><p>Also me <b>bold</b></p><div><p>ENDS</p><p>Notes to editors</p></div>`,
+			`<div><div><p>Notes to editors</p></div></div>`,
+		},
+	}
+	for _, test := range tests {
+		page := `<html><body><h1>Title</h1><div id="content">` + test.content + `</div><div>Footer</div></body></html>`
+		pr := &PressRelease{Permalink: "http://example.com/1"}
+		spec := ScrapeSpec{Title: []string{"h1"}, Content: []string{"#content"}}
+		if err := spec.Scrape("example", pr, page); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		// (the id is scrubbed off the container)
+		want := `<div><p>Keep me. The offer ends soon.</p><p>Also me <b>bold</b></p></div>`
+		if got := strings.TrimSpace(pr.Content); got != want {
+			t.Errorf("%s: got content %q, want %q", test.name, got, want)
+		}
+		if got := strings.TrimSpace(pr.Notes); got != test.notes {
+			t.Errorf("%s: got notes %q, want %q", test.name, got, test.notes)
+		}
+	}
+}
+
+// GenericScrape has no end marker of its own to give, so gets the default.
+func TestGenericScrapeEndMarker(t *testing.T) {
+	page := `<html><body><h1>Title</h1><div class="body"><p>The release.</p><p>-ENDS-</p><p>For more information call...</p></div></body></html>`
+	pr := &PressRelease{Permalink: "http://example.com/1"}
+	err := GenericScrape("example", pr, page, []string{"h1"}, []string{".body"}, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(pr.Content), "<div><p>The release.</p></div>"; got != want {
+		t.Errorf("got content %q, want %q", got, want)
+	}
+	if !strings.Contains(pr.Notes, "For more information") {
+		t.Errorf("got notes %q", pr.Notes)
+	}
+}
+
+func TestBadEndMarker(t *testing.T) {
+	pr := &PressRelease{Permalink: "http://example.com/1"}
+	spec := ScrapeSpec{Title: []string{"h1"}, Content: []string{"p"}, EndMarker: "-(ENDS"}
+	err := spec.Scrape("example", pr, `<h1>Title</h1><p>The release.</p>`)
+	if !errors.Is(err, ErrParse) {
+		t.Errorf("got %v, want a parse error", err)
+	}
+
+	scraper := &ConfigScraper{ScraperName: "example", URL: "http://example.com/news", Links: "a", Title: selectorList{"h1"}, Content: selectorList{"p"}, EndMarker: "-(ENDS"}
+	if err := scraper.Validate(); err == nil || !strings.Contains(err.Error(), "end_marker") {
+		t.Errorf("got %v, want a bad end_marker error", err)
+	}
+}
+
+func TestGenericFetchListPaged(t *testing.T) {
+	var fetched []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var n int
+		if _, err := fmt.Sscanf(r.URL.Path, "/page/%d/", &n); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		fetched = append(fetched, r.URL.Path)
+		if n > 3 {
+			fmt.Fprint(w, "<html><body>No more news</body></html>")
+			return
+		}
+		// (each page's last link is also the next one's first)
+		fmt.Fprintf(w, `<div class="news"><a href="/news/%d">%d</a><a href="/news/%d">%d</a></div>`, n, n, n+1, n+1)
+	}))
+	defer srv.Close()
+
+	docs, err := GenericFetchListPaged("paged", srv.URL+"/page/%d/", ".news a", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, pr := range docs {
+		got = append(got, strings.TrimPrefix(pr.Permalink, srv.URL))
+	}
+	if want := "[/news/1 /news/2 /news/3 /news/4]"; fmt.Sprint(got) != want {
+		t.Errorf("got %v, want %s", got, want)
+	}
+	// (stopping at the first empty page)
+	if want := "[/page/1/ /page/2/ /page/3/ /page/4/]"; fmt.Sprint(fetched) != want {
+		t.Errorf("fetched %v, want %s", fetched, want)
+	}
+
+	fetched = nil
+	docs, err = GenericFetchListPaged("paged", srv.URL+"/page/%d/", ".news a", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 3 || len(fetched) != 2 {
+		t.Errorf("maxPages 2: got %d links from %v, want 3 from 2 pages", len(docs), fetched)
+	}
+}
+
+func TestConditionalFetchList(t *testing.T) {
+	const lastModified = "Wed, 12 Mar 2014 09:30:00 GMT"
+	tests := []struct {
+		name        string
+		validator   func(w http.ResponseWriter)
+		notModified func(r *http.Request) bool
+	}{
+		{
+			"etag",
+			func(w http.ResponseWriter) { w.Header().Set("ETag", `"v1"`) },
+			func(r *http.Request) bool { return r.Header.Get("If-None-Match") == `"v1"` },
+		},
+		{
+			"last-modified",
+			func(w http.ResponseWriter) { w.Header().Set("Last-Modified", lastModified) },
+			func(r *http.Request) bool { return r.Header.Get("If-Modified-Since") == lastModified },
+		},
+	}
+	for _, test := range tests {
+		full := 0
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/robots.txt" {
+				http.NotFound(w, r)
+				return
+			}
+			if test.notModified(r) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			full++
+			test.validator(w)
+			fmt.Fprint(w, `<a class="news" href="/news/1">One</a>`)
+		}))
+		cleanRuns.set("conditional", true)
+
+		docs, err := GenericFetchList("conditional", srv.URL+"/news", "a.news")
+		if err != nil || len(docs) != 1 {
+			t.Errorf("%s: first fetch got %d links (%v), want 1", test.name, len(docs), err)
+		}
+		// (unchanged since, so nothing to do)
+		docs, err = GenericFetchList("conditional", srv.URL+"/news", "a.news")
+		if err != nil || len(docs) != 0 {
+			t.Errorf("%s: second fetch got %d links (%v), want none", test.name, len(docs), err)
+		}
+		if full != 1 {
+			t.Errorf("%s: page served in full %d times, want once", test.name, full)
+		}
+		srv.Close()
+	}
+}
+
+func TestParsePubDate(t *testing.T) {
+	// (in March, before the clocks go forward, UK time is UTC)
+	march12 := time.Date(2014, 3, 12, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		raw  string
+		want time.Time
+	}{
+		{"12 March 2014", march12},
+		{"Wednesday 12th March 2014", march12},
+		{"Posted by Bob on 12 Mar 2014, 3 comments", march12},
+		{"2014-03-12", march12},
+		{"12/03/2014", march12},
+		{"March 12, 2014", march12},
+		{"Published: 12.03.2014", march12},
+		{"Date: 12/03/14", march12},
+		{"12 March 2014 14:30", time.Date(2014, 3, 12, 14, 30, 0, 0, time.UTC)},
+	}
+	for _, test := range tests {
+		got, err := parsePubDate(test.raw)
+		if err != nil {
+			t.Errorf("%q: %s", test.raw, err)
+		} else if !got.Equal(test.want) {
+			t.Errorf("%q: got %s, want %s", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestResolveLink(t *testing.T) {
+	base, _ := url.Parse("http://example.com/news/index.html")
+	tests := []struct {
+		href, want string
+	}{
+		{"http://other.com/a", "http://other.com/a"},
+		{"/media/1", "http://example.com/media/1"},
+		{"2014/prices", "http://example.com/news/2014/prices"},
+		{"../about", "http://example.com/about"},
+		{"//cdn.example.com/x", "http://cdn.example.com/x"},
+		{"  ?page=2 ", "http://example.com/news/index.html?page=2"},
+		{"https://secure.example.com/a", "https://secure.example.com/a"},
+		// (no good)
+		{"", ""},
+		{"mailto:press@example.com", ""},
+		{"javascript:void(0)", ""},
+	}
+	for _, test := range tests {
+		got, err := resolveLink(base, test.href)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%q: got %q, want an error", test.href, got)
+			}
+		} else if err != nil || got != test.want {
+			t.Errorf("%q: got %q (%v), want %q", test.href, got, err, test.want)
+		}
+	}
+}
+
+func TestFetchListRelativeLinks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<div class="news">
+<a href="/news/1">One</a>
+<a href="2">Two</a>
+<a href="mailto:press@example.com">Press office</a>
+<a href="http://example.com/3">Three</a>
+</div>`)
+	}))
+	defer srv.Close()
+	docs, err := GenericFetchList("relative", srv.URL+"/news/", ".news a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, pr := range docs {
+		got = append(got, pr.Permalink)
+	}
+	want := []string{srv.URL + "/news/1", srv.URL + "/news/2", "http://example.com/3"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestLeadImage(t *testing.T) {
+	const head = `<html><head><meta property="og:image" content="http://cdn.example.com/og.jpg"></head>`
+	tests := []struct {
+		name, page string
+		spec       ScrapeSpec
+		want       string
+	}{
+		{
+			"first in the content",
+			head + `<body><h1>Hi</h1><div class="body"><div class="share"><img src="/share.png"></div><p>x</p><img src="pics/hero.jpg"><img src="pics/second.jpg"></div></body></html>`,
+			ScrapeSpec{Title: []string{"h1"}, Content: []string{".body"}, Cruft: []string{".share"}},
+			"http://example.com/news/pics/hero.jpg",
+		},
+		{
+			"og:image",
+			head + `<body><h1>Hi</h1><div class="body"><p>x</p></div></body></html>`,
+			ScrapeSpec{Title: []string{"h1"}, Content: []string{".body"}},
+			"http://cdn.example.com/og.jpg",
+		},
+		{
+			"none",
+			`<html><body><h1>Hi</h1><div class="body"><p>x</p></div></body></html>`,
+			ScrapeSpec{Title: []string{"h1"}, Content: []string{".body"}},
+			"",
+		},
+	}
+	for _, test := range tests {
+		pr := &PressRelease{Permalink: "http://example.com/news/1"}
+		if err := test.spec.Scrape("images", pr, test.page); err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if pr.ImageURL != test.want {
+			t.Errorf("%s: got image %q, want %q", test.name, pr.ImageURL, test.want)
+		}
+	}
+
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		ev, err := store.Stash(&PressRelease{Source: "images", Permalink: "http://example.com/news/1", ImageURL: "http://example.com/hero.jpg"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pr, err := store.Get("images", fmt.Sprint(ev.id)); err != nil || pr.ImageURL != "http://example.com/hero.jpg" {
+			t.Errorf("%T: got %v (%v) back", store, pr, err)
+		}
+	}
+}
+
+func TestQuerySelector(t *testing.T) {
+	root, err := html.Parse(strings.NewReader(`<div id="news"><p class="a">one</p><p>two</p><div><p class="a">three</p></div></div><p class="a">four</p>`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := querySelector(root, "#news .a"); n == nil || getTextContent(n) != "one" {
+		t.Errorf("got %v, want the first match", n)
+	}
+	if n := querySelector(root, "h1"); n != nil {
+		t.Errorf("got %v for no match, want nil", n)
+	}
+	var got []string
+	for _, n := range querySelectorAll(root, "#news p.a") {
+		got = append(got, getTextContent(n))
+	}
+	if fmt.Sprint(got) != "[one three]" {
+		t.Errorf("got %v, want [one three] (in document order)", got)
+	}
+	if n := querySelectorAll(root, "table td"); len(n) != 0 {
+		t.Errorf("got %d matches for nothing", len(n))
+	}
+	// (a bad selector matches nothing, rather than panicking mid-scrape)
+	for i := 0; i < 2; i++ {
+		if n := querySelector(root, "p["); n != nil {
+			t.Errorf("got %v for a bad selector", n)
+		}
+		if n := querySelectorAll(root, "p["); len(n) != 0 {
+			t.Errorf("got %d matches for a bad selector", len(n))
+		}
+	}
+	if compileSelector("#news .a") == nil || compileSelector("p[") != nil {
+		t.Errorf("selectors compiled wrongly")
+	}
+}
+
+// Links turning up more than once on an index page are only listed once,
+// where they first appear.
+func TestFetchListDuplicateLinks(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<div class="featured"><a href="/news/2">Featured</a></div>
+<ul><li><a href="/news/1">One</a></li><li><a href="/news/2">Two</a></li><li><a href="http://`+r.Host+`/news/1">One again</a></li></ul>`)
+	}))
+	defer srv.Close()
+	docs, err := GenericFetchList("duplicates", srv.URL+"/news/", "a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, pr := range docs {
+		got = append(got, strings.TrimPrefix(pr.Permalink, srv.URL))
+	}
+	if fmt.Sprint(got) != "[/news/2 /news/1]" {
+		t.Errorf("got %v, want [/news/2 /news/1]", got)
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	page := `<h1>Title</h1><div id="content">
+	<p>First   para
+	  with <b>bold</b>&nbsp;text.</p>
+	<p>Second<br>line two</p><ul><li>one</li><li>two</li></ul></div>`
+	pr := &PressRelease{Permalink: "http://example.com/1"}
+	if err := GenericScrape("text", pr, page, []string{"h1"}, []string{"#content"}, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	want := "First para with bold text.\n\nSecond\nline two\n\none\n\ntwo"
+	if pr.Text != want {
+		t.Errorf("got text %q, want %q", pr.Text, want)
+	}
+	// (the same again from the stored html)
+	if got := fragmentText(pr.Content); got != want {
+		t.Errorf("got %q from the content, want %q", got, want)
+	}
+}
+
+// The first candidate selector which matches something (other than
+// whitespace) wins.
+func TestCandidateSelectors(t *testing.T) {
+	spec := ScrapeSpec{Title: []string{"h1.old", "h2"}, Content: []string{".old", ".empty", ".new"}, PubDate: []string{".nodate", ".date"}}
+	page := `<h1 class="old"> </h1><h2>Title</h2><div class="empty"> </div><div class="new"><p>Body</p></div><div class="other"><p>Other</p></div><span class="date">1 March 2014</span>`
+	pr := &PressRelease{Permalink: "http://example.com/1"}
+	if err := spec.Scrape("candidates", pr, page); err != nil {
+		t.Fatal(err)
+	}
+	if pr.Title != "Title" {
+		t.Errorf("got title %q", pr.Title)
+	}
+	if pr.Content != "<div><p>Body</p></div>" {
+		t.Errorf("got content %q", pr.Content)
+	}
+	if want := time.Date(2014, 3, 1, 0, 0, 0, 0, time.UTC); !pr.PubDate.Equal(want) {
+		t.Errorf("got pubdate %s, want %s", pr.PubDate, want)
+	}
+
+	// none of them matching is an error
+	spec.Content = []string{".old", ".missing"}
+	err := spec.Scrape("candidates", &PressRelease{Permalink: "http://example.com/1"}, page)
+	if !errors.Is(err, ErrSelectorNotFound) {
+		t.Errorf("got %v with no content, want %v", err, ErrSelectorNotFound)
+	}
+}
+
+func TestTags(t *testing.T) {
+	for _, test := range []struct {
+		page string
+		want []string
+	}{
+		{`<ul class="tags"><li><a> Corporate </a></li><li><a>Product
+			News</a></li></ul>`, []string{"Corporate", "Product News"}},
+		// (empty and duplicate tags are dropped)
+		{`<ul class="tags"><li><a>Food</a></li><li><a> </a></li><li><a>food</a></li></ul>`, []string{"Food"}},
+		{`<ul class="categories"><li><a>Food</a></li></ul>`, nil},
+	} {
+		pr := &PressRelease{Permalink: "http://example.com/1"}
+		if err := GenericScrape("tags", pr, "<h1>Title</h1><p>Body</p>"+test.page, []string{"h1"}, []string{"p"}, "", nil, ".tags a"); err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%q", pr.Tags) != fmt.Sprintf("%q", test.want) {
+			t.Errorf("got tags %q, want %q", pr.Tags, test.want)
+		}
+	}
+}
+
+func TestCanonical(t *testing.T) {
+	for _, test := range []struct {
+		head, want string
+	}{
+		{`<link rel="canonical" href="/news/1">`, "http://example.com/news/1"},
+		{`<link rel="canonical" href=" http://www.example.com/news/1 ">`, "http://www.example.com/news/1"},
+		{`<link rel="canonical" href="mailto:press@example.com">`, ""},
+		{`<link rel="alternate" href="/m/news/1">`, ""},
+		{"", ""},
+	} {
+		pr := &PressRelease{Permalink: "http://example.com/news/1?utm_source=twitter"}
+		page := "<html><head>" + test.head + "</head><body><h1>Title</h1><div>Body</div></body></html>"
+		if err := GenericScrape("canonical", pr, page, []string{"h1"}, []string{"div"}, "", nil); err != nil {
+			t.Fatal(err)
+		}
+		if pr.CanonicalURL != test.want {
+			t.Errorf("%s: got %q, want %q", test.head, pr.CanonicalURL, test.want)
+		}
+	}
+}
+
+// The link text from the index is kept as a provisional title, for when
+// the page itself doesn't have one the selectors can find.
+func TestLinkTitleFallback(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<ul><li><a href="/1"><img src="1.png"></a> <a href="/1"> Prices  cut on 1,000 lines </a></li><li><a href="/2"><img src="2.png"></a></li></ul>`)
+	}))
+	defer srv.Close()
+	prs, err := GenericFetchList("link-title", srv.URL+"/", "li a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for _, pr := range prs {
+		titles = append(titles, pr.Title)
+	}
+	if fmt.Sprintf("%q", titles) != `["Prices cut on 1,000 lines" ""]` {
+		t.Fatalf("got titles %q", titles)
+	}
+
+	for _, test := range []struct {
+		provisional, page, want string
+	}{
+		{"Prices cut", `<div class="new-layout"><h2>Prices cut</h2></div><div class="body"><p>Content</p></div>`, "Prices cut"},
+		{"Prices cut", `<h1>Real title</h1><div class="body"><p>Content</p></div>`, "Real title"},
+		{"Prices cut", `<h1> </h1><div class="body"><p>Content</p></div>`, "Prices cut"},
+		{"", `<div class="body"><p>Content</p></div>`, ""},
+	} {
+		pr := &PressRelease{Permalink: srv.URL + "/1", Title: test.provisional}
+		err := GenericScrape("link-title", pr, test.page, []string{"h1"}, []string{".body"}, "", nil)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s: no error with no title at all", test.page)
+			}
+			continue
+		}
+		if err != nil || pr.Title != test.want {
+			t.Errorf("%s: got title %q (%v), want %q", test.page, pr.Title, err, test.want)
+		}
+	}
+}
+
+func TestTitleNormalisation(t *testing.T) {
+	spec := ScrapeSpec{Title: []string{"h1"}, Content: []string{".body"}, PubDate: []string{".date"}, TitleSuffix: `\s*\|\s*Tesco PLC`}
+	for _, test := range []struct {
+		title, want string
+	}{
+		{"<h1>\n  Prices cut\n   on 1,000\tlines\n</h1>", "Prices cut on 1,000 lines"},
+		{"<h1>Prices cut | Tesco PLC</h1>", "Prices cut"},
+		{"<h1>&nbsp;Prices&nbsp;&nbsp;cut​ &nbsp;</h1>", "Prices cut"},
+		// (the suffix isn't stripped if that would leave nothing)
+		{"<h1>Tesco PLC</h1>", "Tesco PLC"},
+		{"<h1>| Tesco PLC</h1>", "| Tesco PLC"},
+	} {
+		pr := &PressRelease{Permalink: "http://example.com/1"}
+		err := spec.Scrape("titles", pr, test.title+`<div class="date">&nbsp;12&nbsp;March&nbsp;2014&nbsp;</div><div class="body">Body</div>`)
+		if err != nil {
+			t.Errorf("%q: %s", test.title, err)
+			continue
+		}
+		if pr.Title != test.want {
+			t.Errorf("%q: got %q, want %q", test.title, pr.Title, test.want)
+		}
+		// (the date's tidied up the same way)
+		if want := time.Date(2014, 3, 12, 0, 0, 0, 0, time.UTC); !pr.PubDate.Equal(want) {
+			t.Errorf("%q: got pubdate %s, want %s", test.title, pr.PubDate, want)
+		}
+	}
+
+	spec.TitleSuffix = "("
+	if err := spec.Scrape("titles", &PressRelease{Permalink: "http://example.com/1"}, `<h1>Title</h1><div class="body">Body</div>`); !errors.Is(err, ErrParse) {
+		t.Errorf("bad suffix: got %v, want %v", err, ErrParse)
+	}
+}
+
+// Dates without a zone are UK time, and everything comes out as UTC.
+func TestPubDateUTC(t *testing.T) {
+	for _, test := range []struct {
+		raw  string
+		want time.Time
+	}{
+		// (BST in the summer, so 10:00 is 09:00 UTC)
+		{"Posted 12 June 2014 10:00", time.Date(2014, 6, 12, 9, 0, 0, 0, time.UTC)},
+		{"12 March 2014 10:00", time.Date(2014, 3, 12, 10, 0, 0, 0, time.UTC)},
+		// (midnight UK time)
+		{"1st July 2014", time.Date(2014, 6, 30, 23, 0, 0, 0, time.UTC)},
+		{"2014-06-12T10:00:00+02:00", time.Date(2014, 6, 12, 8, 0, 0, 0, time.UTC)},
+		// either side of the clocks going forward (at 01:00 UTC on 30
+		// March 2014)
+		{"30 March 2014 00:30", time.Date(2014, 3, 30, 0, 30, 0, 0, time.UTC)},
+		{"30 March 2014 03:30", time.Date(2014, 3, 30, 2, 30, 0, 0, time.UTC)},
+	} {
+		got, err := parsePubDate(test.raw)
+		if err != nil {
+			t.Errorf("%q: %s", test.raw, err)
+			continue
+		}
+		if !got.Equal(test.want) || got.Location() != time.UTC {
+			t.Errorf("%q: got %s, want %s", test.raw, got, test.want)
+		}
+	}
+}
+
+func TestAuthor(t *testing.T) {
+	const (
+		metas  = `<meta name="author" content="Press Office"><meta property="article:author" content="https://facebook.com/tesco">`
+		byline = `<p class="byline">  Jane   Smith, Head of PR </p>`
+	)
+	for _, test := range []struct {
+		selector, head, body, want string
+	}{
+		{".byline", metas, byline, "Jane Smith, Head of PR"},
+		// (the article:author is a url, so it's skipped)
+		{"", metas, byline, "Press Office"},
+		{".byline", `<meta property="article:author" content="Bob Jones">` + metas, "", "Bob Jones"},
+		{".byline", "", `<p class="byline"> </p>`, ""},
+	} {
+		spec := ScrapeSpec{Title: []string{"h1"}, Content: []string{".body"}, Author: test.selector}
+		pr := &PressRelease{Permalink: "http://example.com/1"}
+		page := "<html><head>" + test.head + "</head><body><h1>Title</h1>" + test.body + `<div class="body"><p>Words.</p></div></body></html>`
+		if err := spec.Scrape("author", pr, page); err != nil {
+			t.Fatal(err)
+		}
+		if pr.Author != test.want {
+			t.Errorf("%q %s %s: got %q, want %q", test.selector, test.head, test.body, pr.Author, test.want)
+		}
+	}
+}
+
+func TestOutageMarkers(t *testing.T) {
+	const (
+		outage = `<html><body><div class="banner error">Sorry, something went wrong</div><ul class="news"></ul></body></html>`
+		normal = `<html><body><ul class="news"><li><a href="/1">One</a></li></ul><div class="banner error">Old banner</div></body></html>`
+		empty  = `<html><body><ul class="news"></ul></body></html>`
+	)
+	var page string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"1"`)
+		fmt.Fprint(w, page)
+	}))
+	defer srv.Close()
+	for i, test := range []struct {
+		page    string
+		markers OutageMarkers
+		err     error
+		links   int
+	}{
+		{outage, OutageMarkers{Selector: ".error"}, errProbableOutage, 0},
+		{outage, OutageMarkers{Text: "(?i)something went WRONG"}, errProbableOutage, 0},
+		{outage, OutageMarkers{}, nil, 0},
+		// (the markers only count if there aren't any links)
+		{normal, OutageMarkers{Selector: ".error"}, nil, 1},
+		{empty, OutageMarkers{Selector: ".error", Text: "wrong"}, nil, 0},
+		// (a bad pattern is logged and never matches, rather than panicking)
+		{outage, OutageMarkers{Text: "(wrong"}, nil, 0},
+	} {
+		page = test.page
+		listURL := fmt.Sprintf("%s/list/%d", srv.URL, i)
+		prs, err := GenericFetchListChecked("outage", listURL, ".news a", test.markers)
+		if err != test.err || len(prs) != test.links {
+			t.Errorf("%d: got %d links (%v), want %d (%v)", i, len(prs), err, test.links, test.err)
+		}
+		// (an outage page's ETag isn't kept, so the real list is fetched
+		// in full next time)
+		validators.Lock()
+		_, kept := validators.urls[listURL]
+		validators.Unlock()
+		if kept != (test.err == nil) {
+			t.Errorf("%d: validators kept: %v", i, kept)
+		}
+	}
+
+	bad := &ConfigScraper{ScraperName: "outage", URL: "http://example.com/", Links: "a", Title: selectorList{"h1"}, Content: selectorList{"p"}, OutageText: "("}
+	if bad.Validate() == nil {
+		t.Errorf("no error for a bad outage_text")
+	}
+}
+
+// cruftPage has a share bar, a related articles box and an ad in the content
+const cruftPage = `<html><body><h1>Title</h1><div class="body"><p>Keep this.</p><div class="share-buttons">Tweet</div><aside class="related">Related articles</aside><div class="ad">Buy now</div><p>And this.</p></div></body></html>`
+
+func TestCruftList(t *testing.T) {
+	spec := ScrapeSpec{Title: []string{"h1"}, Content: []string{".body"}, Cruft: []string{".share-buttons", ".related", ".ad"}}
+	pr := &PressRelease{Permalink: "http://example.com/1"}
+	if err := spec.Scrape("cruft", pr, cruftPage); err != nil {
+		t.Fatal(err)
+	}
+	for _, junk := range []string{"Tweet", "Related articles", "Buy now"} {
+		if strings.Contains(pr.Content, junk) {
+			t.Errorf("%q left in %s", junk, pr.Content)
+		}
+	}
+	if !strings.Contains(pr.Content, "Keep this.") || !strings.Contains(pr.Content, "And this.") {
+		t.Errorf("lost the content: %s", pr.Content)
+	}
+
+	// (a single string still does, selector group and all)
+	pr = &PressRelease{Permalink: "http://example.com/1"}
+	if err := GenericScrape("cruft", pr, cruftPage, []string{"h1"}, []string{".body"}, ".ad, .related", nil); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(pr.Content, "Buy now") || strings.Contains(pr.Content, "Related articles") || !strings.Contains(pr.Content, "Tweet") {
+		t.Errorf("got %s", pr.Content)
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	words := strings.Repeat("word ", 39) // (195 runes)
+	for _, test := range []struct {
+		text, want string
+	}{
+		{"  short   text ", "short text"},
+		// (cut back to the end of the last whole word)
+		{words + "abcdefghij more", strings.TrimSpace(words) + "…"},
+		{words + "abcd, efghij", words + "abcd…"},
+		{words + "abcd efghij", words + "abcd…"},
+		{strings.Repeat("x", 250), strings.Repeat("x", excerptLength) + "…"},
+		{"", ""},
+	} {
+		if got := excerpt(test.text); got != test.want {
+			t.Errorf("%.20q...: got %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestFindExcerpt(t *testing.T) {
+	const (
+		description   = `<meta name="description" content=" The  summary ">`
+		ogDescription = `<meta property="og:description" content="The og summary">`
+	)
+	for _, test := range []struct {
+		head, want string
+	}{
+		{ogDescription + description, "The summary"},
+		{ogDescription, "The og summary"},
+		{`<meta name="description" content=" ">`, "Words from the body."},
+		{"", "Words from the body."},
+	} {
+		spec := ScrapeSpec{Title: []string{"h1"}, Content: []string{".body"}}
+		pr := &PressRelease{Permalink: "http://example.com/1"}
+		page := "<html><head>" + test.head + `</head><body><h1>Title</h1><div class="body"><p>Words from the body.</p></div></body></html>`
+		if err := spec.Scrape("excerpt", pr, page); err != nil {
+			t.Fatal(err)
+		}
+		if pr.Excerpt != test.want {
+			t.Errorf("%s: got %q, want %q", test.head, pr.Excerpt, test.want)
+		}
+	}
+}
+
+func TestNormalizePermalink(t *testing.T) {
+	for _, test := range []struct {
+		raw, want string
+	}{
+		{"http://example.com/news/1?utm_source=tw&utm_medium=social", "http://example.com/news/1"},
+		{"http://example.com/news?id=42&utm_campaign=spring&ref=home", "http://example.com/news?id=42"},
+		{"http://example.com/news?UTM_Source=a&id=42&page=2#comments", "http://example.com/news?id=42&page=2"},
+		{"http://example.com/a#top", "http://example.com/a"},
+		{"http://example.com/a?", "http://example.com/a"},
+		{"http://example.com/Detail.aspx?ReleaseID=2301&NewsAreaId=2&fbclid=zz", "http://example.com/Detail.aspx?ReleaseID=2301&NewsAreaId=2"},
+		{"http://example.com/a?q=a%20b&jsessionid=123", "http://example.com/a?q=a%20b"},
+		// (only whole names, or prefixes where there's a *)
+		{"http://example.com/a?referrer=x", "http://example.com/a?referrer=x"},
+	} {
+		if got := normalizePermalink(test.raw); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.raw, got, test.want)
+		}
+	}
+
+	old := *stripParams
+	*stripParams = "sid, track*"
+	defer func() { *stripParams = old }()
+	if got := normalizePermalink("http://example.com/a?sid=1&tracking=2&utm_source=3"); got != "http://example.com/a?utm_source=3" {
+		t.Errorf("with -strip-params %q: got %s", *stripParams, got)
+	}
+}
+
+// The same release linked with different tracking params is only listed
+// once.
+func TestFetchListStripsParams(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `<div class="news">
+<a href="/news?id=1&utm_source=home">One</a>
+<a href="/news?id=1&utm_source=sidebar#more">One again</a>
+<a href="/news?id=2&ref=rss">Two</a>
+</div>`)
+	}))
+	defer srv.Close()
+	docs, err := GenericFetchList("stripparams", srv.URL+"/", ".news a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, pr := range docs {
+		got = append(got, pr.Permalink)
+	}
+	want := []string{srv.URL + "/news?id=1", srv.URL + "/news?id=2"}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+// watermarkServer serves a date-ordered index at /news, and a date-ordered
+// archive at /page/<n> (counting the archive pages fetched in pageHits)
+func watermarkServer(pageHits *int32) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			http.NotFound(w, r)
+		case "/news":
+			fmt.Fprint(w, `<ul>
+<li><a class="pr" href="/a">A</a> <span class="date">14 March 2024</span></li>
+<li><a class="pr" href="/b">B</a> <span class="date">12 March 2024</span></li>
+<li><a class="pr" href="/c">C</a> <span class="date">12 March 2024</span></li>
+<li><a class="pr" href="/d">D</a> <span class="date">11 March 2024</span></li>
+<li><a class="pr" href="/e">E</a> <span class="date">2 March 2024</span></li>
+</ul>`)
+		case "/page/1":
+			atomic.AddInt32(pageHits, 1)
+			fmt.Fprint(w, `<li><a class="pr" href="/p1">x</a><span class="date">20 March 2024</span></li><li><a class="pr" href="/p2">x</a><span class="date">19 March 2024</span></li>`)
+		case "/page/2":
+			atomic.AddInt32(pageHits, 1)
+			fmt.Fprint(w, `<li><a class="pr" href="/p3">x</a><span class="date">18 March 2024</span></li><li><a class="pr" href="/p4">x</a><span class="date">1 March 2024</span></li>`)
+		default:
+			atomic.AddInt32(pageHits, 1)
+			fmt.Fprint(w, `<li><a class="pr" href="/p9">x</a><span class="date">1 Feb 2024</span></li>`)
+		}
+	}))
+}
+
+func TestWatermark(t *testing.T) {
+	var pageHits int32
+	srv := watermarkServer(&pageHits)
+	defer srv.Close()
+	defer func(old time.Duration) { limiter.delay = old }(limiter.delay)
+	limiter.delay = 0
+	links := func(prs []*PressRelease) string {
+		var got []string
+		for _, pr := range prs {
+			got = append(got, strings.TrimPrefix(pr.Permalink, srv.URL))
+		}
+		return strings.Join(got, " ")
+	}
+
+	// (the newest stored is from 3pm on the 12th - the others from the
+	// same day are still listed)
+	since := time.Date(2024, 3, 12, 15, 0, 0, 0, time.UTC)
+	for _, test := range []struct {
+		since time.Time
+		want  string
+	}{
+		{since, "/a /b /c"},
+		{time.Time{}, "/a /b /c /d /e"},
+	} {
+		// (a fresh url each time, so the conditional GET doesn't get a 304)
+		prs, err := GenericFetchListSince("watermark", fmt.Sprintf("%s/news?since=%d", srv.URL, test.since.Unix()), "a.pr", ".date", test.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := links(prs); got != test.want {
+			t.Errorf("since %s: got %s, want %s", test.since, got, test.want)
+		}
+	}
+
+	// no more pages are fetched once it's reached
+	prs, err := GenericFetchListPagedSince("watermark", srv.URL+"/page/%d", "a.pr", ".date", 10, time.Date(2024, 3, 5, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := links(prs); got != "/p1 /p2 /p3" {
+		t.Errorf("paged: got %s, want /p1 /p2 /p3", got)
+	}
+	if n := atomic.LoadInt32(&pageHits); n != 2 {
+		t.Errorf("fetched %d pages, want 2", n)
+	}
+	prs, err = GenericFetchListPaged("watermark", srv.URL+"/page/%d", "a.pr", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := links(prs); got != "/p1 /p2 /p3 /p4 /p9" {
+		t.Errorf("without a watermark: got %s", got)
+	}
+}
@@ -14,14 +14,25 @@ func (scraper *SeventyTwoPointScraper) Name() string {
 	return "72point"
 }
 
+func (scraper *SeventyTwoPointScraper) Meta() ScraperMeta {
+	return ScraperMeta{DisplayName: "72 Point", BaseURL: "http://www.72point.com/"}
+}
+
+// url template for the paginated 72point archives
+const seventyTwoPointPages = "http://www.72point.com/coverage/page/%d/"
+
+// selector for the press release links, on the index and archive pages
+const seventyTwoPointLinks = ".items .item .content .links a"
+
 // fetches a list of latest press releases from 72point
 func (scraper *SeventyTwoPointScraper) FetchList() ([]*PressRelease, error) {
-	// (could also access archives, about 160 pages
-	// eg    http://www.72point.com/coverage/page/2/)
-
 	url := "http://www.72point.com/coverage/"
-	sel := ".items .item .content .links a"
-	return GenericFetchList(scraper.Name(), url, sel)
+	return GenericFetchList(scraper.Name(), url, seventyTwoPointLinks)
+}
+
+// fetches a page of the 72point archives (about 160 pages of them)
+func (scraper *SeventyTwoPointScraper) FetchArchivePage(page int) ([]*PressRelease, error) {
+	return GenericFetchArchivePage(scraper.Name(), seventyTwoPointPages, seventyTwoPointLinks, page)
 }
 
 func (scraper *SeventyTwoPointScraper) Scrape(pr *PressRelease, raw_html string) error {
@@ -30,5 +41,5 @@ func (scraper *SeventyTwoPointScraper) Scrape(pr *PressRelease, raw_html string)
 	cruft := ".addthis_toolbox"
 	pubDate := "#content .item .meta"
 
-	return GenericScrape(scraper.Name(), pr, raw_html, title, content, cruft, pubDate)
+	return GenericScrape(scraper.Name(), pr, raw_html, []string{title}, []string{content}, cruft, []string{pubDate})
 }
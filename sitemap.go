@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+)
+
+// sitemap indexes (which point to other sitemaps, rather than pages) are
+// only followed this deep
+const maxSitemapDepth = 3
+
+// sitemapDoc covers both an ordinary sitemap (<urlset>) and a sitemap index
+// (<sitemapindex>), see sitemaps.org
+type sitemapDoc struct {
+	URLs []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// SitemapFetchList fetches a list of press releases from an xml sitemap,
+// for sites where that's more reliable than picking links off an index
+// page. Sitemap indexes are followed (up to maxSitemapDepth deep).
+// Only urls matching urlFilter (a regexp, or just a substring) are
+// returned - an empty filter lets everything through.
+func SitemapFetchList(scraperName, sitemapURL, urlFilter string) ([]*PressRelease, error) {
+	var filter *regexp.Regexp
+	if urlFilter != "" {
+		var err error
+		filter, err = regexp.Compile(urlFilter)
+		if err != nil {
+			return nil, err
+		}
+	}
+	docs := make([]*PressRelease, 0)
+	seen := make(map[string]bool)
+	visited := make(map[string]bool)
+	var walk func(sitemapURL string, depth int) error
+	walk = func(sitemapURL string, depth int) error {
+		if visited[sitemapURL] {
+			return nil
+		}
+		visited[sitemapURL] = true
+		sitemap, base, err := fetchSitemap(sitemapURL)
+		if err != nil {
+			return err
+		}
+		for _, sm := range sitemap.Sitemaps {
+			link, err := resolveLink(base, sm.Loc)
+			if err != nil {
+				debugf("%s: skipping sitemap in %s: %s", scraperName, sitemapURL, err)
+				continue
+			}
+			if depth >= maxSitemapDepth {
+				warnf("%s: sitemaps nested too deep, skipping %s", scraperName, link)
+				continue
+			}
+			err = walk(link, depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		for _, u := range sitemap.URLs {
+			link, err := resolveLink(base, u.Loc)
+			if err != nil {
+				debugf("%s: skipping url in %s: %s", scraperName, sitemapURL, err)
+				continue
+			}
+			if seen[link] || (filter != nil && !filter.MatchString(link)) {
+				continue
+			}
+			seen[link] = true
+			docs = append(docs, &PressRelease{Source: scraperName, Permalink: link})
+		}
+		return nil
+	}
+	err := walk(sitemapURL, 0)
+	if err != nil {
+		return nil, err
+	}
+	return docs, nil
+}
+
+// fetchSitemap fetches and parses a single sitemap (which may be gzipped,
+// as sitemap.xml.gz files often are). Also returns the url it ended up at,
+// for resolving any relative urls against.
+func fetchSitemap(sitemapURL string) (*sitemapDoc, *url.URL, error) {
+	allowed, err := robotsAllowed(sitemapURL, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !allowed {
+		return nil, nil, errDisallowed
+	}
+	resp, err := politeGet(httpClient, sitemapURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, nil, &statusError{sitemapURL, resp.StatusCode}
+	}
+
+	// (a .gz file is served up as-is, rather than with a Content-Encoding)
+	var body io.Reader = bufio.NewReader(resp.Body)
+	if magic, err := body.(*bufio.Reader).Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		body, err = gzip.NewReader(body)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	var sitemap sitemapDoc
+	// (sitemaps have to be utf-8, so no need for utf8Body)
+	err = xml.NewDecoder(body).Decode(&sitemap)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %s", sitemapURL, err)
+	}
+	return &sitemap, resp.Request.URL, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSitemapFetchList(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/sitemap.xml":
+			// (an index, which also lists itself)
+			fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>%s/news.xml</loc></sitemap>
+  <sitemap><loc>/more.xml.gz</loc></sitemap>
+  <sitemap><loc>%s/sitemap.xml</loc></sitemap>
+</sitemapindex>`, srv.URL, srv.URL)
+		case "/news.xml":
+			fmt.Fprintf(w, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc> %s/press/one </loc><lastmod>2014-01-01</lastmod></url>
+  <url><loc>%s/about</loc></url>
+  <url><loc>%s/press/one</loc></url>
+  <url><loc>%s/press/2014/three</loc></url>
+</urlset>`, srv.URL, srv.URL, srv.URL, srv.URL)
+		case "/more.xml.gz":
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			fmt.Fprint(gz, `<urlset><url><loc>/press/two</loc></url><url><loc>mailto:press@example.com</loc></url></urlset>`)
+			gz.Close()
+			w.Write(buf.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for _, test := range []struct {
+		filter string
+		want   []string
+	}{
+		{"/press/", []string{"/press/one", "/press/2014/three", "/press/two"}},
+		{`/press/\d+/`, []string{"/press/2014/three"}},
+		{"", []string{"/press/one", "/about", "/press/2014/three", "/press/two"}},
+	} {
+		prs, err := SitemapFetchList("sitemap", srv.URL+"/sitemap.xml", test.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, pr := range prs {
+			if pr.Source != "sitemap" || pr.complete {
+				t.Errorf("%q: got %+v", test.filter, pr)
+			}
+			got = append(got, strings.TrimPrefix(pr.Permalink, srv.URL))
+		}
+		if fmt.Sprint(got) != fmt.Sprint(test.want) {
+			t.Errorf("%q: got %v, want %v", test.filter, got, test.want)
+		}
+	}
+
+	for _, test := range []struct{ path, filter string }{
+		{"/missing.xml", ""},
+		{"/sitemap.xml", "("},
+	} {
+		if _, err := SitemapFetchList("sitemap", srv.URL+test.path, test.filter); err == nil {
+			t.Errorf("%s %q: no error", test.path, test.filter)
+		}
+	}
+}
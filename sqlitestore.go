@@ -0,0 +1,772 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	_ "github.com/mattn/go-sqlite3"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLiteStore is a Store which keeps the press releases in a sqlite db.
+type SQLiteStore struct {
+	db *sql.DB
+	// set if sqlite was built with FTS5, so Search works
+	// (go-sqlite3 needs the sqlite_fts5 build tag)
+	fts bool
+}
+
+// the columns needed to fill out a PressRelease, as read by scanPressRelease
+const pressReleaseColumns = "title,source,permalink,urls,final_url,canonical_url,pubdate,content,text,notes,image_url,lang,author,excerpt,tags,content_hash,last_modified,raw_html_path,simhash,id"
+
+// scanner is satisfied by both sql.Row and sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanPressRelease reads in a PressRelease from a row of pressReleaseColumns
+func scanPressRelease(row scanner) (*PressRelease, error) {
+	var pr PressRelease
+	var urls, tags string
+	var lastModified sql.NullTime
+	var hash int64
+	err := row.Scan(&pr.Title, &pr.Source, &pr.Permalink, &urls, &pr.FinalURL, &pr.CanonicalURL, &pr.PubDate, &pr.Content, &pr.Text, &pr.Notes, &pr.ImageURL, &pr.Lang, &pr.Author, &pr.Excerpt, &tags, &pr.ContentHash, &lastModified, &pr.RawHTMLPath, &hash, &pr.id)
+	if err != nil {
+		return nil, err
+	}
+	pr.LastModified = lastModified.Time
+	// (older rows can have local times)
+	pr.PubDate = pr.PubDate.UTC()
+	pr.simhash = uint64(hash)
+	// (rows from before excerpts were kept)
+	if pr.Excerpt == "" {
+		pr.Excerpt = excerpt(pr.Text)
+	}
+	if urls != "" {
+		err = json.Unmarshal([]byte(urls), &pr.URLs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if tags != "" {
+		err = json.Unmarshal([]byte(tags), &pr.Tags)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pr.complete = true
+	return &pr, nil
+}
+
+// how long a query waits for a lock held by another connection (eg a
+// long-running write) before failing with "database is locked"
+const sqliteBusyTimeout = 5 * time.Second
+
+// NewSQLiteStore opens (or creates) the sqlite db in dbfile.
+// It's put in WAL mode, so reads (eg by the api and browse pages) and the
+// scrapers' writes don't block each other, and every connection gets a
+// busy timeout, for the writes which do have to wait their turn.
+func NewSQLiteStore(dbfile string) (*SQLiteStore, error) {
+	store := new(SQLiteStore)
+	dsn := fmt.Sprintf("%s?_journal_mode=WAL&_busy_timeout=%d", dbfile, sqliteBusyTimeout.Milliseconds())
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	store.db = db
+
+	err = store.migrate()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	err = store.setupSearch()
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// setupSearch creates the full-text index, if it's not already there, and
+// fills it in for any existing press releases.
+// If FTS5 isn't available, searching is just disabled.
+func (store *SQLiteStore) setupSearch() error {
+	// title and plaintext content, with rowids matching press_release ids
+	_, err := store.db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS press_release_fts USING fts5(title, content)`)
+	if err != nil {
+		if strings.Contains(err.Error(), "no such module") {
+			warnf("sqlite built without fts5 - search disabled")
+			return nil
+		}
+		return err
+	}
+	store.fts = true
+	_, err = store.db.Exec(`CREATE TRIGGER IF NOT EXISTS press_release_fts_delete AFTER DELETE ON press_release BEGIN
+         DELETE FROM press_release_fts WHERE rowid=old.id;
+         END`)
+	if err != nil {
+		return err
+	}
+
+	var n int
+	err = store.db.QueryRow("SELECT COUNT(*) FROM press_release_fts").Scan(&n)
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		return nil
+	}
+	// (read everything in first - sqlite won't let us write while a read
+	// is underway)
+	type unindexed struct {
+		id             int64
+		title, content string
+	}
+	var todo []unindexed
+	rows, err := store.db.Query("SELECT id,title,content FROM press_release")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var u unindexed
+		err = rows.Scan(&u.id, &u.title, &u.content)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		todo = append(todo, u)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	if len(todo) == 0 {
+		return nil
+	}
+
+	tx, err := store.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, u := range todo {
+		err = indexPressRelease(tx, u.id, u.title, u.content)
+		if err != nil {
+			return err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	infof("indexed %d existing press releases for searching", len(todo))
+	return nil
+}
+
+// indexPressRelease adds a press release to the full-text index
+func indexPressRelease(tx *sql.Tx, id int64, title, content string) error {
+	_, err := tx.Exec("INSERT INTO press_release_fts (rowid,title,content) VALUES ($1,$2,$3)", id, title, plainText(content))
+	return err
+}
+
+// max number of press releases WhichAreNew checks per query (each one
+// can need three params, and sqlite has a limit of 999)
+const whichAreNewBatch = 300
+
+// returns a list of press releases with the ones already in the store culled out
+// Both the permalink and final (post-redirect) url are considered, as is the
+// content hash, if set (to catch the same content republished under a new url).
+func (store *SQLiteStore) WhichAreNew(incoming []*PressRelease) ([]*PressRelease, error) {
+	// find all the matching press releases already in the db
+	urls := make(map[string]bool)   // "source url"
+	hashes := make(map[string]bool) // "source hash"
+	for start := 0; start < len(incoming); start += whichAreNewBatch {
+		end := start + whichAreNewBatch
+		if end > len(incoming) {
+			end = len(incoming)
+		}
+		err := store.findExisting(incoming[start:end], urls, hashes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var unseen []*PressRelease
+	for _, pr := range incoming {
+		seen := false
+		for _, u := range pr.aliases() {
+			seen = seen || urls[pr.Source+" "+u]
+		}
+		if seen {
+			continue
+		}
+		if pr.ContentHash != "" && hashes[pr.Source+" "+pr.ContentHash] {
+			continue
+		}
+		unseen = append(unseen, pr)
+	}
+	if *dedupFlag > 0 {
+		return store.cullNearDuplicates(unseen)
+	}
+	return unseen, nil
+}
+
+// cullNearDuplicates takes out the press releases which look like
+// near-duplicates of recent ones in the store (see -dedup), setting their
+// duplicateOf.
+func (store *SQLiteStore) cullNearDuplicates(prs []*PressRelease) ([]*PressRelease, error) {
+	recent := make(map[string][]storedSimhash) // by source
+	var unseen []*PressRelease
+	for _, pr := range prs {
+		if pr.simhash == 0 {
+			unseen = append(unseen, pr)
+			continue
+		}
+		hashes, ok := recent[pr.Source]
+		if !ok {
+			var err error
+			hashes, err = store.recentSimhashes(pr.Source)
+			if err != nil {
+				return nil, err
+			}
+			recent[pr.Source] = hashes
+		}
+		for _, h := range hashes {
+			if nearDuplicate(pr.simhash, h.simhash) {
+				pr.duplicateOf = h.id
+				break
+			}
+		}
+		if pr.duplicateOf == 0 {
+			unseen = append(unseen, pr)
+		}
+	}
+	return unseen, nil
+}
+
+// storedSimhash is the simhash of a stored press release
+type storedSimhash struct {
+	id      int
+	simhash uint64
+}
+
+// recentSimhashes returns the simhashes of the most recently stashed press
+// releases for a source, up to dedupWindow of them
+func (store *SQLiteStore) recentSimhashes(source string) ([]storedSimhash, error) {
+	rows, err := store.db.Query("SELECT id,simhash FROM press_release WHERE source=$1 AND simhash!=0 ORDER BY id DESC LIMIT $2", source, dedupWindow)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []storedSimhash
+	for rows.Next() {
+		var id int
+		var hash int64
+		if err := rows.Scan(&id, &hash); err != nil {
+			return nil, err
+		}
+		out = append(out, storedSimhash{id, uint64(hash)})
+	}
+	return out, rows.Err()
+}
+
+// LinkDuplicate records url as a near-duplicate of the stored press
+// release id, in press_release_duplicate (which findExisting checks).
+func (store *SQLiteStore) LinkDuplicate(id int, url string) error {
+	res, err := store.db.Exec("INSERT INTO press_release_duplicate (release_id,url) SELECT id,$1 FROM press_release WHERE id=$2", url, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = errNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// findExisting looks up stored press releases matching any of prs by url or
+// content hash, and adds their urls and hashes (prefixed by source) to the
+// urls and hashes sets.
+func (store *SQLiteStore) findExisting(prs []*PressRelease, urls, hashes map[string]bool) error {
+	// (?NNN rather than $NNN params, as the urls are used twice and sqlite
+	// numbers $ params in order of appearance)
+	var args []interface{}
+	var urlParams, hashParams []string
+	for _, pr := range prs {
+		for _, u := range pr.aliases() {
+			args = append(args, u)
+			urlParams = append(urlParams, fmt.Sprintf("?%d", len(args)))
+		}
+		if pr.ContentHash != "" {
+			args = append(args, pr.ContentHash)
+			hashParams = append(hashParams, fmt.Sprintf("?%d", len(args)))
+		}
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	var conds []string
+	if len(urlParams) > 0 {
+		in := strings.Join(urlParams, ",")
+		conds = append(conds, "permalink IN ("+in+")", "final_url IN ("+in+")", "canonical_url IN ("+in+")")
+	}
+	if len(hashParams) > 0 {
+		conds = append(conds, "content_hash IN ("+strings.Join(hashParams, ",")+")")
+	}
+
+	rows, err := store.db.Query("SELECT source,permalink,final_url,canonical_url,content_hash FROM press_release WHERE "+strings.Join(conds, " OR "), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var source, permalink, finalURL, canonicalURL, hash string
+		err := rows.Scan(&source, &permalink, &finalURL, &canonicalURL, &hash)
+		if err != nil {
+			return err
+		}
+		urls[source+" "+permalink] = true
+		for _, u := range []string{finalURL, canonicalURL} {
+			if u != "" {
+				urls[source+" "+u] = true
+			}
+		}
+		if hash != "" {
+			hashes[source+" "+hash] = true
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	// and any near-duplicates linked to them (see LinkDuplicate)
+	if len(urlParams) == 0 {
+		return nil
+	}
+	rows, err = store.db.Query("SELECT r.source,d.url FROM press_release_duplicate d JOIN press_release r ON r.id=d.release_id WHERE d.url IN ("+strings.Join(urlParams, ",")+")", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var source, u string
+		if err := rows.Scan(&source, &u); err != nil {
+			return err
+		}
+		urls[source+" "+u] = true
+	}
+	return rows.Err()
+}
+
+// jsonLists encodes the extra urls and tags of a press release, as they're
+// kept in the db (empty lists as empty strings)
+func jsonLists(pr *PressRelease) (urls, tags string, err error) {
+	if len(pr.URLs) > 0 {
+		out, err := json.Marshal(pr.URLs)
+		if err != nil {
+			return "", "", err
+		}
+		urls = string(out)
+	}
+	if len(pr.Tags) > 0 {
+		out, err := json.Marshal(pr.Tags)
+		if err != nil {
+			return "", "", err
+		}
+		tags = string(out)
+	}
+	return urls, tags, nil
+}
+
+// Stash adds a press release into the store
+// Any extra urls, and the tags, are kept as json lists.
+// It's a single insert, which does nothing if there's already a press
+// release with the permalink (going by the unique index) or the content
+// hash, so two runs racing to stash the same one can't both manage it.
+func (store *SQLiteStore) Stash(pr *PressRelease) (*pressReleaseEvent, error) {
+	urls, tags, err := jsonLists(pr)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT INTO press_release (title,source,permalink,urls,final_url,canonical_url,pubdate,content,text,notes,image_url,lang,author,excerpt,tags,content_hash,raw_html_path,simhash,stashed)
+         SELECT $1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16,$17,$18,$19
+         WHERE $16='' OR NOT EXISTS (SELECT 1 FROM press_release WHERE source=$2 AND content_hash=$16)
+         ON CONFLICT DO NOTHING`, pr.Title, pr.Source, pr.Permalink, urls, pr.FinalURL, pr.CanonicalURL, pr.PubDate.UTC(), pr.Content, pr.Text, pr.Notes, pr.ImageURL, pr.Lang, pr.Author, pr.Excerpt, tags, pr.ContentHash, pr.RawHTMLPath, int64(pr.simhash), time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, errAlreadyStashed
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if store.fts {
+		err = indexPressRelease(tx, id, pr.Title, pr.Content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return &pressReleaseEvent{payload: pr, id: int(id)}, nil
+}
+
+// Lookup finds the stored press release for a url (its permalink, where
+// that ended up, or its canonical url).
+func (store *SQLiteStore) Lookup(source, url string) (*pressReleaseEvent, error) {
+	var id int
+	err := store.db.QueryRow("SELECT id FROM press_release WHERE source=$1 AND (permalink=$2 OR final_url=$2 OR canonical_url=$2) ORDER BY id DESC LIMIT 1", source, url).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	pr, err := store.Get(source, strconv.Itoa(id))
+	if err != nil {
+		return nil, err
+	}
+	return &pressReleaseEvent{payload: pr, id: id}, nil
+}
+
+// Update replaces a stored press release with a newer revision of it. The
+// old one is copied over to press_release_revision first.
+func (store *SQLiteStore) Update(id int, pr *PressRelease) (*pressReleaseEvent, error) {
+	urls, tags, err := jsonLists(pr)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := store.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	now := time.Now().UTC()
+	res, err := tx.Exec(`INSERT INTO press_release_revision (release_id,title,urls,final_url,content,text,notes,image_url,lang,author,excerpt,tags,content_hash,last_modified,raw_html_path,replaced)
+         SELECT id,title,urls,final_url,content,text,notes,image_url,lang,author,excerpt,tags,content_hash,last_modified,raw_html_path,$1 FROM press_release WHERE id=$2`, now, id)
+	if err != nil {
+		return nil, err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		if err == nil {
+			err = errNotFound
+		}
+		return nil, err
+	}
+	_, err = tx.Exec(`UPDATE press_release SET title=$1,urls=$2,final_url=$3,content=$4,text=$5,notes=$6,image_url=$7,lang=$8,tags=$9,content_hash=$10,raw_html_path=$11,last_modified=$12,canonical_url=$13,simhash=$14,author=$15,excerpt=$16 WHERE id=$17`,
+		pr.Title, urls, pr.FinalURL, pr.Content, pr.Text, pr.Notes, pr.ImageURL, pr.Lang, tags, pr.ContentHash, pr.RawHTMLPath, now, pr.CanonicalURL, int64(pr.simhash), pr.Author, pr.Excerpt, id)
+	if err != nil {
+		return nil, err
+	}
+	if store.fts {
+		_, err = tx.Exec("DELETE FROM press_release_fts WHERE rowid=$1", id)
+		if err != nil {
+			return nil, err
+		}
+		err = indexPressRelease(tx, int64(id), pr.Title, pr.Content)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	pr.LastModified = now
+	return &pressReleaseEvent{payload: pr, id: id, updated: true}, nil
+}
+
+// Query fetches press releases from the store, most recently stashed first.
+func (store *SQLiteStore) Query(opts QueryOptions) ([]*PressRelease, error) {
+	return store.query("", opts)
+}
+
+// Search fetches press releases matching a search query (see
+// parseSearchQuery), most recently stashed first.
+func (store *SQLiteStore) Search(q string, opts QueryOptions) ([]*PressRelease, error) {
+	if !store.fts {
+		return nil, errNoSearch
+	}
+	terms := parseSearchQuery(q)
+	if len(terms) == 0 {
+		return []*PressRelease{}, nil
+	}
+	// quote everything, so fts5 query syntax in the search doesn't cause
+	// errors
+	quoted := make([]string, len(terms))
+	for i, term := range terms {
+		quoted[i] = `"` + strings.Replace(term, `"`, `""`, -1) + `"`
+	}
+	return store.query(strings.Join(quoted, " "), opts)
+}
+
+// query does the work for Query and Search. If match is set, only press
+// releases matching it in the full-text index are returned.
+func (store *SQLiteStore) query(match string, opts QueryOptions) ([]*PressRelease, error) {
+	where, args := queryFilter(match, opts)
+	q := "SELECT " + pressReleaseColumns + " FROM press_release" + where
+	q += " ORDER BY id DESC"
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit)
+		q += fmt.Sprintf(" LIMIT $%d", len(args))
+		if opts.Offset > 0 {
+			args = append(args, opts.Offset)
+			q += fmt.Sprintf(" OFFSET $%d", len(args))
+		}
+	}
+
+	rows, err := store.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []*PressRelease{}
+	for rows.Next() {
+		pr, err := scanPressRelease(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, pr)
+	}
+	return out, rows.Err()
+}
+
+// Count returns the number of press releases which Query would return for
+// opts (ignoring Limit and Offset).
+func (store *SQLiteStore) Count(opts QueryOptions) (int, error) {
+	where, args := queryFilter("", opts)
+	var n int
+	err := store.db.QueryRow("SELECT COUNT(*) FROM press_release"+where, args...).Scan(&n)
+	return n, err
+}
+
+// queryFilter builds the WHERE clause (if any) for the press releases
+// picked out by opts and match (see query), along with its args.
+func queryFilter(match string, opts QueryOptions) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if match != "" {
+		args = append(args, match)
+		conds = append(conds, fmt.Sprintf("id IN (SELECT rowid FROM press_release_fts WHERE press_release_fts MATCH $%d)", len(args)))
+	}
+	if opts.Source != "" {
+		args = append(args, opts.Source)
+		conds = append(conds, fmt.Sprintf("source=$%d", len(args)))
+	}
+	if opts.Lang != "" {
+		args = append(args, opts.Lang)
+		conds = append(conds, fmt.Sprintf("lang=$%d", len(args)))
+	}
+	if opts.Tag != "" {
+		// (NULLIF, as json_each chokes on the empty string)
+		args = append(args, opts.Tag)
+		conds = append(conds, fmt.Sprintf("EXISTS (SELECT 1 FROM json_each(NULLIF(tags,'')) WHERE value=$%d COLLATE NOCASE)", len(args)))
+	}
+	if opts.AfterID > 0 {
+		args = append(args, opts.AfterID)
+		conds = append(conds, fmt.Sprintf("id<$%d", len(args)))
+	}
+	if !opts.Since.IsZero() {
+		// julianday() copes with the timezone offsets on stored times
+		args = append(args, opts.Since)
+		conds = append(conds, fmt.Sprintf("julianday(pubdate)>=julianday($%d)", len(args)))
+	}
+	if len(conds) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// SourceCounts returns the number of stored press releases for each source.
+func (store *SQLiteStore) SourceCounts() (map[string]int, error) {
+	rows, err := store.db.Query("SELECT source,COUNT(*) FROM press_release GROUP BY source")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var source string
+		var n int
+		if err := rows.Scan(&source, &n); err != nil {
+			return nil, err
+		}
+		counts[source] = n
+	}
+	return counts, rows.Err()
+}
+
+// Prune deletes press releases stashed more than maxAge ago, and returns the
+// number of press releases removed.
+// Goes by stash time rather than pubdate, so it's always the oldest event ids
+// which get dropped - clients catching up via last-event-id won't end up with
+// holes in the middle of their replay.
+func (store *SQLiteStore) Prune(maxAge time.Duration) (int, error) {
+	cutoff := time.Now().UTC().Add(-maxAge)
+	res, err := store.db.Exec("DELETE FROM press_release WHERE stashed<$1", cutoff)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(n), nil
+}
+
+// Vacuum rebuilds the database file, to reclaim the space left by deleted
+// press releases. It needs exclusive access, so writes will block while
+// it's running.
+func (store *SQLiteStore) Vacuum() error {
+	_, err := store.db.Exec("VACUUM")
+	return err
+}
+
+// Stats reports the number of press releases for each source, and the size
+// of the database.
+func (store *SQLiteStore) Stats() (StoreStats, error) {
+	counts, err := store.SourceCounts()
+	if err != nil {
+		return StoreStats{}, err
+	}
+	var pageCount, pageSize int64
+	err = store.db.QueryRow("PRAGMA page_count").Scan(&pageCount)
+	if err != nil {
+		return StoreStats{}, err
+	}
+	err = store.db.QueryRow("PRAGMA page_size").Scan(&pageSize)
+	if err != nil {
+		return StoreStats{}, err
+	}
+	lastIDs, err := store.lastIDs()
+	if err != nil {
+		return StoreStats{}, err
+	}
+	return StoreStats{Counts: counts, Bytes: pageCount * pageSize, LastIDs: lastIDs}, nil
+}
+
+// lastIDs returns the id of the latest press release from each source
+func (store *SQLiteStore) lastIDs() (map[string]int, error) {
+	rows, err := store.db.Query("SELECT source,MAX(id) FROM press_release GROUP BY source")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make(map[string]int)
+	for rows.Next() {
+		var source string
+		var id int
+		if err := rows.Scan(&source, &id); err != nil {
+			return nil, err
+		}
+		ids[source] = id
+	}
+	return ids, rows.Err()
+}
+
+// Close shuts down the store, once it's finished with.
+func (store *SQLiteStore) Close() error {
+	return store.db.Close()
+}
+
+// Get fetches a single press release by id.
+// note: source can be allChannel, to match any source
+func (store *SQLiteStore) Get(source, eventId string) (*PressRelease, error) {
+	id, err := strconv.Atoi(eventId)
+	if err != nil {
+		return nil, errBadId
+	}
+	row := store.db.QueryRow(`SELECT `+pressReleaseColumns+` FROM press_release WHERE id=$1 AND (source=$2 OR $2=$3)`, id, source, allChannel)
+	pr, err := scanPressRelease(row)
+	if err == sql.ErrNoRows {
+		return nil, errNotFound
+	}
+	return pr, err
+}
+
+// Replay to handle last-event-id catchups
+// note: channel contains the source (eg 'tesco'...) or allChannel
+// If lastEventId predates the oldest press release (ie it's been pruned),
+// everything is replayed.
+// The ids are read in batches (see -replay-batch) as the client takes them,
+// rather than all at once, and without holding a query open in between (so
+// a slow client doesn't hold up the writes). Only the press releases
+// already stashed when the replay starts are included - later ones go out
+// as new events anyway.
+func (store *SQLiteStore) Replay(channel, lastEventId string) (chan string, error) {
+	after := 0
+	// (the newest id handed out, rather than the newest still stored, to
+	// match MemStore once everything's been pruned)
+	var newest int
+	err := store.db.QueryRow("SELECT COALESCE((SELECT seq FROM sqlite_sequence WHERE name='press_release'),0)").Scan(&newest)
+	if err != nil {
+		return nil, err
+	}
+	if lastEventId != "" {
+		after, err = strconv.Atoi(lastEventId)
+		if err != nil {
+			return nil, errBadId
+		}
+		if after > newest {
+			return nil, errNotFound
+		}
+	}
+
+	ids := make(chan string)
+	go func() {
+		defer close(ids)
+		for {
+			batch, err := store.replayBatch(channel, after, newest)
+			if err != nil {
+				errorf("replaying %s: %s", channel, err)
+				return
+			}
+			for _, id := range batch {
+				ids <- strconv.Itoa(id)
+			}
+			if len(batch) < replayBatchSize() {
+				return
+			}
+			after = batch[len(batch)-1]
+		}
+	}()
+	return ids, nil
+}
+
+// replayBatch returns the next batch of ids for Replay: those on channel
+// after after, up to and including upTo
+func (store *SQLiteStore) replayBatch(channel string, after, upTo int) ([]int, error) {
+	rows, err := store.db.Query("SELECT id FROM press_release WHERE id>$1 AND id<=$2 AND (source=$3 OR $3=$4) ORDER BY id LIMIT $5", after, upTo, channel, allChannel, replayBatchSize())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var batch []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		batch = append(batch, id)
+	}
+	return batch, rows.Err()
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mustSQLite opens a fresh sqlite store for a test
+func mustSQLite(t testing.TB) *SQLiteStore {
+	store, err := NewSQLiteStore(t.TempDir() + "/prstore.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// An empty search index is built from the stored releases on opening, and
+// kept in step when they're pruned.
+func TestSearchIndex(t *testing.T) {
+	filename := t.TempDir() + "/prstore.db"
+	store, err := NewSQLiteStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !store.fts {
+		store.Close()
+		t.Skip("sqlite built without fts5")
+	}
+	for i := 1; i <= 3; i++ {
+		if _, err := store.Stash(&PressRelease{Source: "tesco", Permalink: fmt.Sprint(i), Title: "Milk", Content: "<p>milk</p>"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := store.db.Exec("DELETE FROM press_release_fts"); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	store, err = NewSQLiteStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if got, err := store.Search("milk", QueryOptions{}); err != nil || len(got) != 3 {
+		t.Errorf("got %d (%v) after reopening, want 3", len(got), err)
+	}
+	if n, err := store.Prune(-time.Hour); err != nil || n != 3 {
+		t.Fatalf("pruned %d (%v), want 3", n, err)
+	}
+	var indexed int
+	if err := store.db.QueryRow("SELECT COUNT(*) FROM press_release_fts").Scan(&indexed); err != nil {
+		t.Fatal(err)
+	}
+	if indexed != 0 {
+		t.Errorf("got %d left in the index after pruning everything", indexed)
+	}
+}
+
+// Reads and writes going on at once don't block each other, or fail.
+func TestConcurrentReadWrite(t *testing.T) {
+	store := mustSQLite(t)
+	var mode string
+	if err := store.db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil || mode != "wal" {
+		t.Fatalf("got journal mode %q (%v), want wal", mode, err)
+	}
+	for i := 0; i < 50; i++ {
+		if _, err := store.Stash(&PressRelease{Title: "x", Source: "tesco", Permalink: fmt.Sprint(i), PubDate: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// (with a read held open part way through all the while)
+	rows, err := store.db.Query("SELECT id FROM press_release")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	rows.Next()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 400)
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := store.Stash(&PressRelease{Title: "x", Source: "tesco", Permalink: fmt.Sprintf("%d/%d", w, i), PubDate: time.Now()}); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if _, err := store.Query(QueryOptions{Limit: 10}); err != nil {
+					errs <- err
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(20 * time.Second):
+		t.Fatal("reads and writes held each other up")
+	}
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if n, err := store.Count(QueryOptions{}); err != nil || n != 250 {
+		t.Errorf("got %d stored (%v), want 250", n, err)
+	}
+}
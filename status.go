@@ -0,0 +1,118 @@
+package main
+
+// Per-source scraping status, for monitoring. Served up as json at /status,
+// alongside a bare-bones /healthz for load balancers.
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// statusTracker keeps track of how each source's scraping is going
+type statusTracker struct {
+	sync.Mutex
+	started     time.Time
+	lastSuccess map[string]time.Time
+	lastError   map[string]string
+	lastErrorAt map[string]time.Time
+}
+
+// sourceStatus is the reported status of a single source
+type sourceStatus struct {
+	Name        string     `json:"name"`
+	Healthy     bool       `json:"healthy"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastErrorAt *time.Time `json:"last_error_at,omitempty"`
+	Count       int        `json:"count"`   // number of releases in the store
+	LastID      int        `json:"last_id"` // id of the latest one (0 if none)
+}
+
+var scrapeStatus = newStatusTracker()
+
+func newStatusTracker() *statusTracker {
+	return &statusTracker{
+		started:     time.Now(),
+		lastSuccess: make(map[string]time.Time),
+		lastError:   make(map[string]string),
+		lastErrorAt: make(map[string]time.Time),
+	}
+}
+
+// success records a successful scraping run for a source
+func (st *statusTracker) success(source string) {
+	st.Lock()
+	defer st.Unlock()
+	st.lastSuccess[source] = time.Now()
+}
+
+// failure records a failed scraping run for a source
+func (st *statusTracker) failure(source string, err error) {
+	st.Lock()
+	defer st.Unlock()
+	st.lastError[source] = err.Error()
+	st.lastErrorAt[source] = time.Now()
+}
+
+// lastSuccessAt returns the time of the last successful scraping run for a
+// source (ok is false if there hasn't been one)
+func (st *statusTracker) lastSuccessAt(source string) (t time.Time, ok bool) {
+	st.Lock()
+	defer st.Unlock()
+	t, ok = st.lastSuccess[source]
+	return
+}
+
+// report returns the status of each source in staleAfter, sorted by name.
+// A source is unhealthy if it hasn't been successfully scraped within its
+// staleAfter (the clock starts when the tracker is created, so sources
+// aren't marked unhealthy before they've had a chance to run).
+func (st *statusTracker) report(staleAfter map[string]time.Duration, counts, lastIDs map[string]int) []sourceStatus {
+	st.Lock()
+	defer st.Unlock()
+	now := time.Now()
+	out := []sourceStatus{}
+	for name := range staleAfter {
+		status := sourceStatus{Name: name, Count: counts[name], LastID: lastIDs[name]}
+		since := st.started
+		if t, ok := st.lastSuccess[name]; ok {
+			status.LastSuccess = &t
+			since = t
+		}
+		if t, ok := st.lastErrorAt[name]; ok {
+			status.LastError = st.lastError[name]
+			status.LastErrorAt = &t
+		}
+		status.Healthy = now.Sub(since) <= staleAfter[name]
+		out = append(out, status)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// healthzHandler just says we're up
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.Write([]byte("ok\n"))
+}
+
+// statusHandler serves up the status of each source (under "sources"),
+// and the store stats (under "store"), as json.
+// Sources are flagged as unhealthy if they've gone too long without a
+// successful scrape (see liveScrapers.staleAfter).
+func statusHandler(store Store, live *liveScrapers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		stats, err := store.Stats()
+		if err != nil {
+			errorf("getting store stats: %s", err)
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, struct {
+			Sources []sourceStatus `json:"sources"`
+			Store   StoreStats     `json:"store"`
+		}{scrapeStatus.report(live.staleAfter(), stats.Counts, stats.LastIDs), stats})
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestStatusReport(t *testing.T) {
+	st := newStatusTracker()
+	st.started = time.Now().Add(-time.Hour)
+	st.success("fresh")
+	st.lastSuccess["stale"] = time.Now().Add(-time.Hour)
+	st.failure("stale", errors.New("index page borked"))
+	staleAfter := map[string]time.Duration{"fresh": 30 * time.Minute, "stale": 30 * time.Minute, "never": 30 * time.Minute}
+
+	report := st.report(staleAfter, map[string]int{"fresh": 3}, map[string]int{"fresh": 12})
+	if len(report) != 3 {
+		t.Fatalf("got %d sources, want 3", len(report))
+	}
+	// (sorted by name)
+	fresh, never, stale := report[0], report[1], report[2]
+	if fresh.Name != "fresh" || !fresh.Healthy || fresh.Count != 3 || fresh.LastID != 12 || fresh.LastSuccess == nil {
+		t.Errorf("got %+v for fresh", fresh)
+	}
+	if never.Name != "never" || never.Healthy || never.LastSuccess != nil {
+		t.Errorf("got %+v for one which has never run", never)
+	}
+	if stale.Name != "stale" || stale.Healthy || stale.LastError != "index page borked" || stale.LastErrorAt == nil {
+		t.Errorf("got %+v for stale", stale)
+	}
+
+	// (sources get a chance to run before they count as unhealthy)
+	st = newStatusTracker()
+	if report := st.report(staleAfter, nil, nil); !report[0].Healthy {
+		t.Errorf("got %+v straight after starting", report[0])
+	}
+}
+
+func TestStatusHandler(t *testing.T) {
+	store := NewMemStore()
+	if _, err := store.Stash(&PressRelease{Source: "status", Permalink: "http://example.com/1"}); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	statusHandler(store, newLiveScrapers(map[string]Scraper{"status": &fakeScraper{"status"}}))(w, httptest.NewRequest("GET", "/status", nil))
+	var status struct {
+		Sources []sourceStatus
+		Store   StoreStats
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Sources) != 1 || status.Sources[0].Name != "status" || status.Sources[0].Count != 1 {
+		t.Errorf("got sources %+v", status.Sources)
+	}
+	if status.Store.Counts["status"] != 1 {
+		t.Errorf("got store stats %+v", status.Store)
+	}
+
+	w = httptest.NewRecorder()
+	healthzHandler(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != 200 || w.Body.String() != "ok\n" {
+		t.Errorf("healthz: got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -1,33 +1,116 @@
 package main
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"github.com/donovanhide/eventsource"
-	_ "github.com/mattn/go-sqlite3"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // Store manages an archive of recent press releases.
-// It also implements eventsource.Repository to allow the press releases to be
-// streamed out as server side events.
 // Can stash away press releases for multiple sources.
-type Store struct {
-	db *sql.DB
+// (see storeRepository for streaming them out as server side events)
+type Store interface {
+	// Get fetches a single press release by id (which is also its event id).
+	// source can be allChannel, to match any source.
+	// Returns errNotFound if there's no such press release, or errBadId if
+	// the id is malformed.
+	Get(source, id string) (*PressRelease, error)
+	// Replay returns the ids of the press releases on a channel after
+	// lastEventId, in order (or all of them, if lastEventId is empty).
+	// An id older than anything in the store (eg "0", or one that's been
+	// pruned) replays everything. Returns errBadId if lastEventId is
+	// malformed, or errNotFound if it's newer than any id the store has
+	// handed out. So on a new, empty store any id but "0" is errNotFound,
+	// but not on one which has been pruned empty.
+	// The ids should be read from the store a batch at a time (see
+	// replayBatchSize) as they're taken, so a big backlog isn't all held in
+	// memory for each client catching up.
+	Replay(channel, lastEventId string) (chan string, error)
+	// returns a list of press releases with the ones already in the store culled out
+	// With -dedup, near-duplicates of stored press releases (going by
+	// their simhashes) are culled too, with duplicateOf set.
+	WhichAreNew(incoming []*PressRelease) ([]*PressRelease, error)
+	// LinkDuplicate records url as a near-duplicate of the stored press
+	// release id (see -dedup), so it counts as already stored from then on.
+	// Returns errNotFound if there's no such press release.
+	LinkDuplicate(id int, url string) error
+	// Stash adds a press release into the store. If there's already one
+	// from the same source with the same permalink (or content hash), it's
+	// left alone and errAlreadyStashed is returned, so retries and
+	// overlapping runs don't end up with duplicates.
+	Stash(pr *PressRelease) (*pressReleaseEvent, error)
+	// Lookup finds the stored press release for a url (its permalink,
+	// where that ended up, or its canonical url). Returns errNotFound if
+	// there isn't one.
+	Lookup(source, url string) (*pressReleaseEvent, error)
+	// Update replaces a stored press release with a newer revision of it
+	// (keeping the old one, for the record). The publication date is left
+	// alone, and LastModified is set (on pr too). Returns errNotFound if
+	// there's no such press release.
+	Update(id int, pr *PressRelease) (*pressReleaseEvent, error)
+	// Query fetches press releases from the store, most recently stashed first.
+	Query(opts QueryOptions) ([]*PressRelease, error)
+	// Count returns the number of press releases which Query would return
+	// for opts (ignoring Limit and Offset).
+	Count(opts QueryOptions) (int, error)
+	// Search fetches press releases matching a search query (see
+	// parseSearchQuery), most recently stashed first. opts narrows things
+	// down further. Returns errNoSearch if searching isn't supported.
+	Search(q string, opts QueryOptions) ([]*PressRelease, error)
+	// SourceCounts returns the number of stored press releases for each source.
+	SourceCounts() (map[string]int, error)
+	// Prune deletes press releases stashed more than maxAge ago, and returns
+	// the number of press releases removed.
+	Prune(maxAge time.Duration) (int, error)
+	// Vacuum reclaims any space left over from deleted press releases.
+	Vacuum() error
+	// Stats reports on the size of the store.
+	Stats() (StoreStats, error)
+	// Close shuts down the store, once it's finished with.
+	Close() error
 }
 
+var (
+	errNotFound = errors.New("press release not found")
+	errBadId    = errors.New("bad press release id")
+	errNoSearch = errors.New("search not available")
+	// returned by Stash for a press release which is already stored
+	errAlreadyStashed = errors.New("press release already stashed")
+)
+
+// allChannel is the eventsource channel which carries the press releases
+// from every source. Event ids are global, so they're ordered across
+// sources on this channel too.
+const allChannel = "all"
+
 // pressReleaseEvent wraps up a PressRelease for use as a server-sent event.
+// If updated is set, it's an "updated" event for a press release which has
+// already been sent out.
 type pressReleaseEvent struct {
 	payload *PressRelease
 	id      int
+	updated bool
 }
 
+// Updated events go out without an id, so they don't wind back clients'
+// last-event-ids (the release is matched up by permalink instead).
 func (ev *pressReleaseEvent) Id() string {
+	if ev.updated {
+		return ""
+	}
 	return strconv.Itoa(ev.id)
 }
 
+// Event types are "updated" for updated press releases, and -new-event
+// (default "new") for the rest.
 func (ev *pressReleaseEvent) Event() string {
-	return "press_release"
+	if ev.updated {
+		return "updated"
+	}
+	return *newEventFlag
 }
 
 func (ev *pressReleaseEvent) Data() string {
@@ -35,112 +118,199 @@ func (ev *pressReleaseEvent) Data() string {
 	return string(out)
 }
 
-func NewStore(dbfile string) *Store {
-	store := new(Store)
-	db, err := sql.Open("sqlite3", dbfile)
-	if err != nil {
-		panic(err)
-	}
-	store.db = db
-
-	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS press_release (
-         id INTEGER PRIMARY KEY,
-         title TEXT NOT NULL,
-         source TEXT NOT NULL,
-         permalink TEXT NOT NULL,
-         pubdate DATETIME NOT NULL,
-         content TEXT NOT NULL )`)
-	if err != nil {
-		panic(err)
+// changeEvent is sent out on the updated channels (see updatedChannel) when
+// a stored press release changes, with what it looked like before and
+// after. Like the "updated" events on the main streams, it has no id.
+type changeEvent struct {
+	id          int
+	old, latest *PressRelease
+}
+
+// releaseVersion is the json for one side of a changeEvent
+type releaseVersion struct {
+	Title        string    `json:"title"`
+	Excerpt      string    `json:"excerpt"`
+	Author       string    `json:"author"`
+	ImageURL     string    `json:"image_url"`
+	Tags         []string  `json:"tags"`
+	ContentHash  string    `json:"content_hash"`
+	LastModified time.Time `json:"last_modified"`
+}
+
+func newReleaseVersion(pr *PressRelease) releaseVersion {
+	return releaseVersion{
+		Title:        pr.Title,
+		Excerpt:      pr.Excerpt,
+		Author:       pr.Author,
+		ImageURL:     pr.ImageURL,
+		Tags:         pr.Tags,
+		ContentHash:  pr.ContentHash,
+		LastModified: pr.LastModified,
 	}
+}
 
-	return store
+func (ev *changeEvent) Id() string    { return "" }
+func (ev *changeEvent) Event() string { return "updated" }
+
+func (ev *changeEvent) Data() string {
+	out, _ := json.Marshal(struct {
+		ID        int            `json:"id"`
+		Source    string         `json:"source"`
+		Permalink string         `json:"permalink"`
+		Old       releaseVersion `json:"old"`
+		New       releaseVersion `json:"new"`
+	}{ev.id, ev.latest.Source, ev.latest.Permalink, newReleaseVersion(ev.old), newReleaseVersion(ev.latest)})
+	return string(out)
 }
 
-// returns a list of press releases with the ones already in the store culled out
-func (store *Store) WhichAreNew(incoming []*PressRelease) []*PressRelease {
-	var unseen []*PressRelease
-	// should really just use a single sql query ("WHERE permalink IN (...)" but hey.
-	for _, pr := range incoming {
-		var id int64
-		err := store.db.QueryRow("SELECT id FROM press_release WHERE permalink=$1 AND source=$2", pr.Permalink, pr.Source).Scan(&id)
-		switch err {
-		case nil:
-			// it's already in db
-		case sql.ErrNoRows:
-			// it's a new one
-			unseen = append(unseen, pr)
-		default:
-			panic(err)
+// updatedChannel returns the name of the eventsource channel carrying just
+// the changes to stored press releases from a channel (a source, or
+// allChannel).
+func updatedChannel(channel string) string {
+	return channel + "/updated"
+}
+
+// changeRepository is the eventsource.Repository for the updated channels.
+// The changes aren't kept anywhere, so there's no catching up to do.
+type changeRepository struct{}
+
+func (changeRepository) Get(channel, eventId string) eventsource.Event {
+	return nil
+}
+
+func (changeRepository) Replay(channel, lastEventId string) chan string {
+	ids := make(chan string)
+	close(ids)
+	return ids
+}
+
+// parseSearchQuery splits up a search query into the terms which must
+// all appear in a press release for it to match. Terms are single words, or
+// phrases in double quotes, eg: supermarket "price cut"
+func parseSearchQuery(q string) []string {
+	var terms []string
+	for i, chunk := range strings.Split(q, `"`) {
+		if i%2 == 1 {
+			// inside quotes
+			if phrase := compressSpace(chunk); phrase != "" {
+				terms = append(terms, phrase)
+			}
+			continue
 		}
+		terms = append(terms, strings.Fields(chunk)...)
 	}
-	return unseen
+	return terms
 }
 
-// Stash adds a press release into the store
-func (store *Store) Stash(pr *PressRelease) *pressReleaseEvent {
+// StoreStats describes the size of a store
+type StoreStats struct {
+	Counts map[string]int `json:"counts"` // press releases stored, per source
+	Bytes  int64          `json:"bytes"`  // size on disk (0 if not on disk)
+	// id of the latest press release stored, per source (ie the event id a
+	// fully caught-up client would resume from)
+	LastIDs map[string]int `json:"last_ids"`
+}
 
-	res, err := store.db.Exec("INSERT INTO press_release (title,source,permalink,pubdate,content) VALUES ($1,$2,$3,$4,$5)", pr.Title, pr.Source, pr.Permalink, pr.PubDate, pr.Content)
-	if err != nil {
-		panic(err)
-	}
-	id, err := res.LastInsertId()
-	if err != nil {
-		panic(err)
-	}
-	return &pressReleaseEvent{pr, int(id)}
+// QueryOptions narrows down the press releases returned by Store.Query.
+// Zero values are ignored.
+type QueryOptions struct {
+	Source string    // only press releases from this source
+	Since  time.Time // only press releases published at or after this time
+	Limit  int       // return at most this many
+	Offset int       // skip this many (for paging through results)
+	Lang   string    // only press releases in this language
+	Tag    string    // only press releases with this tag (case-insensitive)
+	// only press releases after this one (ie stashed before it), for
+	// paging through results by id rather than offset
+	AfterID int
+}
+
+// storeRepository adapts a Store into an eventsource.Repository, to allow
+// the press releases to be streamed out as server side events.
+// If lang is set, only press releases in that language are replayed (for
+// the channels made by langChannel).
+type storeRepository struct {
+	store Store
+	lang  string
 }
 
 // Get to help handle last-event-id catchups
-// note: channel contains the source (eg 'tesco'...)
-func (store *Store) Get(channel, eventId string) eventsource.Event {
-	id, err := strconv.Atoi(eventId)
-	if err != nil {
-		panic(err)
-	}
-	row := store.db.QueryRow(`SELECT title,source,permalink,pubdate,content FROM press_release WHERE id=$1 AND source=$2`, id, channel)
-	var pr PressRelease
-	if err := row.Scan(&pr.Title, &pr.Source, &pr.Permalink, &pr.PubDate, &pr.Content); err != nil {
-		panic(err) // TODO: log and return nil
+// note: channel contains the source (eg 'tesco'...) or allChannel
+func (repo storeRepository) Get(channel, eventId string) eventsource.Event {
+	channel, _ = splitLangChannel(channel)
+	pr, err := repo.store.Get(channel, eventId)
+	switch err {
+	case nil:
+	case errNotFound:
+		debugf("no event %s on %s", eventId, channel)
+		return nil
+	case errBadId:
+		warnf("bad event id '%s' on %s", eventId, channel)
+		return nil
+	default:
+		errorf("fetching event %s on %s: %s", eventId, channel, err)
+		return nil
 	}
-	pr.complete = true
+	id, _ := strconv.Atoi(eventId)
+	return &pressReleaseEvent{payload: pr, id: id}
+}
 
-	return &pressReleaseEvent{&pr, id}
+// replayBatchSize returns the number of ids the stores read at a time when
+// replaying (see -replay-batch)
+func replayBatchSize() int {
+	if *replayBatch < 1 {
+		return 1
+	}
+	return *replayBatch
 }
 
 // Replay to handle last-event-id catchups
-// note: channel contains the source (eg 'tesco'...)
-func (store *Store) Replay(channel, lastEventId string) (ids chan string) {
-	var err error
-	var rows *sql.Rows
-	if lastEventId == "" {
-		// no last eventid, just replay everything
-		if rows, err = store.db.Query("SELECT id FROM press_release WHERE source=$1", channel); err != nil {
-			panic(err)
-		}
-	} else {
-		// only fetch events after lastEventId
-		id, err := strconv.Atoi(lastEventId)
-		if err != nil {
-			panic(err)
-		}
-		if rows, err = store.db.Query("SELECT id FROM press_release WHERE id>$1 AND source=$2", id, channel); err != nil {
-			panic(err)
+// If lastEventId is no good, there's no catching up to do - the client just
+// gets the new events as they come in.
+func (repo storeRepository) Replay(channel, lastEventId string) chan string {
+	channel, _ = splitLangChannel(channel)
+	ids, err := repo.store.Replay(channel, lastEventId)
+	if err != nil {
+		switch err {
+		case errBadId:
+			warnf("bad last-event-id '%s' on %s, not replaying", lastEventId, channel)
+		case errNotFound:
+			warnf("unknown last-event-id '%s' on %s, not replaying", lastEventId, channel)
+		default:
+			errorf("replaying %s from '%s': %s", channel, lastEventId, err)
 		}
+		ids = make(chan string)
+		close(ids)
+		return ids
+	}
+	if repo.lang == "" {
+		return ids
 	}
 
-	ids = make(chan string)
+	// filter out the ones in other languages
+	filtered := make(chan string)
 	go func() {
-		defer rows.Close()
-		for rows.Next() {
-			var id int
-			err := rows.Scan(&id)
-			if err != nil {
-				panic(err)
+		defer close(filtered)
+		for id := range ids {
+			pr, err := repo.store.Get(channel, id)
+			if err == nil && pr.Lang == repo.lang {
+				filtered <- id
 			}
-			ids <- strconv.Itoa(id)
 		}
-		close(ids)
 	}()
-	return ids
+	return filtered
+}
+
+// langChannel returns the name of the eventsource channel carrying just
+// the press releases in lang from a channel.
+func langChannel(channel, lang string) string {
+	return channel + ":" + lang
+}
+
+// splitLangChannel undoes langChannel (lang is empty for plain channels)
+func splitLangChannel(channel string) (string, string) {
+	if i := strings.LastIndex(channel, ":"); i >= 0 {
+		return channel[:i], channel[i+1:]
+	}
+	return channel, ""
 }
@@ -0,0 +1,817 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// replayed returns the ids a store replays after lastEventId, comma
+// separated, or the error
+func replayed(store Store, lastEventId string) string {
+	ids, err := store.Replay(allChannel, lastEventId)
+	if err != nil {
+		return err.Error()
+	}
+	var got []string
+	for id := range ids {
+		got = append(got, id)
+	}
+	return strings.Join(got, ",")
+}
+
+// Both stores should replay the same things, whatever they're given.
+func TestReplay(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		check := func(when, lastEventId, want string) {
+			t.Helper()
+			if got := replayed(store, lastEventId); got != want {
+				t.Errorf("%T %s: replaying after %q got %q, want %q", store, when, lastEventId, got, want)
+			}
+		}
+		check("empty", "", "")
+		check("empty", "0", "")
+		check("empty", "1", errNotFound.Error())
+		check("empty", "junk", errBadId.Error())
+
+		for i := 1; i <= 4; i++ {
+			if _, err := store.Stash(&PressRelease{Source: "tesco", Permalink: fmt.Sprint(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		check("stashed", "", "1,2,3,4")
+		check("stashed", "0", "1,2,3,4")
+		check("stashed", "2", "3,4")
+		check("stashed", "4", "")
+		check("stashed", "5", errNotFound.Error())
+		check("stashed", "99", errNotFound.Error())
+
+		time.Sleep(1100 * time.Millisecond)
+		if _, err := store.Stash(&PressRelease{Source: "tesco", Permalink: "5"}); err != nil {
+			t.Fatal(err)
+		}
+		if n, err := store.Prune(500 * time.Millisecond); err != nil || n != 4 {
+			t.Fatalf("%T: pruned %d (%v), want 4", store, n, err)
+		}
+		check("pruned", "2", "5")
+		check("pruned", "5", "")
+
+		time.Sleep(1100 * time.Millisecond)
+		if n, err := store.Prune(500 * time.Millisecond); err != nil || n != 1 {
+			t.Fatalf("%T: pruned %d (%v), want 1", store, n, err)
+		}
+		check("pruned empty", "3", "")
+		check("pruned empty", "5", "")
+		check("pruned empty", "6", errNotFound.Error())
+	}
+}
+
+// backdate makes out the press release with that id was stashed a while
+// ago
+func backdate(t *testing.T, store Store, id int, ago time.Duration) {
+	switch store := store.(type) {
+	case *MemStore:
+		store.Lock()
+		defer store.Unlock()
+		for _, entry := range store.entries {
+			if entry.id == id {
+				entry.stashed = time.Now().Add(-ago)
+			}
+		}
+	case *SQLiteStore:
+		_, err := store.db.Exec("UPDATE press_release SET stashed=$1 WHERE id=$2", time.Now().UTC().Add(-ago), id)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		for i := 1; i <= 3; i++ {
+			if _, err := store.Stash(&PressRelease{Title: "x", Source: "tesco", Permalink: fmt.Sprint(i), PubDate: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		backdate(t, store, 1, 48*time.Hour)
+		backdate(t, store, 2, 25*time.Hour)
+
+		n, err := store.Prune(24 * time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Errorf("%T: pruned %d, want 2", store, n)
+		}
+		// (the one left is still there, and nothing else goes next time)
+		if _, err := store.Get("tesco", "3"); err != nil {
+			t.Errorf("%T: %s", store, err)
+		}
+		if _, err := store.Get("tesco", "1"); err != errNotFound {
+			t.Errorf("%T: got %v for a pruned release, want %v", store, err, errNotFound)
+		}
+		if n, _ := store.Prune(24 * time.Hour); n != 0 {
+			t.Errorf("%T: pruned %d more, want 0", store, n)
+		}
+	}
+}
+
+func TestAllChannel(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		for i, source := range []string{"tesco", "asda", "tesco"} {
+			if _, err := store.Stash(&PressRelease{Source: source, Permalink: fmt.Sprint(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		repo := storeRepository{store: store}
+		for _, test := range []struct{ channel, lastEventId, want string }{
+			{"tesco", "", "1,3"},
+			{"asda", "", "2"},
+			{allChannel, "", "1,2,3"},
+			{allChannel, "1", "2,3"},
+			{"tesco", "1", "3"},
+		} {
+			var got []string
+			for id := range repo.Replay(test.channel, test.lastEventId) {
+				got = append(got, id)
+			}
+			if strings.Join(got, ",") != test.want {
+				t.Errorf("%T: replaying %s after %q got %v, want %s", store, test.channel, test.lastEventId, got, test.want)
+			}
+		}
+
+		if ev := repo.Get(allChannel, "2"); ev == nil || ev.(*pressReleaseEvent).payload.Source != "asda" {
+			t.Errorf("%T: got %v for 2 on %s, want the asda one", store, ev, allChannel)
+		}
+		if ev := repo.Get("tesco", "2"); ev != nil {
+			t.Errorf("%T: got %v for 2 on tesco, want nothing", store, ev)
+		}
+	}
+}
+
+// countNew returns how many of prs the store says are new
+func countNew(t *testing.T, store Store, prs ...*PressRelease) int {
+	t.Helper()
+	unseen, err := store.WhichAreNew(prs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(unseen)
+}
+
+func TestContentHashDedup(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		stored := &PressRelease{Title: "Prices cut", Source: "tesco", Permalink: "http://example.com/1", Content: "<p>Prices  cut</p>", PubDate: time.Now()}
+		stored.ContentHash = contentHash(stored)
+		if _, err := store.Stash(stored); err != nil {
+			t.Fatal(err)
+		}
+
+		// the same release under another url, give or take some whitespace
+		moved := &PressRelease{Title: " Prices cut", Source: "tesco", Permalink: "http://example.com/2", Content: "<p>Prices cut</p>"}
+		if n := countNew(t, store, moved); n != 1 {
+			t.Errorf("%T: before hashing, got %d new, want 1", store, n)
+		}
+		moved.ContentHash = contentHash(moved)
+		if moved.ContentHash != stored.ContentHash {
+			t.Errorf("%T: whitespace changed the hash", store)
+		}
+		if n := countNew(t, store, moved); n != 0 {
+			t.Errorf("%T: same content, got %d new, want 0", store, n)
+		}
+		// (the hash only counts within a source)
+		elsewhere := *moved
+		elsewhere.Source = "asda"
+		if n := countNew(t, store, &elsewhere); n != 1 {
+			t.Errorf("%T: same content from another source, got %d new, want 1", store, n)
+		}
+
+		different := &PressRelease{Title: "Prices up", Source: "tesco", Permalink: "http://example.com/3", Content: "<p>Prices up</p>"}
+		different.ContentHash = contentHash(different)
+		if n := countNew(t, store, different); n != 1 {
+			t.Errorf("%T: new content, got %d new, want 1", store, n)
+		}
+		if _, err := store.Stash(moved); err != errAlreadyStashed {
+			t.Errorf("%T: stashing the same content again got %v, want %v", store, err, errAlreadyStashed)
+		}
+	}
+}
+
+// The two stores should behave just the same.
+func TestStores(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		for i, source := range []string{"tesco", "asda", "tesco"} {
+			_, err := store.Stash(&PressRelease{Title: fmt.Sprint(i + 1), Source: source, Permalink: fmt.Sprintf("http://example.com/%d", i+1), PubDate: time.Date(2014, 1, i+1, 0, 0, 0, 0, time.UTC)})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		for _, test := range []struct {
+			opts QueryOptions
+			want string
+		}{
+			// (most recently stashed first)
+			{QueryOptions{}, "[3 2 1]"},
+			{QueryOptions{Source: "tesco"}, "[3 1]"},
+			{QueryOptions{Limit: 1, Offset: 1}, "[2]"},
+			{QueryOptions{Since: time.Date(2014, 1, 2, 0, 0, 0, 0, time.UTC)}, "[3 2]"},
+			{QueryOptions{Source: "sainsburys"}, "[]"},
+		} {
+			prs, err := store.Query(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, pr := range prs {
+				got = append(got, pr.Title)
+			}
+			if fmt.Sprint(got) != test.want {
+				t.Errorf("%T: querying %+v got %v, want %s", store, test.opts, got, test.want)
+			}
+		}
+
+		if pr, err := store.Get("asda", "2"); err != nil || pr.Permalink != "http://example.com/2" {
+			t.Errorf("%T: got %v (%v) for asda 2", store, pr, err)
+		}
+		if _, err := store.Get("tesco", "2"); err != errNotFound {
+			t.Errorf("%T: got %v for 2 from the wrong source, want %v", store, err, errNotFound)
+		}
+		if _, err := store.Get("tesco", "x"); err != errBadId {
+			t.Errorf("%T: got %v for a bad id, want %v", store, err, errBadId)
+		}
+
+		if n := countNew(t, store, &PressRelease{Source: "tesco", Permalink: "http://example.com/1"}, &PressRelease{Source: "tesco", Permalink: "http://example.com/9"}); n != 1 {
+			t.Errorf("%T: got %d new, want 1", store, n)
+		}
+		counts, err := store.SourceCounts()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if counts["tesco"] != 2 || counts["asda"] != 1 {
+			t.Errorf("%T: got counts %v", store, counts)
+		}
+	}
+}
+
+// roundTrip stashes pr, and fetches it back out again
+func roundTrip(t *testing.T, store Store, pr *PressRelease) *PressRelease {
+	t.Helper()
+	ev, err := store.Stash(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get(pr.Source, fmt.Sprint(ev.id))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestNotesStored(t *testing.T) {
+	page := `<h1>Title</h1><div id="content"><p>Body text.</p><p><b>ENDS</b></p><p><strong>Notes to editors</strong></p><ul><li>one</li></ul></div>`
+	pr := &PressRelease{Source: "notes", Permalink: "http://example.com/1"}
+	spec := ScrapeSpec{Title: []string{"h1"}, Content: []string{"#content"}}
+	if err := spec.Scrape("notes", pr, page); err != nil {
+		t.Fatal(err)
+	}
+	want := `<div><p><strong>Notes to editors</strong></p><ul><li>one</li></ul></div>`
+	if pr.Notes != want {
+		t.Fatalf("got notes %q, want %q", pr.Notes, want)
+	}
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		if got := roundTrip(t, store, pr); got.Notes != want || got.Content != pr.Content {
+			t.Errorf("%T: got notes %q, content %q back", store, got.Notes, got.Content)
+		}
+	}
+}
+
+// WhichAreNew copes with big batches (more than sqlite will take as
+// parameters in one go).
+func TestWhichAreNewBatch(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		var incoming []*PressRelease
+		for i := 0; i < 2000; i++ {
+			pr := &PressRelease{Source: "tesco", Permalink: fmt.Sprintf("http://example.com/%d", i), FinalURL: fmt.Sprintf("http://example.com/final/%d", i), ContentHash: fmt.Sprintf("hash%d", i)}
+			incoming = append(incoming, pr)
+			if i%3 == 0 {
+				if _, err := store.Stash(pr); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		// the same url from another source doesn't count
+		if _, err := store.Stash(&PressRelease{Source: "asda", Permalink: "http://example.com/1"}); err != nil {
+			t.Fatal(err)
+		}
+		// (2 and 4 are already there, under other urls)
+		if _, err := store.Stash(&PressRelease{Source: "tesco", Permalink: "http://example.com/other/2", ContentHash: "hash2"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Stash(&PressRelease{Source: "tesco", Permalink: "http://example.com/other/4", FinalURL: "http://example.com/final/4"}); err != nil {
+			t.Fatal(err)
+		}
+
+		unseen, err := store.WhichAreNew(incoming)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := 0
+		for i := range incoming {
+			if i%3 != 0 && i != 2 && i != 4 {
+				want++
+			}
+		}
+		if len(unseen) != want {
+			t.Errorf("%T: got %d new, want %d", store, len(unseen), want)
+		}
+		// (in their original order)
+		if len(unseen) > 0 && unseen[0].Permalink != "http://example.com/1" {
+			t.Errorf("%T: got %s first", store, unseen[0].Permalink)
+		}
+		// a link to where a stored one ended up counts as seen
+		if n := countNew(t, store, &PressRelease{Source: "tesco", Permalink: "http://example.com/final/3"}); n != 0 {
+			t.Errorf("%T: got %d new for a final url, want 0", store, n)
+		}
+	}
+}
+
+func BenchmarkWhichAreNew(b *testing.B) {
+	store := mustSQLite(b)
+	var incoming []*PressRelease
+	for i := 0; i < 2000; i++ {
+		pr := &PressRelease{Source: "tesco", Permalink: fmt.Sprintf("http://example.com/%d", i)}
+		incoming = append(incoming, pr)
+		if i%2 == 0 {
+			store.Stash(pr)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		store.WhichAreNew(incoming)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		if s, ok := store.(*SQLiteStore); ok && !s.fts {
+			if _, err := s.Search("milk", QueryOptions{}); err != errNoSearch {
+				t.Errorf("got %v searching without fts5, want %v", err, errNoSearch)
+			}
+			t.Log("sqlite built without fts5, not searching it")
+			continue
+		}
+		for _, pr := range []*PressRelease{
+			{Source: "tesco", Permalink: "http://example.com/1", Title: "Big price cut", Content: "<p>Milk is <b>cheaper</b></p>"},
+			{Source: "asda", Permalink: "http://example.com/2", Title: "Price news", Content: "<p>We cut the milk price</p>"},
+			{Source: "asda", Permalink: "http://example.com/3", Title: "Other", Content: "<p>strong words</p>"},
+		} {
+			if _, err := store.Stash(pr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, test := range []struct {
+			q, source string
+			want      int
+		}{
+			{"milk", "", 2},
+			{`"price cut"`, "", 1},
+			{"milk", "asda", 1},
+			{"cheaper", "", 1},
+			// (not the markup)
+			{"strong", "", 1},
+			{"p", "", 0},
+			{`"unbalanced`, "", 0},
+		} {
+			got, err := store.Search(test.q, QueryOptions{Source: test.source, Limit: 10})
+			if err != nil {
+				t.Errorf("%T: searching for %s: %s", store, test.q, err)
+			} else if len(got) != test.want {
+				t.Errorf("%T: searching for %s in %q got %d, want %d", store, test.q, test.source, len(got), test.want)
+			}
+		}
+	}
+}
+
+func TestVacuum(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		for i := 1; i <= 20; i++ {
+			if _, err := store.Stash(&PressRelease{Title: "x", Source: "tesco", Permalink: fmt.Sprint(i), Content: strings.Repeat("blah ", 2000)}); err != nil {
+				t.Fatal(err)
+			}
+			if i <= 15 {
+				backdate(t, store, i, 48*time.Hour)
+			}
+		}
+		if _, err := store.Stash(&PressRelease{Title: "x", Source: "asda", Permalink: "21"}); err != nil {
+			t.Fatal(err)
+		}
+		before, err := store.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if before.Counts["tesco"] != 20 || before.Counts["asda"] != 1 || before.LastIDs["tesco"] != 20 {
+			t.Errorf("%T: got stats %+v before pruning", store, before)
+		}
+
+		if _, err := store.Prune(24 * time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Vacuum(); err != nil {
+			t.Fatalf("%T: %s", store, err)
+		}
+		after, err := store.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if after.Counts["tesco"] != 5 || after.Counts["asda"] != 1 || after.LastIDs["asda"] != 21 {
+			t.Errorf("%T: got stats %+v after pruning", store, after)
+		}
+		// (only an SQLiteStore has a size on disk)
+		if _, onDisk := store.(*SQLiteStore); onDisk && (before.Bytes == 0 || after.Bytes >= before.Bytes) {
+			t.Errorf("%T: %d bytes before vacuuming, %d after", store, before.Bytes, after.Bytes)
+		}
+	}
+}
+
+func TestTagQuery(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		for _, pr := range []*PressRelease{
+			{Title: "both", Source: "tesco", Permalink: "1", Tags: []string{"Corporate", "Product News"}},
+			{Title: "product", Source: "tesco", Permalink: "2", Tags: []string{"Product News"}},
+			{Title: "none", Source: "tesco", Permalink: "3"},
+		} {
+			if _, err := store.Stash(pr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, test := range []struct {
+			tag  string
+			want []string
+		}{
+			{"", []string{"none", "product", "both"}},
+			{"corporate", []string{"both"}},
+			{"Product News", []string{"product", "both"}},
+			{"Product", nil},
+		} {
+			prs, err := store.Query(QueryOptions{Tag: test.tag})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, pr := range prs {
+				got = append(got, pr.Title)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(test.want) {
+				t.Errorf("%T %q: got %v, want %v", store, test.tag, got, test.want)
+			}
+		}
+		prs, err := store.Query(QueryOptions{Tag: "corporate"})
+		if err != nil || len(prs) != 1 || fmt.Sprintf("%q", prs[0].Tags) != `["Corporate" "Product News"]` {
+			t.Errorf("%T: tags didn't round trip: %v %v", store, prs, err)
+		}
+	}
+}
+
+// (the Event and Id are what go out on the wire as the event: and id:
+// fields)
+func TestEventTypes(t *testing.T) {
+	old := *newEventFlag
+	t.Cleanup(func() { *newEventFlag = old })
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		*newEventFlag = "new"
+		ev, err := store.Stash(&PressRelease{Source: "tesco", Permalink: "http://example.com/1", Title: "One", Content: "one"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Event() != "new" || ev.Id() != fmt.Sprint(ev.id) {
+			t.Errorf("%T: stashed release has event %q, id %q", store, ev.Event(), ev.Id())
+		}
+		// (replayed ones too)
+		if got := (storeRepository{store: store}).Get("tesco", ev.Id()); got == nil || got.Event() != "new" {
+			t.Errorf("%T: got %v replayed", store, got)
+		}
+
+		up, err := store.Update(ev.id, &PressRelease{Source: "tesco", Permalink: "http://example.com/1", Title: "One", Content: "two"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if up.Event() != "updated" || up.Id() != "" {
+			t.Errorf("%T: updated release has event %q, id %q", store, up.Event(), up.Id())
+		}
+		change := &changeEvent{id: ev.id, old: ev.payload, latest: up.payload}
+		if change.Event() != "updated" || change.Id() != "" {
+			t.Errorf("change has event %q, id %q", change.Event(), change.Id())
+		}
+
+		// (-new-event="" leaves new ones unnamed, for old clients)
+		*newEventFlag = ""
+		if ev.Event() != "" || up.Event() != "updated" {
+			t.Errorf("%T: got events %q and %q with no -new-event", store, ev.Event(), up.Event())
+		}
+	}
+}
+
+func TestCount(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		early := time.Date(2014, 1, 1, 0, 0, 0, 0, time.UTC)
+		late := time.Date(2014, 6, 1, 0, 0, 0, 0, time.UTC)
+		for i, pr := range []*PressRelease{
+			{Source: "tesco", PubDate: early, Lang: "en"},
+			{Source: "tesco", PubDate: late, Lang: "cy"},
+			{Source: "asda", PubDate: late, Lang: "en"},
+		} {
+			pr.Title = "x"
+			pr.Permalink = fmt.Sprint(i)
+			if _, err := store.Stash(pr); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, test := range []struct {
+			opts QueryOptions
+			want int
+		}{
+			{QueryOptions{}, 3},
+			{QueryOptions{Source: "tesco"}, 2},
+			{QueryOptions{Since: late}, 2},
+			{QueryOptions{Lang: "en"}, 2},
+			{QueryOptions{Source: "tesco", Since: late}, 1},
+			// (Limit and Offset don't come into it)
+			{QueryOptions{Limit: 1, Offset: 1}, 3},
+			{QueryOptions{Source: "sainsburys"}, 0},
+		} {
+			n, err := store.Count(test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != test.want {
+				t.Errorf("%T %+v: got %d, want %d", store, test.opts, n, test.want)
+			}
+		}
+	}
+}
+
+func TestAuthorStored(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		got := roundTrip(t, store, &PressRelease{Source: "tesco", Permalink: "http://example.com/1", Author: "Jane Smith"})
+		if got.Author != "Jane Smith" {
+			t.Errorf("%T: got author %q", store, got.Author)
+		}
+		if out, err := json.Marshal(got); err != nil || !strings.Contains(string(out), `"Author":"Jane Smith"`) {
+			t.Errorf("%T: got %s (%v)", store, out, err)
+		}
+	}
+}
+
+func TestConcurrentStashIDs(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		var mu sync.Mutex
+		got := make(map[string][]int)
+		var wg sync.WaitGroup
+		for s := 0; s < 4; s++ {
+			for i := 0; i < 25; i++ {
+				wg.Add(1)
+				go func(s, i int) {
+					defer wg.Done()
+					source := fmt.Sprintf("source%d", s)
+					pr := &PressRelease{Title: "x", Source: source, Permalink: fmt.Sprintf("%d/%d", s, i), PubDate: time.Now(), Content: "content"}
+					// (as in publish, which keeps the ids and the broadcasts
+					// in the same order)
+					publishLock.Lock()
+					defer publishLock.Unlock()
+					ev, err := store.Stash(pr)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					mu.Lock()
+					got[source] = append(got[source], ev.id)
+					mu.Unlock()
+				}(s, i)
+			}
+		}
+		wg.Wait()
+
+		seen := make(map[int]bool)
+		for source, ids := range got {
+			for i, id := range ids {
+				if seen[id] {
+					t.Errorf("%T: id %d used twice", store, id)
+				}
+				seen[id] = true
+				if i > 0 && id <= ids[i-1] {
+					t.Errorf("%T %s: ids out of order: %v", store, source, ids)
+					break
+				}
+			}
+		}
+		if len(seen) != 100 {
+			t.Errorf("%T: got %d ids, want 100", store, len(seen))
+		}
+		stats, err := store.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for source, ids := range got {
+			if want := ids[len(ids)-1]; stats.LastIDs[source] != want {
+				t.Errorf("%T %s: got last id %d, want %d", store, source, stats.LastIDs[source], want)
+			}
+		}
+	}
+}
+
+func TestIDsNotReusedAfterPrune(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		first, err := store.Stash(&PressRelease{Title: "x", Source: "tesco", Permalink: "1", PubDate: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// (everything goes, so there's no highest id left to count on)
+		if n, err := store.Prune(-time.Hour); err != nil || n != 1 {
+			t.Fatalf("%T: pruned %d, %v", store, n, err)
+		}
+		second, err := store.Stash(&PressRelease{Title: "x", Source: "tesco", Permalink: "2", PubDate: time.Now()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if second.id <= first.id {
+			t.Errorf("%T: got id %d after %d was pruned", store, second.id, first.id)
+		}
+	}
+}
+
+func TestStashIdempotent(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		pr := &PressRelease{Title: "x", Source: "tesco", Permalink: "http://example.com/1", PubDate: time.Now(), Content: "<p>x</p>"}
+		if _, err := store.Stash(pr); err != nil {
+			t.Fatal(err)
+		}
+		again := *pr
+		again.Title = "y"
+		if _, err := store.Stash(&again); err != errAlreadyStashed {
+			t.Errorf("%T: stashing the same permalink again got %v, want %v", store, err, errAlreadyStashed)
+		}
+		// (but it's a different release from another source)
+		again.Source = "asda"
+		if _, err := store.Stash(&again); err != nil {
+			t.Errorf("%T: %s", store, err)
+		}
+		prs, err := store.Query(QueryOptions{Source: "tesco"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(prs) != 1 || prs[0].Title != "x" {
+			t.Errorf("%T: got %d releases, want just the first", store, len(prs))
+		}
+	}
+}
+
+func TestReplayBatches(t *testing.T) {
+	old := *replayBatch
+	*replayBatch = 7
+	defer func() { *replayBatch = old }()
+	memStore, sqliteStore := NewMemStore(), mustSQLite(t)
+	for _, store := range []Store{memStore, sqliteStore} {
+		for i := 1; i <= 2000; i++ {
+			source := "tesco"
+			if i%4 == 0 {
+				source = "asda"
+			}
+			if _, err := store.Stash(&PressRelease{Title: "x", Source: source, Permalink: fmt.Sprint(i), PubDate: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, test := range []struct {
+			channel string
+			want    int
+		}{
+			{allChannel, 2000},
+			{"asda", 500},
+		} {
+			ids, err := store.Replay(test.channel, "0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			n, last := 0, 0
+			for s := range ids {
+				id, _ := strconv.Atoi(s)
+				if id <= last {
+					t.Fatalf("%T %s: got %d after %d", store, test.channel, id, last)
+				}
+				last = id
+				n++
+			}
+			if n != test.want {
+				t.Errorf("%T %s: got %d ids, want %d", store, test.channel, n, test.want)
+			}
+		}
+		if got := replayed(store, "1990"); got != "1991,1992,1993,1994,1995,1996,1997,1998,1999,2000" {
+			t.Errorf("%T: got %s", store, got)
+		}
+
+		// (anything stashed once a replay has started goes out live
+		// instead)
+		ids, err := store.Replay("asda", "0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		<-ids
+		if _, err := store.Stash(&PressRelease{Title: "x", Source: "asda", Permalink: "late", PubDate: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+		n := 1
+		for range ids {
+			n++
+		}
+		if n != 500 {
+			t.Errorf("%T: got %d ids, want 500 (without the late one)", store, n)
+		}
+	}
+
+	// only a batch of ids is read in at a time
+	if batch := memStore.replayBatch(allChannel, 0, 2001); len(batch) != 7 {
+		t.Errorf("got a batch of %d from the MemStore, want 7", len(batch))
+	}
+	if batch, err := sqliteStore.replayBatch(allChannel, 0, 2001); err != nil || len(batch) != 7 {
+		t.Errorf("got a batch of %d from the SQLiteStore (%v), want 7", len(batch), err)
+	}
+
+	// and the sqlite store isn't locked while a replay is held up part way
+	ids, err := sqliteStore.Replay(allChannel, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-ids
+	done := make(chan error)
+	go func() {
+		_, err := sqliteStore.Stash(&PressRelease{Title: "x", Source: "tesco", Permalink: "during", PubDate: time.Now()})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("stash held up by a replay")
+	}
+	for range ids {
+	}
+}
+
+func TestExcerptStored(t *testing.T) {
+	for _, store := range []Store{NewMemStore(), mustSQLite(t)} {
+		pr := &PressRelease{Title: "x", Source: "tesco", Permalink: "http://example.com/1", PubDate: time.Now(), Content: "<p>x</p>", Excerpt: "The summary"}
+		got := roundTrip(t, store, pr)
+		if got.Excerpt != pr.Excerpt {
+			t.Errorf("%T: got excerpt %q, want %q", store, got.Excerpt, pr.Excerpt)
+		}
+		b, err := json.Marshal(got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(b), `"Excerpt":"The summary"`) {
+			t.Errorf("%T: no excerpt in %s", store, b)
+		}
+	}
+}
+
+func TestChangeEvent(t *testing.T) {
+	old := &PressRelease{Title: "Original", Source: "tesco", Permalink: "http://example.com/1", ContentHash: "abc"}
+	latest := &PressRelease{Title: "Corrected", Source: "tesco", Permalink: "http://example.com/1", ContentHash: "def", Tags: []string{"food"}, LastModified: time.Date(2014, 3, 12, 9, 30, 0, 0, time.UTC)}
+	ev := &changeEvent{id: 7, old: old, latest: latest}
+	// (like the updated events on the main streams, they've no id, so
+	// last-event-id isn't disturbed)
+	if ev.Id() != "" || ev.Event() != "updated" {
+		t.Errorf("got id %q, event %q", ev.Id(), ev.Event())
+	}
+	var got struct {
+		ID        int    `json:"id"`
+		Source    string `json:"source"`
+		Permalink string `json:"permalink"`
+		Old, New  struct {
+			Title        string    `json:"title"`
+			Tags         []string  `json:"tags"`
+			ContentHash  string    `json:"content_hash"`
+			LastModified time.Time `json:"last_modified"`
+		}
+	}
+	if err := json.Unmarshal([]byte(ev.Data()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != 7 || got.Source != "tesco" || got.Permalink != "http://example.com/1" {
+		t.Errorf("got %s", ev.Data())
+	}
+	if got.Old.Title != "Original" || got.Old.ContentHash != "abc" || got.New.Title != "Corrected" || got.New.ContentHash != "def" || len(got.New.Tags) != 1 || !got.New.LastModified.Equal(latest.LastModified) {
+		t.Errorf("got %s", ev.Data())
+	}
+
+	// (there's nothing to catch up on)
+	if _, ok := <-(changeRepository{}).Replay(updatedChannel("tesco"), "1"); ok {
+		t.Errorf("got a change replayed")
+	}
+}
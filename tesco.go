@@ -2,11 +2,10 @@ package main
 
 import (
 	"bytes"
-	"code.google.com/p/cascadia"
 	"code.google.com/p/go.net/html"
-	//"fmt"
-	"github.com/bcampbell/fuzzytime"
+	"fmt"
 	rss "github.com/jteeuwen/go-pkg-rss"
+	"io/ioutil"
 	"net/url"
 	"regexp"
 	"strings"
@@ -24,12 +23,25 @@ func (scraper *TescoScraper) Name() string {
 	return "tesco"
 }
 
+func (scraper *TescoScraper) Meta() ScraperMeta {
+	return ScraperMeta{DisplayName: "Tesco", BaseURL: "http://www.tescoplc.com/"}
+}
+
 // fetches a list of latest press releases from tesco plc
 func (scraper *TescoScraper) FetchList() ([]*PressRelease, error) {
-	feed := rss.New(0, false, nil, nil)
 	// TODO: ensure this DOES NOT go through an http proxy!
-	// (use FetchClient)
-	err := feed.Fetch("http://www.tescoplc.com/tescoplcnews.xml", nil)
+	feedURL := "http://www.tescoplc.com/tescoplcnews.xml"
+	resp, err := politeGet(httpClient, feedURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	feed := rss.New(0, false, nil, nil)
+	err = feed.FetchBytes(feedURL, raw, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -51,7 +63,7 @@ func (scraper *TescoScraper) FetchList() ([]*PressRelease, error) {
 
 			pubDate, err := parseTime(item.PubDate)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
 			pr := PressRelease{Title: item.Title, Source: scraper.Name(), Permalink: itemURL, PubDate: pubDate}
 			docs = append(docs, &pr)
@@ -67,37 +79,45 @@ func (scraper *TescoScraper) Scrape(pr *PressRelease, raw_html string) error {
 		return err // TODO: wrap up as ScrapeError?
 	}
 
-	containerSel := cascadia.MustCompile(".pagecontent")
-	titleSel := cascadia.MustCompile(".newstitle")
-	dateSel := cascadia.MustCompile(".greydate")
-	cruftSel := cascadia.MustCompile(".sharebuttons, .greydate, .newstitle, .boilerplate")
-
 	endPat := regexp.MustCompile(`\bENDS\b`)
 
-	contentSel := cascadia.MustCompile("p, ul")
 	// :contains() not in css3 spec, but cascadia supports it
 	//	noteSepSel := cascadia.MustCompile(`strong:contains("Notes to editors:"), strong:contains("ENDS")`)
 
-	div := containerSel.MatchAll(root)[0]
+	// (an empty match, eg the js-rendered newsroom, is an error rather than
+	// a blank release)
+	div := querySelector(root, ".pagecontent")
+	if div == nil {
+		return scrapeError(ErrSelectorNotFound, pr.Permalink, fmt.Errorf("no content (.pagecontent)"))
+	}
 
 	// get the title
-	pr.Title = getTextContent(titleSel.MatchAll(div)[0])
+	title := querySelector(div, ".newstitle")
+	if title == nil {
+		return scrapeError(ErrSelectorNotFound, pr.Permalink, fmt.Errorf("no title (.newstitle)"))
+	}
+	pr.Title = getTextContent(title)
 
 	//
-	dateTxt := getTextContent(dateSel.MatchAll(div)[0])
-	pr.PubDate, err = fuzzytime.Parse(dateTxt)
+	date := querySelector(div, ".greydate")
+	if date == nil {
+		return scrapeError(ErrSelectorNotFound, pr.Permalink, fmt.Errorf("no date (.greydate)"))
+	}
+	dateTxt := getTextContent(date)
+	pr.PubDate, err = parsePubDate(dateTxt)
 	if err != nil {
 		return err
 	}
 
 	// cull out the rubbish
-	for _, cruft := range cruftSel.MatchAll(div) {
+	for _, cruft := range querySelectorAll(div, ".sharebuttons, .greydate, .newstitle, .boilerplate") {
 		cruft.Parent.RemoveChild(cruft)
 	}
+	scrubHTML(div)
 
 	// content
 	pr.Content = ""
-	for _, n := range contentSel.MatchAll(div) {
+	for _, n := range querySelectorAll(div, "p, ul") {
 		var out bytes.Buffer
 		err = html.Render(&out, n)
 		if err != nil {
@@ -110,5 +130,8 @@ func (scraper *TescoScraper) Scrape(pr *PressRelease, raw_html string) error {
 		}
 		pr.Content = pr.Content + foo + "\n"
 	}
+	if pr.Content == "" {
+		return scrapeError(ErrSelectorNotFound, pr.Permalink, fmt.Errorf("no content (p, ul)"))
+	}
 	return nil
 }
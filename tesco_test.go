@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// (just the bits of a Tesco press release page the scraper looks at)
+const tescoPage = `<html><body><div class="pagecontent">
+<div class="sharebuttons"><a href="#">Tweet</a></div>
+<h1 class="newstitle">Tesco extends price match</h1>
+<p class="greydate">12/03/2014</p>
+<p>Tesco is extending its price match to 1,000 more lines.</p>
+<ul><li>Starts today</li></ul>
+<p><strong>ENDS</strong></p>
+<p>Notes to editors</p>
+<div class="boilerplate"><p>About Tesco</p></div>
+</div></body></html>`
+
+func TestTescoScrape(t *testing.T) {
+	pr := &PressRelease{Source: "tesco", Permalink: "http://www.tescoplc.com/index.asp?pageid=17&newsid=1001"}
+	if err := NewTescoScraper().Scrape(pr, tescoPage); err != nil {
+		t.Fatal(err)
+	}
+	if pr.Title != "Tesco extends price match" {
+		t.Errorf("got title %q", pr.Title)
+	}
+	if y, m, d := pr.PubDate.Date(); y != 2014 || m != time.March || d != 12 {
+		t.Errorf("got pubdate %s", pr.PubDate)
+	}
+	if !strings.Contains(pr.Content, "1,000 more lines") || !strings.Contains(pr.Content, "Starts today") {
+		t.Errorf("content missing: %s", pr.Content)
+	}
+	// (cut off at ENDS, without the share buttons or boilerplate)
+	for _, cruft := range []string{"Tweet", "Notes to editors", "About Tesco", "12/03/2014"} {
+		if strings.Contains(pr.Content, cruft) {
+			t.Errorf("%q left in %s", cruft, pr.Content)
+		}
+	}
+}
+
+// The js-rendered newsroom (or any page missing a part) is an error, not a
+// blank release.
+func TestTescoScrapeMissing(t *testing.T) {
+	for _, test := range []struct {
+		name, page string
+	}{
+		{"js-rendered", `<html><body><div id="root"></div><script src="/newsroom.js"></script></body></html>`},
+		{"no title", strings.Replace(tescoPage, `class="newstitle"`, "", 1)},
+		{"no date", strings.Replace(tescoPage, `class="greydate"`, "", 1)},
+		{"no content", `<html><body><div class="pagecontent"><h1 class="newstitle">Title</h1><p class="greydate">12/03/2014</p></div></body></html>`},
+	} {
+		pr := &PressRelease{Source: "tesco", Permalink: "http://www.tescoplc.com/index.asp?pageid=17&newsid=1002"}
+		err := NewTescoScraper().Scrape(pr, test.page)
+		if !errors.Is(err, ErrSelectorNotFound) {
+			t.Errorf("%s: got %v, want %v", test.name, err, ErrSelectorNotFound)
+		}
+	}
+}
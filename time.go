@@ -31,6 +31,8 @@ import (
 	"time"
 )
 
+// (tweaked to take times with no zone, or a UK zone abbreviation like
+// "BST", as UK time - see ukTime - and to always return UTC)
 func parseTime(s string) (time.Time, error) {
 	formats := []string{
 		"Mon, _2 Jan 2006 15:04:05 MST",
@@ -51,9 +53,9 @@ func parseTime(s string) (time.Time, error) {
 	var t time.Time
 
 	for _, format := range formats {
-		t, e = time.Parse(format, s)
+		t, e = time.ParseInLocation(format, s, ukTime)
 		if e == nil {
-			return t, e
+			return t.UTC(), e
 		}
 	}
 
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTime(t *testing.T) {
+	for _, test := range []struct {
+		raw  string
+		want time.Time
+	}{
+		{"Thu, 12 Jun 2014 10:00:00 BST", time.Date(2014, 6, 12, 9, 0, 0, 0, time.UTC)},
+		{"Wed, 12 Mar 2014 10:00:00 GMT", time.Date(2014, 3, 12, 10, 0, 0, 0, time.UTC)},
+		{"Thu, 12 Jun 2014 10:00:00 +0000", time.Date(2014, 6, 12, 10, 0, 0, 0, time.UTC)},
+		{"2014-06-12T10:00:00+02:00", time.Date(2014, 6, 12, 8, 0, 0, 0, time.UTC)},
+	} {
+		got, err := parseTime(test.raw)
+		if err != nil {
+			t.Errorf("%q: %s", test.raw, err)
+			continue
+		}
+		if !got.Equal(test.want) || got.Location() != time.UTC {
+			t.Errorf("%q: got %s, want %s", test.raw, got, test.want)
+		}
+	}
+	if _, err := parseTime("teatime"); err == nil {
+		t.Errorf("no error for nonsense")
+	}
+}
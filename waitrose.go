@@ -16,6 +16,10 @@ func (scraper *WaitroseScraper) Name() string {
 	return "waitrose"
 }
 
+func (scraper *WaitroseScraper) Meta() ScraperMeta {
+	return ScraperMeta{DisplayName: "Waitrose", BaseURL: "http://www.waitrose.presscentre.com/"}
+}
+
 // fetches a list of latest press releases from Waitrose
 func (scraper *WaitroseScraper) FetchList() ([]*PressRelease, error) {
 	url := "http://www.waitrose.presscentre.com/content/default.aspx?NewsAreaID=2"
@@ -24,10 +28,11 @@ func (scraper *WaitroseScraper) FetchList() ([]*PressRelease, error) {
 }
 
 func (scraper *WaitroseScraper) Scrape(pr *PressRelease, raw_html string) error {
-	title := "#content h1"
-	content := "#content .main .bodyCopy"
-	// TODO: kill everything after: "-ENDS-"
-	cruft := ""
-	pubDate := "#content .date_release"
-	return GenericScrape(scraper.Name(), pr, raw_html, title, content, cruft, pubDate)
+	spec := ScrapeSpec{
+		Title:     []string{"#content h1"},
+		Content:   []string{"#content .main .bodyCopy"},
+		PubDate:   []string{"#content .date_release"},
+		EndMarker: DefaultEndMarker,
+	}
+	return spec.Scrape(scraper.Name(), pr, raw_html)
 }
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// webhook POSTs new press releases (as json) to a url (see -webhook-url),
+// for consumers which would rather not keep an event stream open.
+// Deliveries happen in the background, so a slow or broken receiver
+// doesn't hold up scraping.
+type webhook struct {
+	url    string
+	client *http.Client
+	wg     sync.WaitGroup
+}
+
+// the webhook, if -webhook-url is set
+var hook *webhook
+
+func newWebhook(url string, timeout time.Duration) *webhook {
+	return &webhook{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+// send queues up delivery of a newly-stashed press release. The event id
+// goes in the X-Event-Id header, so the receiver can match it up with the
+// event streams (or fetch it again from the api).
+func (hook *webhook) send(id string, pr *PressRelease) {
+	body, err := json.Marshal(pr)
+	if err != nil {
+		errorf("webhook: encoding %s: %s", pr.Permalink, err)
+		return
+	}
+	hook.wg.Add(1)
+	go func() {
+		defer hook.wg.Done()
+		err := hook.deliver(id, body)
+		if err != nil {
+			errorf("webhook: delivering %s: %s", pr.Permalink, err)
+			return
+		}
+		debugf("webhook: delivered %s", pr.Permalink)
+	}()
+}
+
+// deliver POSTs body to the webhook url, retrying (with backoff, as for
+// retryingGet) on 5xx responses and transient network errors.
+func (hook *webhook) deliver(id string, body []byte) error {
+	var lastErr error
+	for attempt := 0; attempt <= maxRetries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff(attempt))
+		}
+		req, err := http.NewRequest("POST", hook.url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("X-Event-Id", id)
+		resp, err := hook.client.Do(req)
+		if err != nil {
+			if !isTransient(err) {
+				return err
+			}
+			lastErr = err
+			continue
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			lastErr = &statusError{hook.url, resp.StatusCode}
+			if resp.StatusCode >= 500 {
+				continue
+			}
+			return lastErr
+		}
+		return nil
+	}
+	return lastErr
+}
+
+// wait blocks until all the deliveries underway are done with
+func (hook *webhook) wait() {
+	hook.wg.Wait()
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/donovanhide/eventsource"
+)
+
+// hookReceiver collects the press releases POSTed to it, after failing the
+// first few attempts with status
+type hookReceiver struct {
+	sync.Mutex
+	fails    int
+	status   int
+	attempts int
+	got      []PressRelease
+	ids      []string
+}
+
+func (h *hookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.Lock()
+	defer h.Unlock()
+	h.attempts++
+	if h.fails > 0 {
+		h.fails--
+		w.WriteHeader(h.status)
+		return
+	}
+	var pr PressRelease
+	if err := json.NewDecoder(r.Body).Decode(&pr); err != nil || r.Method != "POST" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	h.got = append(h.got, pr)
+	h.ids = append(h.ids, r.Header.Get("X-Event-Id"))
+}
+
+func TestWebhook(t *testing.T) {
+	tests := []struct {
+		name          string
+		fails, status int
+		delivered     bool
+		attempts      int
+	}{
+		{"ok", 0, 0, true, 1},
+		{"unavailable", 2, http.StatusServiceUnavailable, true, 3},
+		{"rejected", 1, http.StatusBadRequest, false, 1},
+	}
+	for _, test := range tests {
+		receiver := &hookReceiver{fails: test.fails, status: test.status}
+		srv := httptest.NewServer(receiver)
+		h := newWebhook(srv.URL, time.Second)
+		h.send("42", &PressRelease{Title: "Prices cut", Source: "tesco", Permalink: "http://example.com/1"})
+		h.wait()
+		srv.Close()
+		if receiver.attempts != test.attempts {
+			t.Errorf("%s: got %d attempts, want %d", test.name, receiver.attempts, test.attempts)
+		}
+		if !test.delivered {
+			if len(receiver.got) != 0 {
+				t.Errorf("%s: delivered", test.name)
+			}
+			continue
+		}
+		if len(receiver.got) != 1 || receiver.got[0].Title != "Prices cut" || receiver.ids[0] != "42" {
+			t.Errorf("%s: got %+v, ids %v", test.name, receiver.got, receiver.ids)
+		}
+	}
+}
+
+// Each new press release stashed gets sent.
+func TestWebhookFromRun(t *testing.T) {
+	setFlag(t, minContent, 0)
+	receiver := &hookReceiver{}
+	hookSrv := httptest.NewServer(receiver)
+	defer hookSrv.Close()
+	hook = newWebhook(hookSrv.URL, time.Second)
+	defer func() { hook = nil }()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer srv.Close()
+	store := NewMemStore()
+	scraper := &listScraper{fakeScraper{"hooked"}, []*PressRelease{
+		{Source: "hooked", Permalink: srv.URL + "/1"},
+		{Source: "hooked", Permalink: srv.URL + "/2"},
+	}}
+	doit(scraper, store, eventsource.NewServer())
+	// (and they're not sent again next time)
+	doit(scraper, store, eventsource.NewServer())
+	hook.wait()
+	if len(receiver.got) != 2 {
+		t.Fatalf("got %d deliveries, want 2", len(receiver.got))
+	}
+	for i, pr := range receiver.got {
+		stored, err := store.Get("hooked", receiver.ids[i])
+		if err != nil || stored.Permalink != pr.Permalink {
+			t.Errorf("delivery %d: id %s doesn't match %s", i, receiver.ids[i], pr.Permalink)
+		}
+	}
+}